@@ -0,0 +1,467 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"golang.org/x/term"
+	"stuartdd.com/gui"
+	"stuartdd.com/lib"
+	"stuartdd.com/pref"
+)
+
+//
+// Command line (non GUI) sub-commands. Dont use logData use std out!
+//
+
+const (
+	passwordFdArg = "--password-fd"
+)
+
+var (
+	stdinReader    *bufio.Reader
+	passwordReader *bufio.Reader // Set by --password-fd. Passwords are read one per line.
+
+	// Sub-command name --> Number of required args (after the sub-command)
+	dataCommandArgCount = map[string]int{
+		"get":       1,
+		"set":       2,
+		"ls":        0,
+		"rm":        1,
+		"rename":    2,
+		"add-user":  1,
+		"add-hint":  2,
+		"add-asset": 2,
+		"export":    2,
+		"report":    1,
+	}
+)
+
+func isDataCommand(cmd string) bool {
+	_, ok := dataCommandArgCount[cmd]
+	return ok
+}
+
+func dataCommandUsage() {
+	fmt.Println("  Data commands. Paths are separated by '|'. E.g. \"user|pwHints|app|userId\"")
+	fmt.Printf("     %s <configfile> get <user|group|item|field>\n", os.Args[0])
+	fmt.Printf("     %s <configfile> set <user|group|item|field> <value>\n", os.Args[0])
+	fmt.Printf("     %s <configfile> ls [user|group|item]\n", os.Args[0])
+	fmt.Printf("     %s <configfile> rm <user|group|item|field>\n", os.Args[0])
+	fmt.Printf("     %s <configfile> rename <user|group|item|field> <newName>\n", os.Args[0])
+	fmt.Printf("     %s <configfile> add-user <user>\n", os.Args[0])
+	fmt.Printf("     %s <configfile> add-hint <user> <hint>\n", os.Args[0])
+	fmt.Printf("     %s <configfile> add-asset <user> <asset>\n", os.Args[0])
+	fmt.Printf("     %s <configfile> export <user|asset> <csv|json|qif> [filter]\n", os.Args[0])
+	fmt.Printf("     %s <configfile> report <user[|asset]> [from yyyy-mm-dd] [to yyyy-mm-dd]\n", os.Args[0])
+	fmt.Printf("  Add '%s <n>' to read the password(s) from file descriptor n instead of the terminal\n", passwordFdArg)
+}
+
+//
+// Remove --password-fd <n> (or --password-fd=<n>) from the args.
+// If found passwords are read from the file descriptor one per line.
+//
+func parsePasswordFdArg(args []string) ([]string, error) {
+	out := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a != passwordFdArg && !strings.HasPrefix(a, passwordFdArg+"=") {
+			out = append(out, a)
+			continue
+		}
+		v := strings.TrimPrefix(strings.TrimPrefix(a, passwordFdArg), "=")
+		if v == "" {
+			i++
+			if i >= len(args) {
+				return nil, fmt.Errorf("'%s' requires a file descriptor number", passwordFdArg)
+			}
+			v = args[i]
+		}
+		fd, err := strconv.Atoi(v)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("'%s' value '%s' is not a valid file descriptor", passwordFdArg, v)
+		}
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+		if f == nil {
+			return nil, fmt.Errorf("'%s' file descriptor %d is invalid", passwordFdArg, fd)
+		}
+		passwordReader = bufio.NewReader(f)
+	}
+	return out, nil
+}
+
+//
+// Read a password from the --password-fd file descriptor if defined, otherwise from stdin.
+// If stdin is a terminal the password is not echoed.
+//
+func readPassword(prompt string) (string, error) {
+	if passwordReader != nil {
+		return readLine(passwordReader)
+	}
+	fmt.Print(prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	if stdinReader == nil {
+		stdinReader = bufio.NewReader(os.Stdin)
+	}
+	return readLine(stdinReader)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	s, err := r.ReadString('\n')
+	if err != nil && s == "" {
+		return "", err
+	}
+	return strings.TrimRight(s, "\r\n"), nil
+}
+
+//
+// Load the data file and decrypt it if required.
+//
+func loadDataForCommand(fileName string, backupFileDef *lib.BackupFileDef, getDataUrl, postDataUrl string, dataMapUpdated func(string, *parser.Path, error)) (*lib.FileData, *lib.JsonData, error) {
+	fd, err := lib.NewFileData(fileName, backupFileDef, getDataUrl, postDataUrl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load data file '%s'. Error: %s", fileName, err.Error())
+	}
+	fd.SetVerifier(lib.VerifyJsonData)
+	if fd.RequiresDecryption() {
+		pw, err := readPassword("-> Password: ")
+		if err != nil {
+			return nil, nil, err
+		}
+		if pw == "" {
+			return nil, nil, fmt.Errorf("password was not provided")
+		}
+		err = fd.DecryptContents([]byte(pw))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt data file '%s'", fileName)
+		}
+	}
+	jd, err := lib.NewJsonData(fd.GetContent(), dataMapUpdated)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot process data in file '%s'. Error: %s", fileName, err.Error())
+	}
+	return fd, jd, nil
+}
+
+//
+// Run one of the data commands (get, set, ls, rm, rename, add-user, add-hint, add-asset, export, report).
+// If the data is changed it is saved as it was loaded (encrypted or not) using the backup definition.
+//
+func dataCommand(cmd string, args []string, fileName string, backupFileDef *lib.BackupFileDef, getDataUrl, postDataUrl string) error {
+	if len(args) < dataCommandArgCount[cmd] {
+		return fmt.Errorf("'%s' requires %d argument(s)", cmd, dataCommandArgCount[cmd])
+	}
+	changed := ""
+	fd, jd, err := loadDataForCommand(fileName, backupFileDef, getDataUrl, postDataUrl, func(desc string, p *parser.Path, err error) {
+		if err == nil {
+			changed = desc
+		}
+	})
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case "get":
+		err = getCommand(jd, parser.NewBarPath(args[0]))
+	case "ls":
+		path := parser.NewBarPath("")
+		if len(args) > 0 {
+			path = parser.NewBarPath(args[0])
+		}
+		err = lsCommand(jd, path)
+	case "set":
+		err = setCommand(jd, parser.NewBarPath(args[0]), args[1])
+	case "rm":
+		path := parser.NewBarPath(args[0])
+		min := 1
+		if path.Len() == 3 && path.StringAt(UID_POS_TYPE) == lib.IdAssets {
+			min = -1 // Same as the GUI. Remove the assets node when the last asset is removed
+		}
+		err = jd.Remove(path, min)
+	case "rename":
+		path := parser.NewBarPath(args[0])
+		at, _ := lib.GetNodeAnnotationTypeAndName(path.StringLast())
+		name, err2 := lib.ProcessEntityName(args[1], at)
+		if err2 != nil {
+			return err2
+		}
+		err = jd.Rename(path, name)
+	case "add-user":
+		name, err2 := lib.ProcessEntityName(args[0], lib.NODE_TYPE_SL)
+		if err2 != nil {
+			return err2
+		}
+		err = jd.AddUser(name)
+	case "add-hint", "add-asset":
+		name, err2 := lib.ProcessEntityName(args[1], lib.NODE_TYPE_SL)
+		if err2 != nil {
+			return err2
+		}
+		if cmd == "add-hint" {
+			err = jd.AddHint(parser.NewBarPath(args[0]), name)
+		} else {
+			err = jd.AddAsset(parser.NewBarPath(args[0]), name)
+		}
+	case "export":
+		filter := ""
+		if len(args) > 2 {
+			filter = args[2]
+		}
+		err = exportCommand(jd, parser.NewBarPath(args[0]), args[1], filter)
+	case "report":
+		err = reportCommand(jd, parser.NewBarPath(args[0]), args[1:])
+	}
+	if err != nil {
+		return err
+	}
+	if changed != "" {
+		jd.SetDateTime()
+		fd.SetContent([]byte(jd.ToJson()))
+		return fd.StoreContentAsIs(func() {
+			fmt.Printf("-> %s. File '%s' saved\n", changed, fileName)
+		})
+	}
+	return nil
+}
+
+//
+// Print a value. If the path is not a value then print the json it contains
+//
+func getCommand(jd *lib.JsonData, path *parser.Path) error {
+	n, err := jd.FindNodeForUserDataPath(path)
+	if err != nil {
+		return fmt.Errorf("'%s' was not found in the data", path)
+	}
+	if n.IsContainer() {
+		fmt.Println(n.JsonValueIndented(4))
+	} else {
+		fmt.Println(n.String())
+	}
+	return nil
+}
+
+//
+// List the names of the items in a path. Containers are suffixed with PATH_SEP.
+// Lists (transactions) are listed one item per line.
+//
+func lsCommand(jd *lib.JsonData, path *parser.Path) error {
+	var n parser.NodeI = jd.GetUserRoot()
+	if !path.IsEmpty() {
+		var err error
+		n, err = jd.FindNodeForUserDataPath(path)
+		if err != nil {
+			return fmt.Errorf("'%s' was not found in the data", path)
+		}
+	}
+	switch n.GetNodeType() {
+	case parser.NT_OBJECT:
+		o := n.(*parser.JsonObject)
+		for _, k := range o.GetSortedKeys() {
+			if o.GetNodeWithName(k).IsContainer() {
+				fmt.Println(k + lib.PATH_SEP)
+			} else {
+				fmt.Println(k)
+			}
+		}
+	case parser.NT_LIST:
+		for _, v := range n.(parser.NodeC).GetValues() {
+			fmt.Println(v.JsonValue())
+		}
+	default:
+		fmt.Println(n.GetName())
+	}
+	return nil
+}
+
+//
+// Write the transactions of an account to std out. The filter is applied as it is in the GUI.
+// The path is user|asset (or user|assets|asset).
+//
+func exportCommand(jd *lib.JsonData, path *parser.Path, format, filter string) error {
+	if path.Len() < 2 {
+		return fmt.Errorf("'%s' must be <user|asset>", path)
+	}
+	lib.InitUserAssetsCache(jd)
+	acc, err := lib.FindUserAccount(path.StringFirst(), path.StringLast())
+	if err != nil {
+		return err
+	}
+	switch format {
+	case lib.ExportFormatQif:
+		return lib.ExportQif(os.Stdout, acc, lib.QifDateOrderDMY)
+	default:
+		return lib.ExportTransactions(os.Stdout, acc, format, filter)
+	}
+}
+
+//
+// Print the totals by category for a user's accounts or a single account.
+// The period defaults to the start of the year until today.
+//
+func reportCommand(jd *lib.JsonData, path *parser.Path, period []string) error {
+	now := time.Now()
+	dates := []time.Time{time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC), now}
+	for i := 0; i < len(period) && i < len(dates); i++ {
+		dt, err := time.Parse(lib.DATE_FORMAT_TXN, period[i])
+		if err != nil {
+			return fmt.Errorf("date '%s' must be %s", period[i], lib.DATE_FORMAT_TXN)
+		}
+		dates[i] = dt
+	}
+	lib.InitUserAssetsCache(jd)
+	user := path.StringFirst()
+	title := fmt.Sprintf("User '%s'", user)
+	accounts, err := lib.FindAllUserAccounts(user)
+	if path.Len() > 1 {
+		var acc *lib.AccountData
+		acc, err = lib.FindUserAccount(user, path.StringLast())
+		accounts = []*lib.AccountData{acc}
+		title = fmt.Sprintf("Account '%s'", path.StringLast())
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(lib.NewCategoryReport(title, accounts, dates[0], dates[1]))
+	return nil
+}
+
+//
+// Set a value. If the value does not exist and the parent is a hint or asset item then add it.
+//
+func setCommand(jd *lib.JsonData, path *parser.Path, value string) error {
+	_, err := jd.FindNodeForUserDataPath(path)
+	if err != nil {
+		if path.Len() != 4 {
+			return fmt.Errorf("'%s' was not found in the data", path)
+		}
+		at, name := lib.GetNodeAnnotationTypeAndName(path.StringLast())
+		name, err = lib.ProcessEntityName(name, at)
+		if err != nil {
+			return err
+		}
+		err = jd.AddSubItem(path.PathParent(), name, "")
+		if err != nil {
+			return err
+		}
+		path = path.PathParent().StringAppend(name)
+	}
+	return jd.SetLeafValue(path, value)
+}
+
+//
+// Change the password of the data file.
+// If the file is encrypted the current password is required.
+// The content is re-encrypted as it was loaded. Nothing else is changed.
+//
+func passwdCommand(fileName string, backupFileDef *lib.BackupFileDef, getDataUrl, postDataUrl string) error {
+	fd, err := lib.NewFileData(fileName, backupFileDef, getDataUrl, postDataUrl)
+	if err != nil {
+		return fmt.Errorf("failed to load data file '%s'. Error: %s", fileName, err.Error())
+	}
+	fd.SetVerifier(lib.VerifyJsonData)
+	if fd.RequiresDecryption() {
+		current, err := readPassword("-> Current password: ")
+		if err != nil {
+			return err
+		}
+		if current == "" {
+			return fmt.Errorf("password was not provided")
+		}
+		err = fd.DecryptContents([]byte(current))
+		if err != nil {
+			return fmt.Errorf("the current password is incorrect")
+		}
+	} else {
+		fmt.Printf("-> File '%s' is not encrypted. It will be encrypted with the new password\n", fileName)
+	}
+	newPw, err := readPassword("-> New password: ")
+	if err != nil {
+		return err
+	}
+	if newPw == "" {
+		return fmt.Errorf("new password was not provided")
+	}
+	fmt.Printf("-> Password strength: %s\n", lib.PasswordStrengthDesc(newPw))
+	confirm, err := readPassword("-> Confirm new password: ")
+	if err != nil {
+		return err
+	}
+	if newPw != confirm {
+		return fmt.Errorf("the new passwords do not match")
+	}
+	return fd.StoreContentEncrypted([]byte(newPw), func() {
+		fmt.Printf("-> Password for file '%s' has been changed\n", fileName)
+	})
+}
+
+//
+// Serve a directory of data files using the remote storage protocol. Runs until stopped.
+// Requests are logged to std out and to the serve log file if it is active.
+//	serve.dir         The directory. Default is the current directory
+//	serve.addr        The address. Default is :8080
+//	serve.token       If defined requests require 'Authorization: Bearer <token>'
+//	serve.certFile    The TLS certificate and key files. If defined https is used
+//	serve.keyFile
+//	serve.historyMax  The number of previous revisions kept for each file. Default is 10
+//
+func serveCommand(p *pref.PrefData) error {
+	logData := gui.NewLogData(
+		p.GetStringWithFallback(serveLogFileNamePrefName, "enctest-serve.log"),
+		"SERVE: ",
+		p.GetBoolWithFallback(serveLogActivePrefName, false))
+	defer logData.WaitAndClose()
+	if logData.IsWarning() {
+		fmt.Printf("-> Log file error: %s\n", logData.GetErr().Error())
+	}
+	logServe := func(l string) {
+		fmt.Printf("%s %s\n", time.Now().Format(lib.DATE_TIME_FORMAT_TXN), l)
+		if logData.IsLogging() {
+			logData.Log(l)
+		}
+	}
+	token := p.GetStringWithFallback(serveTokenPrefName, "")
+	server, err := lib.NewStorageServer(
+		p.GetStringWithFallback(serveDirPrefName, "."),
+		token,
+		int(p.GetInt64WithFallback(serveHistoryMaxPrefName, 10)),
+		logServe)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		logServe(fmt.Sprintf("WARNING: '%s' is not defined. Requests are not authenticated", serveTokenPrefName))
+	}
+	return server.ListenAndServe(
+		p.GetStringWithFallback(serveAddrPrefName, ":8080"),
+		p.GetStringWithFallback(serveCertFilePrefName, ""),
+		p.GetStringWithFallback(serveKeyFilePrefName, ""))
+}
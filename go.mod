@@ -15,6 +15,7 @@ replace stuartdd.com/pref => ./pref
 require (
 	fyne.io/fyne/v2 v2.2.1
 	github.com/stuartdd2/JsonParser4go/parser v0.0.0-20220423103514-a885cd31b1aa
+	golang.org/x/term v0.0.0-20210503060354-a79de5458b56
 	stuartdd.com/gui v0.0.0-00010101000000-000000000000
 	stuartdd.com/lib v0.0.0-00010101000000-000000000000
 	stuartdd.com/pref v0.0.0
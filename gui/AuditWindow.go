@@ -0,0 +1,76 @@
+package gui
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+type AuditWindow struct {
+	results        []*lib.AuditResult
+	selectFunction func(string, *parser.Path)
+	auditWindow    fyne.Window
+}
+
+func NewAuditWindow(selectFunction func(string, *parser.Path)) *AuditWindow {
+	return &AuditWindow{selectFunction: selectFunction, results: make([]*lib.AuditResult, 0)}
+}
+
+func (aw *AuditWindow) IsShowing() bool {
+	return aw.auditWindow != nil
+}
+
+func (aw *AuditWindow) createRow(r *lib.AuditResult) *fyne.Container {
+	c := container.NewHBox()
+	b := widget.NewButtonWithIcon("", theme.MailForwardIcon(), func() {
+		go aw.selectFunction(r.String(), r.HintPath())
+	})
+	c.Add(b)
+	c.Add(container.New(NewFixedWLayout(85), widget.NewLabel(lib.AuditIssueNames[r.Issue])))
+	c.Add(widget.NewLabel(fmt.Sprintf("%s %s [ %s ] %s", r.Path.StringFirst(), r.Path.StringAt(2), r.Path.StringLast(), r.Desc)))
+	return c
+}
+
+func (aw *AuditWindow) Show(w, h float32, results []*lib.AuditResult) {
+	aw.results = results
+	counts := make([]int, len(lib.AuditIssueNames))
+	for _, r := range results {
+		counts[r.Issue]++
+	}
+	if !aw.IsShowing() {
+		aw.auditWindow = fyne.CurrentApp().NewWindow("Password Audit")
+	}
+	vc := container.NewVBox()
+	hb := container.NewHBox()
+	hb.Add(widget.NewButtonWithIcon("Close", theme.CancelIcon(), func() {
+		aw.Close()
+	}))
+	if len(results) == 0 {
+		hb.Add(widget.NewLabel("No issues found"))
+	} else {
+		summary := fmt.Sprintf("%d issues:", len(results))
+		for i, n := range lib.AuditIssueNames {
+			summary = summary + fmt.Sprintf(" %s %d", n, counts[i])
+		}
+		hb.Add(widget.NewLabel(summary))
+	}
+	vc.Add(hb)
+	for _, r := range results {
+		vc.Add(aw.createRow(r))
+	}
+	aw.auditWindow.SetContent(container.NewScroll(vc))
+	aw.auditWindow.Resize(fyne.NewSize(w, h))
+	aw.auditWindow.Show()
+}
+
+func (aw *AuditWindow) Close() {
+	if aw.auditWindow != nil {
+		aw.auditWindow.Close()
+		aw.auditWindow = nil
+	}
+}
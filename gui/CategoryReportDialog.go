@@ -0,0 +1,66 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package gui
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"stuartdd.com/lib"
+)
+
+/*
+	Display the credit and debit totals for each category in a report.
+	Each currency has its own totals.
+*/
+func NewModalCategoryReportDialog(w fyne.Window, report *lib.CategoryReport) (modal *widget.PopUp) {
+	ts := fyne.TextStyle{Monospace: true}
+	cw := report.CategoryWidth()
+	list := container.NewVBox()
+	for i, c := range report.Currencies {
+		if i > 0 {
+			list.Add(widget.NewSeparator())
+		}
+		lines := c.Lines(cw)
+		for j, l := range lines {
+			style := ts
+			style.Bold = j == 0 || j == len(lines)-1 // Heading and total
+			list.Add(widget.NewLabelWithStyle(l, fyne.TextAlignLeading, style))
+		}
+	}
+	if report.Count == 0 {
+		list.Add(widget.NewLabelWithStyle("No transactions in the period", fyne.TextAlignLeading, ts))
+	}
+	closeDialog := func() {
+		modal.Hide()
+		w.Canvas().SetOnTypedKey(nil)
+	}
+	modal = widget.NewModalPopUp(container.NewVBox(
+		container.NewCenter(widget.NewLabel(report.Heading())),
+		widget.NewSeparator(),
+		container.New(NewFixedHLayout(600, 300), container.NewScroll(list)),
+		widget.NewSeparator(),
+		container.NewCenter(widget.NewButton("Close", closeDialog)),
+	), w.Canvas())
+	w.Canvas().SetOnTypedKey(func(ke *fyne.KeyEvent) {
+		if ke.Name == "Escape" || ke.Name == "Return" {
+			closeDialog()
+		}
+	})
+	modal.Show()
+	return modal
+}
@@ -0,0 +1,60 @@
+package gui
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+/*
+	A non-modal banner shown above the data when something needs attention
+	but the user should be able to carry on working. For example the data
+	file has been changed by another program.
+	The banner is hidden until Show is called. Each button hides the banner
+	before calling its action. Dismiss just hides it.
+*/
+type ChangeBanner struct {
+	message   *widget.Label
+	buttons   *fyne.Container
+	Container *fyne.Container
+}
+
+type BannerAction struct {
+	Label  string
+	Action func()
+}
+
+func NewChangeBanner() *ChangeBanner {
+	msg := widget.NewLabel("")
+	msg.Wrapping = fyne.TextWrapWord
+	buttons := container.NewHBox()
+	c := container.NewBorder(nil, widget.NewSeparator(), widget.NewIcon(theme.WarningIcon()), buttons, msg)
+	c.Hide()
+	return &ChangeBanner{message: msg, buttons: buttons, Container: c}
+}
+
+func (cb *ChangeBanner) Show(message string, actions ...*BannerAction) {
+	cb.message.SetText(message)
+	cb.buttons.Objects = make([]fyne.CanvasObject, 0)
+	for _, a := range actions {
+		action := a.Action
+		cb.buttons.Add(widget.NewButton(a.Label, func() {
+			cb.Hide()
+			action()
+		}))
+	}
+	cb.buttons.Add(widget.NewButton("Dismiss", func() {
+		cb.Hide()
+	}))
+	cb.Container.Show()
+	cb.Container.Refresh()
+}
+
+func (cb *ChangeBanner) Hide() {
+	cb.Container.Hide()
+}
+
+func (cb *ChangeBanner) IsShowing() bool {
+	return cb.Container.Visible()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
@@ -142,6 +143,9 @@ func (p *EditEntry) CommitEdit(data parser.NodeI) bool {
 		}
 		p.OldTxt = p.NewTxt
 		newV := m.String()
+		if newV != oldV {
+			lib.AddHistory(data, p.Path, oldV, time.Now())
+		}
 		p.ActionFunc(ACTION_LOG, p.Path, fmt.Sprintf("CommitEdit Path:%s Len:%d --> Len:%d --->%s<--+-->%s<---", p.Path, len(oldV), len(newV), oldV, newV))
 		p.RefreshData()
 		return true
@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package gui
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+const (
+	lastChangedFormat = "2006-01-02"
+)
+
+/*
+	The 'last changed' date and a button to show the change history of a field.
+	Returns nil if the field has never been changed.
+*/
+func historyControls(w fyne.Window, dataRoot parser.NodeI, path *parser.Path, title string, statusDisplay *StatusDisplay) fyne.CanvasObject {
+	changed, ok := lib.LastChanged(dataRoot, path)
+	if !ok {
+		return nil
+	}
+	hist := NewMyIconButton("", theme.HistoryIcon(), func(a, b string) {
+		NewModalHistoryDialog(w, fmt.Sprintf("Change history for '%s'", title), lib.GetHistory(dataRoot, path))
+	}, "", "", statusDisplay, fmt.Sprintf("Show the change history of '%s'", title))
+	return container.NewHBox(widget.NewLabel(fmt.Sprintf("Changed: %s", changed.Format(lastChangedFormat))), container.New(&FixedLayout{10, 1}, hist))
+}
+
+/*
+	List the previous values of a field. Newest first.
+*/
+func NewModalHistoryDialog(w fyne.Window, heading string, entries []*lib.HistoryEntry) (modal *widget.PopUp) {
+	list := container.NewVBox()
+	for i := len(entries) - 1; i >= 0; i-- {
+		list.Add(widget.NewLabel(entries[i].String()))
+	}
+	if len(entries) == 0 {
+		list.Add(widget.NewLabel("No history"))
+	}
+	closeDialog := func() {
+		modal.Hide()
+		w.Canvas().SetOnTypedKey(nil)
+	}
+	modal = widget.NewModalPopUp(container.NewVBox(
+		container.NewCenter(widget.NewLabel(heading)),
+		widget.NewSeparator(),
+		container.New(NewFixedHLayout(400, 200), container.NewVScroll(list)),
+		widget.NewSeparator(),
+		container.NewCenter(widget.NewButton("Close", closeDialog)),
+	), w.Canvas())
+	w.Canvas().SetOnTypedKey(func(ke *fyne.KeyEvent) {
+		if ke.Name == "Escape" || ke.Name == "Return" {
+			closeDialog()
+		}
+	})
+	modal.Show()
+	return modal
+}
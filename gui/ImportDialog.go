@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package gui
+
+import (
+	"fmt"
+	"sort"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+	"stuartdd.com/pref"
+)
+
+var (
+	importProfilesPrefName        = parser.NewDotPath("import.profiles")
+	importAccountProfilesPrefName = parser.NewDotPath("import.accountProfiles")
+)
+
+/*
+	The names of the import profiles defined in the preferences (sorted).
+	If none are defined the default profile name is returned.
+*/
+func GetImportProfileNames(p *pref.PrefData) []string {
+	names := make([]string, 0)
+	n, ok := p.GetDataForPath(importProfilesPrefName)
+	if ok && n.GetNodeType() == parser.NT_OBJECT {
+		for _, v := range n.(*parser.JsonObject).GetValues() {
+			if v.GetNodeType() == parser.NT_OBJECT {
+				names = append(names, v.GetName())
+			}
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, lib.DefaultImportProfileName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+	Read an import profile from the preferences.
+	Values that are not defined are taken from the fallback profile.
+*/
+func GetImportProfile(p *pref.PrefData, name string, fb *lib.ImportProfile) *lib.ImportProfile {
+	pp := importProfilesPrefName.StringAppend(name)
+	return &lib.ImportProfile{
+		Name:         name,
+		DateFormat:   p.GetStringWithFallback(pp.StringAppend("dateFormat"), fb.DateFormat),
+		Delimiter:    p.GetStringWithFallback(pp.StringAppend("delimiter"), fb.Delimiter),
+		Decimal:      p.GetStringWithFallback(pp.StringAppend("decimal"), fb.Decimal),
+		Thousands:    p.GetStringWithFallback(pp.StringAppend("thousands"), fb.Thousands),
+		Columns:      p.GetStringListWithFallback(pp.StringAppend("columns"), fb.Columns),
+		SkipHeader:   p.GetBoolWithFallback(pp.StringAppend("skipHeader"), fb.SkipHeader),
+		RefFormat:    p.GetStringWithFallback(pp.StringAppend("refFormat"), fb.RefFormat),
+		InvertAmount: p.GetBoolWithFallback(pp.StringAppend("invertAmount"), fb.InvertAmount),
+	}
+}
+
+/*
+	Store an import profile in the preferences.
+*/
+func PutImportProfile(p *pref.PrefData, profile *lib.ImportProfile) {
+	pp := importProfilesPrefName.StringAppend(profile.Name)
+	p.PutString(pp.StringAppend("dateFormat"), profile.DateFormat)
+	p.PutString(pp.StringAppend("delimiter"), profile.Delimiter)
+	p.PutString(pp.StringAppend("decimal"), profile.Decimal)
+	p.PutString(pp.StringAppend("thousands"), profile.Thousands)
+	p.PutStringList(pp.StringAppend("columns"), profile.Columns, false)
+	p.PutBool(pp.StringAppend("skipHeader"), profile.SkipHeader)
+	p.PutString(pp.StringAppend("refFormat"), profile.RefFormat)
+	p.PutBool(pp.StringAppend("invertAmount"), profile.InvertAmount)
+	p.Save()
+}
+
+/*
+	The name of the import profile last used for an account. Empty if none has been used.
+*/
+func GetAccountImportProfileName(p *pref.PrefData, user, account string) string {
+	return p.GetStringMapWithFallback(importAccountProfilesPrefName, nil)[accountProfileKey(user, account)]
+}
+
+/*
+	Remember the import profile used for an account.
+*/
+func PutAccountImportProfileName(p *pref.PrefData, user, account, name string) {
+	p.PutString(importAccountProfilesPrefName.StringAppend(accountProfileKey(user, account)), name)
+	p.Save()
+}
+
+func accountProfileKey(user, account string) string {
+	return fmt.Sprintf("%s%s%s", user, lib.PATH_SEP, account)
+}
+
+/*
+	Select the import profile to use for an account.
+	The details of the selected profile are displayed.
+	accept(ok, profileName) is called when OK or Cancel is pressed.
+*/
+func NewModalImportProfileDialog(w fyne.Window, heading string, names []string, selected string, profileDesc func(string) string, accept func(bool, string)) (modal *widget.PopUp) {
+	desc := widget.NewLabel("")
+	desc.Wrapping = fyne.TextWrapWord
+	sel := widget.NewSelect(names, func(s string) {
+		desc.SetText(profileDesc(s))
+	})
+	if selected == "" && len(names) > 0 {
+		selected = names[0]
+	}
+	sel.SetSelected(selected)
+	submit := func(ok bool) {
+		if ok && sel.Selected == "" {
+			return
+		}
+		modal.Hide()
+		w.Canvas().SetOnTypedKey(nil)
+		accept(ok, sel.Selected)
+	}
+	buttons := container.NewCenter(container.New(layout.NewHBoxLayout(), widget.NewButton("Cancel", func() {
+		submit(false)
+	}), widget.NewButton("OK", func() {
+		submit(true)
+	})))
+	modal = widget.NewModalPopUp(container.NewVBox(
+		container.NewCenter(widget.NewLabel(heading)),
+		container.NewBorder(nil, nil, widget.NewLabel("Import profile:"), nil, sel),
+		container.New(NewFixedHLayout(450, 120), desc),
+		widget.NewSeparator(),
+		buttons,
+	), w.Canvas())
+	w.Canvas().SetOnTypedKey(func(ke *fyne.KeyEvent) {
+		if ke.Name == "Escape" {
+			submit(false)
+		} else if ke.Name == "Return" {
+			submit(true)
+		}
+	})
+	modal.Show()
+	return modal
+}
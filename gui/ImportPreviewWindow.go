@@ -0,0 +1,102 @@
+package gui
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"stuartdd.com/lib"
+)
+
+/*
+	Lists the transactions read from an import file before they are added.
+	Duplicates and probable duplicates are marked and not selected.
+	Nothing is imported until the Import button is pressed.
+*/
+type ImportPreviewWindow struct {
+	candidates    []*lib.ImportCandidate
+	accept        func([]*lib.ImportCandidate)
+	summary       *widget.Label
+	previewWindow fyne.Window
+}
+
+func NewImportPreviewWindow(accept func([]*lib.ImportCandidate)) *ImportPreviewWindow {
+	return &ImportPreviewWindow{accept: accept, candidates: make([]*lib.ImportCandidate, 0)}
+}
+
+func (pw *ImportPreviewWindow) IsShowing() bool {
+	return pw.previewWindow != nil
+}
+
+func (pw *ImportPreviewWindow) createRow(c *lib.ImportCandidate) *fyne.Container {
+	row := container.NewHBox()
+	chk := widget.NewCheck("", func(b bool) {
+		c.Accept = b
+		pw.updateSummary()
+	})
+	chk.SetChecked(c.Accept)
+	row.Add(chk)
+	row.Add(container.New(NewFixedWLayout(85), widget.NewLabel(lib.ImportMatchNames[c.Match])))
+	desc := fmt.Sprintf("%s %s", c.Tx.Description(), c.Tx.TxType())
+	if c.MatchedWith != nil {
+		desc = fmt.Sprintf("%s  matches [ %s ]", desc, c.MatchedWith.Description())
+	}
+	row.Add(widget.NewLabel(desc))
+	return row
+}
+
+func (pw *ImportPreviewWindow) updateSummary() {
+	counts := make([]int, len(lib.ImportMatchNames))
+	selected := 0
+	for _, c := range pw.candidates {
+		counts[c.Match]++
+		if c.Accept {
+			selected++
+		}
+	}
+	s := fmt.Sprintf("%d rows:", len(pw.candidates))
+	for i, n := range lib.ImportMatchNames {
+		s = s + fmt.Sprintf(" %s %d", n, counts[i])
+	}
+	pw.summary.SetText(fmt.Sprintf("%s. %d selected", s, selected))
+}
+
+func (pw *ImportPreviewWindow) Show(w, h float32, title string, candidates []*lib.ImportCandidate) {
+	pw.candidates = candidates
+	if !pw.IsShowing() {
+		pw.previewWindow = fyne.CurrentApp().NewWindow(title)
+		pw.previewWindow.SetOnClosed(func() {
+			pw.previewWindow = nil
+		})
+	} else {
+		pw.previewWindow.SetTitle(title)
+	}
+	pw.summary = widget.NewLabel("")
+	vc := container.NewVBox()
+	hb := container.NewHBox()
+	hb.Add(widget.NewButtonWithIcon("Cancel", theme.CancelIcon(), func() {
+		pw.Close()
+	}))
+	hb.Add(widget.NewButtonWithIcon("Import", theme.ConfirmIcon(), func() {
+		pw.Close()
+		pw.accept(pw.candidates)
+	}))
+	hb.Add(pw.summary)
+	vc.Add(hb)
+	for _, c := range candidates {
+		vc.Add(pw.createRow(c))
+	}
+	pw.updateSummary()
+	pw.previewWindow.SetContent(container.NewScroll(vc))
+	pw.previewWindow.Resize(fyne.NewSize(w, h))
+	pw.previewWindow.Show()
+}
+
+func (pw *ImportPreviewWindow) Close() {
+	if pw.previewWindow != nil {
+		pw.previewWindow.Close()
+		pw.previewWindow = nil
+	}
+}
@@ -208,6 +208,39 @@ func (t *MyButton) MyEnable() {
 	}
 }
 
+var activityFunc func() // Called by NoteActivity. See SetOnActivity
+
+/*
+	Set the function called when the user types in an entry or scrolls the data.
+	Used to reset the idle timer that locks the data.
+*/
+func SetOnActivity(f func()) {
+	activityFunc = f
+}
+
+func NoteActivity() {
+	if activityFunc != nil {
+		activityFunc()
+	}
+}
+
+var copyFunc func(string) // Called by CopyToClipboard. See SetOnCopy
+
+/*
+	Set the function called with the value each time something is copied to the clipboard.
+	Used to clear the clipboard later.
+*/
+func SetOnCopy(f func(string)) {
+	copyFunc = f
+}
+
+func CopyToClipboard(w fyne.Window, value string) {
+	w.Clipboard().SetContent(value)
+	if copyFunc != nil {
+		copyFunc(value)
+	}
+}
+
 type StatusDisplay struct {
 	statusLabel     *widget.Label
 	updatedText     *widget.Label
@@ -216,6 +249,7 @@ type StatusDisplay struct {
 	initialText     string
 	prefix          string
 	current         string
+	onActivity      func() // Called when the user moves the mouse over a button
 }
 
 func NewStatusDisplay(initialText, updateText, prefix string) *StatusDisplay {
@@ -233,12 +267,19 @@ func (sd *StatusDisplay) SetUpdated(dt string) {
 	sd.updatedText.SetText("Last Updated: " + dt)
 }
 
+func (sd *StatusDisplay) SetOnActivity(f func()) {
+	sd.onActivity = f
+}
+
 func (sd *StatusDisplay) Reset() {
 	sd.statusStack = NewStringStack()
 	sd.PopStatus()
 }
 
 func (sd *StatusDisplay) PushStatus(m string) {
+	if sd.onActivity != nil {
+		sd.onActivity()
+	}
 	sd.statusStack.Push(sd.current)
 	sd.current = m
 	sd.statusLabel.SetText(fmt.Sprintf("%s: %s", sd.prefix, m))
@@ -62,6 +62,10 @@ const (
 	ACTION_ADD_TRANSACTION    = "addtransaction"
 	ACTION_UPDATE_TRANSACTION = "updatetransaction"
 	ACTION_IMPORT_TRANSACTION = "importtransaction"
+	ACTION_EXPORT_TRANSACTION = "exporttransaction"
+	ACTION_EXCHANGE_RATES     = "exchangerates"
+	ACTION_ADD_RECURRING      = "addrecurring"
+	ACTION_REMOVE_RECURRING   = "removerecurring"
 	ACTION_ADD_HINT_ITEM      = "addhintitem"
 	ACTION_ERROR_DIALOG       = "errorDialog"
 	ACTION_WARN_DIALOG        = "warningDialog"
@@ -71,6 +75,7 @@ var (
 	preferedOrderReversed = []string{"notes", "positional", "post", "pre", "link", "userId"}
 	EditEntryListCache    = NewEditEntryList()
 	EditMode              = false
+	baseCurrencyPrefName  = parser.NewDotPath("currency.base")
 )
 
 func NewModalEntryDialog(w fyne.Window, heading, txt string, isAnnotated bool, annotation lib.NodeAnnotationEnum, accept func(bool, string, lib.NodeAnnotationEnum)) (modal *widget.PopUp) {
@@ -112,6 +117,7 @@ func GetDetailTypeGroupTitle(selectedPath *parser.Path, preferences pref.PrefDat
 }
 
 func GetDetailPage(selectedPath *parser.Path, dataMapRoot parser.NodeI, preferences pref.PrefData, log func(string)) *DetailPage {
+	StopOtpUpdates()
 	user0 := ""
 	if selectedPath.Len() > 0 {
 		user0 = selectedPath.StringAt(0)
@@ -230,6 +236,11 @@ func assetDetailsControls(_ fyne.Window, details DetailPage, actionFunc func(str
 		actionFunc(ACTION_ADD_ASSET_ITEM, details.SelectedPath, "")
 	}, "", "", statusDisplay, fmt.Sprintf("Add new Item to %s: %s", n, details.Title)))
 
+	if EditMode {
+		cObj = append(cObj, NewMyIconButton("Rates", theme.ViewRefreshIcon(), func(a, b string) {
+			actionFunc(ACTION_EXCHANGE_RATES, details.SelectedPath, "")
+		}, "", "", statusDisplay, "Edit the exchange rates and the base currency"))
+	}
 	cObj = append(cObj, widget.NewLabel(head))
 	return container.NewHBox(cObj...)
 }
@@ -259,19 +270,30 @@ func getTransactionalCanvasObjects(actionFunc func(string, *parser.Path, string)
 	transAreCalled := lib.GetNameFromNameMap(lib.IdTxTransactions, "Transaction")
 	txList := accData.Transactions
 	refMax := 10
+	catMax := 0 // No category column unless a transaction has a category
 	for _, tx := range txList {
 		if refMax < len(tx.Ref()) {
 			refMax = len(tx.Ref())
 		}
+		if len(tx.Category()) > 0 && catMax < len(tx.Category())+1 {
+			catMax = len(tx.Category()) + 1
+		}
 	}
 	refMax++
+	if catMax > 0 && catMax < 10 {
+		catMax = 10
+	}
 	hbTop := container.NewHBox()
 	if EditMode {
 		imp := NewMyIconButton("", theme.StorageIcon(), func(a, b string) {
 			actionFunc(ACTION_IMPORT_TRANSACTION, accData.Path.PathParent(), accData.AccountName)
-		}, "", "", statusDisplay, "Import from CSV file")
+		}, "", "", statusDisplay, "Import from CSV, OFX or QIF file")
 		hbTop.Add(imp)
 	}
+	exp := NewMyIconButton("", theme.DocumentSaveIcon(), func(a, b string) {
+		actionFunc(ACTION_EXPORT_TRANSACTION, &accData.Path, accData.AccountName)
+	}, "", "", statusDisplay, "Export to CSV, JSON or QIF file")
+	hbTop.Add(exp)
 	filter := lib.GetUserAccountFilter(accData.User, accData.AccountName)
 	filterEntry := widget.NewEntry()
 	filterEntry.SetText(filter)
@@ -281,8 +303,9 @@ func getTransactionalCanvasObjects(actionFunc func(string, *parser.Path, string)
 	}
 	hbTop.Add(widget.NewLabel("Filter:"))
 	hbTop.Add(container.New(NewFixedWLayout(150), filterEntry))
-	hbTop.Add(widget.NewLabel(fmt.Sprintf(" %d %s(s). Current balance %0.2f", len(accData.Transactions), transAreCalled, accData.ClosingValue)))
+	hbTop.Add(widget.NewLabel(fmt.Sprintf(" %d %s(s). Current balance %s", len(accData.Transactions), transAreCalled, strings.TrimSpace(lib.FormatMoney(accData.ClosingValue, accData.Currency)))))
 	cObj = append(cObj, hbTop)
+	cObj = getRecurringCanvasObjects(actionFunc, cObj, accData, statusDisplay)
 	hb := container.NewHBox()
 	if EditMode {
 		add := NewMyIconButton("", theme.ContentAddIcon(), func(a, d string) {
@@ -296,6 +319,9 @@ func getTransactionalCanvasObjects(actionFunc func(string, *parser.Path, string)
 	lb := lib.NewLine(txColumMaxWidth)
 	lb.Apply("Date Time:", txDateColWidth)
 	lb.ApplyRev("Reference:", refMax)
+	if catMax > 0 {
+		lb.ApplyRev("Category:", catMax)
+	}
 	lb.ApplyRev("In:", txNumColWidth)
 	lb.ApplyRev("Out:", txNumColWidth)
 	lb.ApplyRev("Balance:", txNumColWidth)
@@ -315,31 +341,34 @@ func getTransactionalCanvasObjects(actionFunc func(string, *parser.Path, string)
 			lb.Clear()
 			lb.Apply("---- -- -- -- -- --", txDateColWidth)
 			lb.ApplyRev(tx.Ref(), refMax)
+			lb.ApplyRev(tx.Category(), catMax)
 			lb.Apply("", txNumColWidth)
 			lb.Apply("", txNumColWidth)
-			lb.ApplyRev(fmt.Sprintf("%9.2f", tx.LineValue()), txNumColWidth)
+			lb.ApplyRev(tx.LineVal(), txNumColWidth)
 			hb.Add(widget.NewLabelWithStyle(lb.String(), fyne.TextAlignLeading, ts))
 			cObj = append(cObj, hb)
 		case lib.TX_TYPE_CRE:
 			lb.Clear()
 			lb.Apply(tx.DateTime(), txDateColWidth)
 			lb.ApplyRev(tx.Ref(), refMax)
-			lb.ApplyRev(fmt.Sprintf("%9.2f", tx.Value()), txNumColWidth)
+			lb.ApplyRev(tx.Category(), catMax)
+			lb.ApplyRev(tx.Val(), txNumColWidth)
 			lb.Apply("", txNumColWidth)
-			lb.ApplyRev(fmt.Sprintf("%9.2f", tx.LineValue()), txNumColWidth)
+			lb.ApplyRev(tx.LineVal(), txNumColWidth)
 			hb.Add(widget.NewLabelWithStyle(lb.String(), fyne.TextAlignLeading, ts))
-			if lb.Contains(filter) {
+			if tx.Matches(filter) {
 				cObj = append(cObj, hb)
 			}
 		case lib.TX_TYPE_DEB:
 			lb.Clear()
 			lb.Apply(tx.DateTime(), txDateColWidth)
 			lb.ApplyRev(tx.Ref(), refMax)
+			lb.ApplyRev(tx.Category(), catMax)
 			lb.Apply("", txNumColWidth)
-			lb.ApplyRev(fmt.Sprintf("%9.2f", tx.Value()), txNumColWidth)
-			lb.ApplyRev(fmt.Sprintf("%9.2f", tx.LineValue()), txNumColWidth)
+			lb.ApplyRev(tx.Val(), txNumColWidth)
+			lb.ApplyRev(tx.LineVal(), txNumColWidth)
 			hb.Add(widget.NewLabelWithStyle(lb.String(), fyne.TextAlignLeading, ts))
-			if lb.Contains(filter) {
+			if tx.Matches(filter) {
 				cObj = append(cObj, hb)
 			}
 		default:
@@ -351,12 +380,44 @@ func getTransactionalCanvasObjects(actionFunc func(string, *parser.Path, string)
 	return cObj
 }
 
+/*
+	The recurring transaction templates for an account.
+	In EditMode templates can be added and removed. Extra for remove is the index of the template.
+*/
+func getRecurringCanvasObjects(actionFunc func(string, *parser.Path, string), cObj []fyne.CanvasObject, accData *lib.AccountData, statusDisplay *StatusDisplay) []fyne.CanvasObject {
+	if len(accData.Recurring) == 0 && !EditMode {
+		return cObj
+	}
+	hb := container.NewHBox()
+	if EditMode {
+		add := NewMyIconButton("", theme.ContentAddIcon(), func(a, b string) {
+			actionFunc(ACTION_ADD_RECURRING, accData.Path.PathParent(), accData.AccountName)
+		}, "", "", statusDisplay, fmt.Sprintf("Add a recurring transaction to '%s'", accData.AccountName))
+		hb.Add(add)
+	}
+	hb.Add(widget.NewLabel(fmt.Sprintf("Recurring: %d", len(accData.Recurring))))
+	cObj = append(cObj, hb)
+	for i, r := range accData.Recurring {
+		index := strconv.Itoa(i)
+		hb := container.NewHBox()
+		if EditMode {
+			rem := NewMyIconButton("", theme.DeleteIcon(), func(a, b string) {
+				actionFunc(ACTION_REMOVE_RECURRING, accData.Path.PathParent(), index)
+			}, "", "", statusDisplay, fmt.Sprintf("Remove recurring '%s'", r.Ref))
+			hb.Add(rem)
+		}
+		hb.Add(widget.NewLabel(r.String()))
+		cObj = append(cObj, hb)
+	}
+	return cObj
+}
+
 func assetScreen(w fyne.Window, details DetailPage, actionFunc func(string, *parser.Path, string), pref *pref.PrefData, statusDisplay *StatusDisplay, log func(string)) fyne.CanvasObject {
 	cObj := make([]fyne.CanvasObject, 0)
 	cObj = append(cObj, widget.NewSeparator())
 	accountData, err := lib.FindAllUserAccounts(details.User)
-	tot := 0.0
 	if err == nil {
+		base := GetBaseCurrency(pref)
 		refMax := 10
 		for _, asset := range accountData {
 			if refMax < len(asset.AccountName) {
@@ -372,17 +433,52 @@ func assetScreen(w fyne.Window, details DetailPage, actionFunc func(string, *par
 				hb.Add(NewStringFieldRight("No trans data!", 20))
 			}
 			hb.Add(NewStringFieldRight(asset.AccountName, refMax))
-			hb.Add(NewFloatFieldRight(asset.ClosingValue, 10))
-			tot = tot + asset.ClosingValue
+			hb.Add(NewStringFieldRight(lib.FormatMoney(asset.ClosingValue, asset.Currency), 14))
+			cObj = append(cObj, hb)
+		}
+		summary := lib.NewAssetSummary(accountData, lib.GetExchangeRates(details.DataRootMap), base)
+		cObj = append(cObj, widget.NewSeparator())
+		for _, ct := range summary.Totals {
+			hb := container.NewHBox()
+			hb.Add(NewStringFieldRight(fmt.Sprintf("Total %s:", ct.Currency), refMax+20))
+			hb.Add(NewStringFieldRight(lib.FormatMoney(ct.Total, ct.Currency), 14))
+			if ct.Currency != summary.Base {
+				if ct.HasRate {
+					hb.Add(NewStringFieldRight(lib.FormatMoney(ct.Converted, summary.Base), 14))
+				} else {
+					hb.Add(NewStringFieldRight("No rate", 14))
+				}
+			}
 			cObj = append(cObj, hb)
 		}
 		cObj = append(cObj, widget.NewSeparator())
 		hb := container.NewHBox()
-		hb.Add(NewStringFieldRight("Total Value:", refMax))
-		hb.Add(NewFloatFieldRight(tot, 10))
+		hb.Add(NewStringFieldRight(fmt.Sprintf("Total Value (%s):", summary.Base), refMax+20))
+		hb.Add(NewStringFieldRight(lib.FormatMoney(summary.GrandTotal, summary.Base), 14))
 		cObj = append(cObj, hb)
+		if len(summary.Missing) > 0 {
+			cObj = append(cObj, widget.NewLabel(fmt.Sprintf("No exchange rate for %s. Not included in the Total Value", strings.Join(summary.Missing, ", "))))
+		}
 	}
-	return container.NewScroll(container.NewVBox(cObj...))
+	sc := container.NewScroll(container.NewVBox(cObj...))
+	sc.OnScrolled = func(p fyne.Position) { NoteActivity() }
+	return sc
+}
+
+/*
+	The currency used for the converted Total Value in the asset summary.
+	This is for display only. Accounts without a currency are always in lib.DefaultBaseCurrency
+*/
+func GetBaseCurrency(p *pref.PrefData) string {
+	c := lib.NormaliseCurrency(p.GetStringWithFallback(baseCurrencyPrefName, lib.DefaultBaseCurrency))
+	if lib.ValidateCurrency(c) != nil {
+		return lib.DefaultBaseCurrency
+	}
+	return c
+}
+
+func SetBaseCurrency(p *pref.PrefData, currency string) {
+	p.PutString(baseCurrencyPrefName, lib.NormaliseCurrency(currency))
 }
 
 func detailsScreen(w fyne.Window, details DetailPage, actionFunc func(string, *parser.Path, string), pref *pref.PrefData, statusDisplay *StatusDisplay, log func(string)) fyne.CanvasObject {
@@ -392,6 +488,9 @@ func detailsScreen(w fyne.Window, details DetailPage, actionFunc func(string, *p
 	transPath := parser.NewPath("", ".")
 	for _, k := range keys {
 		v := data.GetNodeWithName(k)
+		if k == lib.IdTxRecurring && v.IsContainer() {
+			continue // Shown with the transactions
+		}
 		idd := details.SelectedPath.StringAppend(k)
 		editEntry, ok := EditEntryListCache.Get(idd)
 		if !ok {
@@ -405,7 +504,7 @@ func detailsScreen(w fyne.Window, details DetailPage, actionFunc func(string, *p
 			transPath = editEntry.Path
 		} else {
 			clip := NewMyIconButton("", theme.ContentCopyIcon(), func(a, b string) {
-				w.Clipboard().SetContent(editEntry.GetCurrentText())
+				CopyToClipboard(w, editEntry.GetCurrentText())
 				actionFunc(ACTION_COPIED, editEntry.Path, editEntry.GetCurrentText())
 			}, "", "", statusDisplay, fmt.Sprintf("Copy the contents of '%s' to the clipboard", k))
 			flClipboard := container.New(&FixedLayout{10, 1}, clip)
@@ -421,21 +520,24 @@ func detailsScreen(w fyne.Window, details DetailPage, actionFunc func(string, *p
 			flRename := container.New(&FixedLayout{10, 0}, editEntry.Rename)
 			cObj = append(cObj, widget.NewSeparator())
 			if !EditMode {
+				flHistory := historyControls(w, details.DataRootMap, editEntry.Path, k, statusDisplay)
 				switch na {
 				case lib.NODE_TYPE_RT:
-					cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flLink, flLab), nil, widget.NewRichTextFromMarkdown(editEntry.GetCurrentText())))
+					cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flLink, flLab), flHistory, widget.NewRichTextFromMarkdown(editEntry.GetCurrentText())))
 				case lib.NODE_TYPE_PO:
-					cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flLink, flLab), nil, positional(editEntry.GetCurrentText())))
+					cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flLink, flLab), flHistory, positional(editEntry.GetCurrentText())))
+				case lib.NODE_TYPE_OT:
+					cObj = append(cObj, container.NewBorder(nil, nil, flLab, flHistory, otpDisplay(w, editEntry, actionFunc, statusDisplay)))
 				case lib.NODE_TYPE_IM:
 					image, message := loadImage(editEntry.GetCurrentText())
 					if message == "" {
 						image.FillMode = canvas.ImageFillOriginal
 						cObj = append(cObj, image)
 					} else {
-						cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flLink, flLab), nil, widget.NewLabel(message)))
+						cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flLink, flLab), flHistory, widget.NewLabel(message)))
 					}
 				default:
-					cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flLink, flLab, flClipboard), nil, widget.NewLabel(editEntry.GetCurrentText())))
+					cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flLink, flLab, flClipboard), flHistory, widget.NewLabel(editEntry.GetCurrentText())))
 				}
 			} else {
 				var we *widget.Entry
@@ -459,7 +561,19 @@ func detailsScreen(w fyne.Window, details DetailPage, actionFunc func(string, *p
 					}
 				}
 				editEntry.We = we
-				cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flRemove, flRename, flLink, flLab, flUnDo), nil, container.New(NewFixedHLayout(300, contHeight), we)))
+				var flGen fyne.CanvasObject
+				if na == lib.NODE_TYPE_SL || na == lib.NODE_TYPE_PO {
+					gen := NewMyIconButton("", theme.ViewRefreshIcon(), func(a, b string) {
+						NewModalPasswordGeneratorDialog(w, fmt.Sprintf("Generate a password for '%s'", editEntry.Title), GetPasswordGenOptions(pref), func(ok bool, value string, o *lib.PasswordGenOptions) {
+							if ok {
+								PutPasswordGenOptions(pref, o)
+								we.SetText(value)
+							}
+						})
+					}, "", "", statusDisplay, fmt.Sprintf("Generate a password for '%s'", k))
+					flGen = container.New(&FixedLayout{10, 0}, gen)
+				}
+				cObj = append(cObj, container.NewBorder(nil, nil, container.NewHBox(flRemove, flRename, flLink, flLab, flUnDo), flGen, container.New(NewFixedHLayout(300, contHeight), we)))
 			}
 		}
 	}
@@ -471,7 +585,9 @@ func detailsScreen(w fyne.Window, details DetailPage, actionFunc func(string, *p
 			cObj = getTransactionalCanvasObjects(actionFunc, cObj, accountData, pref, statusDisplay, log)
 		}
 	}
-	return container.NewScroll(container.NewVBox(cObj...))
+	sc := container.NewScroll(container.NewVBox(cObj...))
+	sc.OnScrolled = func(p fyne.Position) { NoteActivity() }
+	return sc
 }
 
 func entryChangedFunction(newWalue string, path *parser.Path) error {
@@ -583,7 +699,7 @@ func runModalEntryPopup(w fyne.Window, heading, txt string, password bool, isAnn
 		radioGroup.SetSelected(lib.NodeAnnotationPrefixNames[annotation])
 		styles = container.NewCenter(container.New(layout.NewHBoxLayout()), radioGroup)
 	}
-	entry := &widget.Entry{Text: txt, Password: password, OnChanged: nil, OnSubmitted: submitInternal}
+	entry := &widget.Entry{Text: txt, Password: password, OnChanged: func(s string) { NoteActivity() }, OnSubmitted: submitInternal}
 	buttons := container.NewCenter(container.New(layout.NewHBoxLayout(), widget.NewButton("Cancel", func() {
 		modal.Hide()
 		accept(false, entry.Text, noteTypeId)
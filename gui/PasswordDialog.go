@@ -0,0 +1,275 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package gui
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+	"stuartdd.com/pref"
+)
+
+var (
+	pwGenPrefName = parser.NewDotPath("generator")
+)
+
+/*
+	Ask for a NEW password twice and display the strength of the password.
+	If askCurrent is true the current password is also requested.
+	The OK button is only enabled when the new password is entered and the two new passwords match.
+	accept(ok, current, new) is called when OK or Cancel is pressed.
+*/
+func NewModalChangePasswordDialog(w fyne.Window, heading string, askCurrent bool, accept func(bool, string, string)) (modal *widget.PopUp) {
+	current := &widget.Entry{Password: true}
+	newPw := &widget.Entry{Password: true}
+	confirm := &widget.Entry{Password: true}
+	strength := widget.NewLabel("Strength: ")
+	info := widget.NewLabel("Enter the new password twice")
+	var okButton *widget.Button
+
+	validate := func(s string) {
+		NoteActivity()
+		strength.SetText("Strength: " + lib.PasswordStrengthDesc(newPw.Text))
+		switch {
+		case askCurrent && current.Text == "":
+			info.SetText("Enter the current password")
+			okButton.Disable()
+		case newPw.Text == "":
+			info.SetText("Enter the new password twice")
+			okButton.Disable()
+		case newPw.Text != confirm.Text:
+			info.SetText("The new passwords do not match")
+			okButton.Disable()
+		default:
+			info.SetText("Press OK to change the password")
+			okButton.Enable()
+		}
+	}
+	submit := func(ok bool) {
+		if ok && okButton.Disabled() {
+			return
+		}
+		modal.Hide()
+		w.Canvas().SetOnTypedKey(nil)
+		accept(ok, current.Text, newPw.Text)
+	}
+	current.OnChanged = validate
+	newPw.OnChanged = validate
+	confirm.OnChanged = validate
+	current.OnSubmitted = func(s string) { w.Canvas().Focus(newPw) }
+	newPw.OnSubmitted = func(s string) { w.Canvas().Focus(confirm) }
+	confirm.OnSubmitted = func(s string) { submit(true) }
+
+	okButton = widget.NewButton("OK", func() {
+		submit(true)
+	})
+	buttons := container.NewCenter(container.New(layout.NewHBoxLayout(), widget.NewButton("Cancel", func() {
+		submit(false)
+	}), okButton))
+
+	form := container.NewVBox(container.NewCenter(widget.NewLabel(heading)))
+	if askCurrent {
+		form.Add(NewStringFieldLeft("Current password:", 20))
+		form.Add(current)
+	}
+	form.Add(NewStringFieldLeft("New password:", 20))
+	form.Add(newPw)
+	form.Add(NewStringFieldLeft("Confirm password:", 20))
+	form.Add(confirm)
+	form.Add(strength)
+	form.Add(widget.NewSeparator())
+	form.Add(container.NewCenter(info))
+	form.Add(buttons)
+
+	modal = widget.NewModalPopUp(form, w.Canvas())
+	w.Canvas().SetOnTypedKey(func(ke *fyne.KeyEvent) {
+		if ke.Name == "Escape" {
+			submit(false)
+		}
+	})
+	validate("")
+	modal.Resize(fyne.NewSize(400, -1))
+	modal.Show()
+	if askCurrent {
+		w.Canvas().Focus(current)
+	} else {
+		w.Canvas().Focus(newPw)
+	}
+	return modal
+}
+
+/*
+	Read the password generator defaults from the preferences.
+	Missing values are added to the preferences with the lib defaults.
+*/
+func GetPasswordGenOptions(p *pref.PrefData) *lib.PasswordGenOptions {
+	d := lib.NewPasswordGenOptions()
+	return &lib.PasswordGenOptions{
+		Length:           int(p.GetInt64WithFallback(pwGenPrefName.StringAppend("length"), int64(d.Length))),
+		Lower:            p.GetBoolWithFallback(pwGenPrefName.StringAppend("lower"), d.Lower),
+		Upper:            p.GetBoolWithFallback(pwGenPrefName.StringAppend("upper"), d.Upper),
+		Digits:           p.GetBoolWithFallback(pwGenPrefName.StringAppend("digits"), d.Digits),
+		Symbols:          p.GetBoolWithFallback(pwGenPrefName.StringAppend("symbols"), d.Symbols),
+		ExcludeAmbiguous: p.GetBoolWithFallback(pwGenPrefName.StringAppend("excludeAmbiguous"), d.ExcludeAmbiguous),
+		Passphrase:       p.GetBoolWithFallback(pwGenPrefName.StringAppend("passphrase"), d.Passphrase),
+		Words:            int(p.GetInt64WithFallback(pwGenPrefName.StringAppend("words"), int64(d.Words))),
+		Separator:        p.GetStringWithFallback(pwGenPrefName.StringAppend("separator"), d.Separator),
+	}
+}
+
+/*
+	Store the password generator options as the defaults for next time.
+*/
+func PutPasswordGenOptions(p *pref.PrefData, o *lib.PasswordGenOptions) {
+	p.PutInt64(pwGenPrefName.StringAppend("length"), int64(o.Length))
+	p.PutBool(pwGenPrefName.StringAppend("lower"), o.Lower)
+	p.PutBool(pwGenPrefName.StringAppend("upper"), o.Upper)
+	p.PutBool(pwGenPrefName.StringAppend("digits"), o.Digits)
+	p.PutBool(pwGenPrefName.StringAppend("symbols"), o.Symbols)
+	p.PutBool(pwGenPrefName.StringAppend("excludeAmbiguous"), o.ExcludeAmbiguous)
+	p.PutBool(pwGenPrefName.StringAppend("passphrase"), o.Passphrase)
+	p.PutInt64(pwGenPrefName.StringAppend("words"), int64(o.Words))
+	p.PutString(pwGenPrefName.StringAppend("separator"), o.Separator)
+	p.Save()
+}
+
+/*
+	Generate a password (or passphrase) using the options.
+	A new value is generated each time an option is changed or Generate is pressed.
+	The generated value can be edited before OK is pressed.
+	accept(ok, value, options) is called when OK or Cancel is pressed. The options are as the user left them.
+*/
+func NewModalPasswordGeneratorDialog(w fyne.Window, heading string, options *lib.PasswordGenOptions, accept func(bool, string, *lib.PasswordGenOptions)) (modal *widget.PopUp) {
+	o := *options
+	value := widget.NewEntry()
+	strength := widget.NewLabel("Strength: ")
+	info := widget.NewLabel("")
+	lengthLab := widget.NewLabel("")
+	wordsLab := widget.NewLabel("")
+	var okButton *widget.Button
+	var charOpts, phraseOpts *fyne.Container
+
+	value.OnChanged = func(s string) {
+		NoteActivity()
+		strength.SetText("Strength: " + lib.PasswordStrengthDesc(s))
+		if s == "" {
+			okButton.Disable()
+		} else {
+			okButton.Enable()
+		}
+	}
+	generate := func() {
+		lengthLab.SetText(fmt.Sprintf("Length: %d", o.Length))
+		wordsLab.SetText(fmt.Sprintf("Words: %d", o.Words))
+		if o.Passphrase {
+			charOpts.Hide()
+			phraseOpts.Show()
+		} else {
+			phraseOpts.Hide()
+			charOpts.Show()
+		}
+		pw, err := lib.GeneratePassword(&o)
+		if err != nil {
+			info.SetText(fmt.Sprintf("Error: %s", err.Error()))
+			value.SetText("")
+		} else {
+			info.SetText("Press OK to use the generated value")
+			value.SetText(pw)
+		}
+	}
+	check := func(label string, b *bool) *widget.Check {
+		c := widget.NewCheck(label, nil)
+		c.SetChecked(*b)
+		c.OnChanged = func(v bool) {
+			*b = v
+			generate()
+		}
+		return c
+	}
+	lengthSlider := widget.NewSlider(4, 64)
+	lengthSlider.SetValue(float64(o.Length))
+	lengthSlider.OnChanged = func(f float64) {
+		if int(f) != o.Length {
+			o.Length = int(f)
+			generate()
+		}
+	}
+	wordsSlider := widget.NewSlider(1, 12)
+	wordsSlider.SetValue(float64(o.Words))
+	wordsSlider.OnChanged = func(f float64) {
+		if int(f) != o.Words {
+			o.Words = int(f)
+			generate()
+		}
+	}
+	separator := widget.NewEntry()
+	separator.SetText(o.Separator)
+	separator.OnChanged = func(s string) {
+		o.Separator = s
+		generate()
+	}
+	charOpts = container.NewVBox(
+		container.NewBorder(nil, nil, lengthLab, nil, lengthSlider),
+		container.NewHBox(check("a-z", &o.Lower), check("A-Z", &o.Upper), check("0-9", &o.Digits), check("Symbols", &o.Symbols)),
+		check("Exclude look-alike characters (I l 1 O 0 o)", &o.ExcludeAmbiguous),
+	)
+	phraseOpts = container.NewVBox(
+		container.NewBorder(nil, nil, wordsLab, nil, wordsSlider),
+		container.NewBorder(nil, nil, widget.NewLabel("Separator:"), nil, separator),
+	)
+	submit := func(ok bool) {
+		if ok && okButton.Disabled() {
+			return
+		}
+		modal.Hide()
+		w.Canvas().SetOnTypedKey(nil)
+		accept(ok, value.Text, &o)
+	}
+	okButton = widget.NewButton("OK", func() {
+		submit(true)
+	})
+	buttons := container.NewCenter(container.New(layout.NewHBoxLayout(), widget.NewButton("Cancel", func() {
+		submit(false)
+	}), widget.NewButton("Generate", generate), okButton))
+
+	modal = widget.NewModalPopUp(container.NewVBox(
+		container.NewCenter(widget.NewLabel(heading)),
+		value,
+		strength,
+		widget.NewSeparator(),
+		check("Passphrase (words)", &o.Passphrase),
+		charOpts,
+		phraseOpts,
+		widget.NewSeparator(),
+		container.NewCenter(info),
+		buttons,
+	), w.Canvas())
+	w.Canvas().SetOnTypedKey(func(ke *fyne.KeyEvent) {
+		if ke.Name == "Escape" {
+			submit(false)
+		}
+	})
+	generate()
+	modal.Resize(fyne.NewSize(450, -1))
+	modal.Show()
+	return modal
+}
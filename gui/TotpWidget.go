@@ -0,0 +1,64 @@
+package gui
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+//
+// Incremented when the page changes. Any one time password display with an older value stops updating.
+//
+var otpGeneration int32
+
+func StopOtpUpdates() {
+	atomic.AddInt32(&otpGeneration, 1)
+}
+
+//
+// Display the current one time password (TOTP) code for an !otp item with a countdown and a copy button.
+// The code is updated every second until StopOtpUpdates is called.
+//
+func otpDisplay(w fyne.Window, editEntry *EditEntry, actionFunc func(string, *parser.Path, string), statusDisplay *StatusDisplay) fyne.CanvasObject {
+	key, err := lib.ParseTotp(editEntry.GetCurrentText())
+	if err != nil {
+		return widget.NewLabel(fmt.Sprintf("Invalid one time password: %s", err.Error()))
+	}
+	code := widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true, Bold: true})
+	remaining := widget.NewProgressBar()
+	remaining.Max = float64(key.Period)
+	remaining.TextFormatter = func() string {
+		return fmt.Sprintf("%.0fs", remaining.Value)
+	}
+	copyButton := NewMyIconButton("", theme.ContentCopyIcon(), func(a, b string) {
+		c := key.CodeAt(time.Now())
+		CopyToClipboard(w, c)
+		actionFunc(ACTION_COPIED, editEntry.Path, c)
+	}, "", "", statusDisplay, fmt.Sprintf("Copy the current code for '%s' to the clipboard", editEntry.Title))
+
+	update := func() {
+		now := time.Now()
+		c := key.CodeAt(now)
+		code.SetText(fmt.Sprintf("%s %s", c[:len(c)/2], c[len(c)/2:]))
+		remaining.SetValue(float64(key.RemainingAt(now)))
+	}
+	update()
+	gen := atomic.LoadInt32(&otpGeneration)
+	go func() {
+		for {
+			time.Sleep(time.Second)
+			if atomic.LoadInt32(&otpGeneration) != gen {
+				return
+			}
+			update()
+		}
+	}()
+	return container.NewBorder(nil, nil, container.NewHBox(container.New(&FixedLayout{10, 1}, copyButton), code), widget.NewLabel(key.String()), remaining)
+}
@@ -191,6 +191,7 @@ func (idl *InputDataWindow) createRow(ifd *InpuFieldData) *fyne.Container {
 	e.SetText(strings.TrimSpace(ifd.Value))
 	l := NewStringFieldRight(ifd.Labels[0]+":", idl.longestLabel+2)
 	e.OnChanged = func(s string) {
+		NoteActivity()
 		ifd.Value = strings.TrimSpace(s)
 		idl.validateAll()
 	}
@@ -18,7 +18,6 @@ package lib
 
 import (
 	"fmt"
-	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -39,6 +38,7 @@ const (
 	IdTxRef              = "ref"
 	IdTxVal              = "val"
 	IdTxType             = "type"
+	IdTxCategory         = "category" // Optional
 
 	TX_TYPE_ERR TransactionTypeEnum = "err"
 	TX_TYPE_IV  TransactionTypeEnum = "iv"
@@ -67,14 +67,14 @@ var (
 //				Path --> 'groups'.username.'assets'.assetname
 //				Transactions --> []*TranactionData
 //					dateTime 	DateTime()
-//					value		Value() float64
-//								AbsValue() float64
-//								Val() string - formatted value
+//					value		Value() Money
+//								AbsValue() Money
+//								Val() string - formatted value in the account currency
 //					ref			Ref() string
 //					txType		TxType() TransactionTypeEnum
 //								Key() string - date + ref
-//								LineValue() float64
-//								LineVal() string - formatted value
+//								LineValue() Money
+//								LineVal() string - formatted value in the account currency
 //								HasError() bool
 //
 
@@ -90,20 +90,24 @@ type UserAsset struct {
 
 type TranactionData struct {
 	dateTime  time.Time
-	value     float64
+	value     Money
 	ref       string
 	txType    TransactionTypeEnum
+	category  string
+	currency  string // From the account. Used to format values
 	err       error
-	lineValue float64
+	lineValue Money
 }
 
 type AccountData struct {
 	User         string
 	Path         parser.Path
-	AccountName  string            // Like Lloyds Bank Current Account
-	InitialValue float64           // initial value.
-	ClosingValue float64           // initial value -+ all transactions
-	Transactions []*TranactionData // Each transaction
+	AccountName  string                  // Like Lloyds Bank Current Account
+	Currency     string                  // Like EUR. Empty is DefaultBaseCurrency
+	InitialValue Money                   // initial value.
+	ClosingValue Money                   // initial value -+ all transactions
+	Transactions []*TranactionData       // Each transaction
+	Recurring    []*RecurringTransaction // Templates in the order of the 'recurring' list
 	Filter       string
 }
 
@@ -125,54 +129,53 @@ func InitUserAssetsCache(root *JsonData) {
 	cachedUserAssets = cache
 }
 
-func ImportCsvData(txNode parser.NodeC, fileName string, skipFirstLine bool, dtFormat string, mapList []string) (int, error) {
-	count := 0
-	data, err := ParseFileToMap(fileName, skipFirstLine, mapList)
+//
+// Import transactions from a CSV file in to a 'transactions' node using an import profile.
+// Transactions that already exist are not added.
+//
+func ImportCsvData(txNode parser.NodeC, fileName string, profile *ImportProfile) (int, error) {
+	nodes, err := CsvTransactionNodes(fileName, profile)
 	if err != nil {
 		return 0, err
 	}
+	return importNewNodes(txNode, nodes)
+}
+
+//
+// Read the transactions in a CSV file using an import profile. See PreviewImport.
+//
+func CsvTransactionNodes(fileName string, profile *ImportProfile) ([]*parser.JsonObject, error) {
+	err := profile.Validate()
+	if err != nil {
+		return nil, err
+	}
+	delimiter, _ := CsvDelimiter(profile.Delimiter)
+	data, err := ParseFileToMapWithDelimiter(fileName, delimiter, profile.SkipHeader, profile.Columns)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*parser.JsonObject, 0)
 	for _, m := range data {
-		dt, err := time.Parse(TIME_FORMAT_CSV, m["date"])
+		dt, err := profile.ParseDate(m[ImportColDate])
 		if err != nil {
-			return 0, err
-		}
-		dts := FormatDateTime(dt)
-		tx := TX_TYPE_ERR
-		var va float64
-		if m["cr"] != "" {
-			tx = TX_TYPE_CRE
-			va, err = strconv.ParseFloat(m["cr"], 64)
-			if err != nil {
-				return 0, err
-			}
-		} else {
-			if m["db"] != "" {
-				tx = TX_TYPE_DEB
-				va, err = strconv.ParseFloat(m["db"], 64)
-				if err != nil {
-					return 0, err
-				}
-			}
-		}
-
-		re := fmt.Sprintf("%s [%s]", m["ref"], m["type"])
-		if strings.TrimSpace(m["type"]) == "" {
-			re = m["ref"]
+			return nil, err
 		}
-		tn := parser.NewJsonObject("")
-		tn.Add(parser.NewJsonString(IdTxDate, dts))
-		tn.Add(parser.NewJsonString(IdTxRef, re))
-		tn.Add(parser.NewJsonString(IdTxType, string(tx)))
-		tn.Add(parser.NewJsonNumber(IdTxVal, va))
-		if !NodeExistsInContainer(txNode, tn) {
-			count++
-			txNode.Add(tn)
+		tx, va, err := profile.RowAmount(m)
+		if err != nil {
+			return nil, err
 		}
+		nodes = append(nodes, newTransactionNode(dt, profile.RowRef(m), tx, va))
 	}
-	if count == 0 {
-		return 0, fmt.Errorf("no NEW tranactions have been added")
-	}
-	return count, nil
+	return nodes, nil
+}
+
+func newTransactionNode(dt time.Time, ref string, txType TransactionTypeEnum, value Money) *parser.JsonObject {
+	tn := parser.NewJsonObject("")
+	tn.Add(parser.NewJsonString(IdTxDate, FormatDateTime(dt)))
+	tn.Add(parser.NewJsonString(IdTxRef, ref))
+	tn.Add(parser.NewJsonString(IdTxType, string(txType)))
+	tn.Add(parser.NewJsonNumber(IdTxVal, value.Float64()))
+	return tn
 }
 
 func NodeExistsInContainer(parentNode parser.NodeC, newNode parser.NodeI) bool {
@@ -253,7 +256,7 @@ func newUserAsset(userNode, assetsNode parser.NodeC) *UserAsset {
 	ad := make([]*AccountData, 0)
 	for _, accN := range assetsNode.GetValues() {
 		if accN.IsContainer() {
-			ad = append(ad, newAccountData(accN.(parser.NodeC), userNode, 0.0))
+			ad = append(ad, newAccountData(accN.(parser.NodeC), userNode, 0))
 		}
 	}
 	return &UserAsset{user: userNode, asset: assetsNode, data: ad}
@@ -273,14 +276,31 @@ func userAssetKey(userNode, assetsNode parser.NodeC) string {
 
 // !tx node. List of all transactions.
 // Sorted by datetime.
-func newAccountData(accountNode, userNode parser.NodeC, initialValue float64) *AccountData {
+func newAccountData(accountNode, userNode parser.NodeC, initialValue Money) *AccountData {
 	d := make([]*TranactionData, 0)
 	v := initialValue
+	currency := ""
+	cn := accountNode.GetNodeWithName(IdCurrency)
+	if cn != nil && cn.GetNodeType() == parser.NT_STRING {
+		currency = NormaliseCurrency(cn.String())
+	}
+	recurring := make([]*RecurringTransaction, 0)
+	rl := accountNode.GetNodeWithName(IdTxRecurring)
+	if rl != nil && rl.GetNodeType() == parser.NT_LIST {
+		for _, rn := range rl.(*parser.JsonList).GetValues() {
+			r, err := NewRecurringTransactionFromNode(rn)
+			if err != nil {
+				r = &RecurringTransaction{Err: err}
+			}
+			recurring = append(recurring, r)
+		}
+	}
 	for _, n := range accountNode.GetValues() {
 		if n.GetName() == IdTxTransactions && n.IsContainer() {
 			var iv *TranactionData
 			for _, ni := range n.(parser.NodeC).GetValues() {
 				td := NewTranactionDataFromNode(ni)
+				td.currency = currency
 				if td.txType == TX_TYPE_IV {
 					iv = td
 				} else {
@@ -303,11 +323,21 @@ func newAccountData(accountNode, userNode parser.NodeC, initialValue float64) *A
 			}
 		}
 	}
-	return &AccountData{User: userNode.GetName(), AccountName: accountNode.GetName(), InitialValue: initialValue, ClosingValue: v, Transactions: d}
+	return &AccountData{User: userNode.GetName(), AccountName: accountNode.GetName(), Currency: currency, InitialValue: initialValue, ClosingValue: v, Transactions: d, Recurring: recurring}
 }
 
 func (t *AccountData) String() string {
-	return fmt.Sprintf("Name: %s Initial value:%9.2f Final value:%9.2f", t.AccountName, t.InitialValue, t.ClosingValue)
+	return fmt.Sprintf("Name: %s Initial value:%9s Final value:%9s", t.AccountName, t.InitialValue, t.ClosingValue)
+}
+
+//
+// The currency of the account. Accounts without a currency are in DefaultBaseCurrency.
+//
+func (t *AccountData) CurrencyCode() string {
+	if t.Currency == "" {
+		return DefaultBaseCurrency
+	}
+	return t.Currency
 }
 
 func (t *AccountData) LatestTransaction() *TranactionData {
@@ -344,12 +374,12 @@ func GetTransactionDataAndNodeForKey(txNode parser.NodeC, key string) (*Tranacti
 	return nil, nil, fmt.Errorf("failed GetTransactionDataAndNodeForKey. Transaction with key '%s' not found", key)
 }
 
-func newTranactionData(dateTime time.Time, value float64, ref string, typ TransactionTypeEnum, n parser.NodeI) *TranactionData {
-	return &TranactionData{dateTime: dateTime, value: value, ref: ref, lineValue: 0.0, txType: typ}
+func newTranactionData(dateTime time.Time, value Money, ref string, typ TransactionTypeEnum, n parser.NodeI) *TranactionData {
+	return &TranactionData{dateTime: dateTime, value: value, ref: ref, lineValue: 0, txType: typ}
 }
 
 func newTranactionDataError(err string, n parser.NodeI) *TranactionData {
-	return &TranactionData{err: fmt.Errorf("%s '%s'", err, n.JsonValue()), lineValue: 0.0, txType: TX_TYPE_ERR}
+	return &TranactionData{err: fmt.Errorf("%s '%s'", err, n.JsonValue()), lineValue: 0, txType: TX_TYPE_ERR}
 }
 
 func (t *TranactionData) DateTime() string {
@@ -357,7 +387,14 @@ func (t *TranactionData) DateTime() string {
 }
 
 func (t *TranactionData) Key() string {
-	return fmt.Sprintf("%s %s %s %s", FormatDateTime(t.dateTime), t.ref, t.txType, t.Val())
+	return fmt.Sprintf("%s %s %s %s", FormatDateTime(t.dateTime), t.ref, t.txType, t.amount())
+}
+
+//
+// The value without the currency. Used to compare transactions.
+//
+func (t *TranactionData) amount() string {
+	return fmt.Sprintf("%9s", t.value)
 }
 
 func (t1 *TranactionData) Equal(t2 *TranactionData) bool {
@@ -368,8 +405,12 @@ func (t *TranactionData) Ref() string {
 	return t.ref
 }
 
+func (t *TranactionData) Category() string {
+	return t.category
+}
+
 func (t *TranactionData) Val() string {
-	return fmt.Sprintf("%9.2f", t.value)
+	return FormatMoney(t.value, t.currency)
 }
 
 func (t *TranactionData) TxType() TransactionTypeEnum {
@@ -377,21 +418,25 @@ func (t *TranactionData) TxType() TransactionTypeEnum {
 }
 
 func (t *TranactionData) LineVal() string {
-	return fmt.Sprintf("%9.2f", t.lineValue)
+	return FormatMoney(t.lineValue, t.currency)
+}
+
+func (t *TranactionData) Currency() string {
+	return t.currency
 }
 
-func (t *TranactionData) Value() float64 {
+func (t *TranactionData) Value() Money {
 	return t.value
 }
-func (t *TranactionData) AbsValue() float64 {
-	return math.Abs(t.value)
+func (t *TranactionData) AbsValue() Money {
+	return t.value.Abs()
 }
 
-func (t *TranactionData) LineValue() float64 {
+func (t *TranactionData) LineValue() Money {
 	return t.lineValue
 }
 
-func (t *TranactionData) SetLineValue(lineValue float64) {
+func (t *TranactionData) SetLineValue(lineValue Money) {
 	t.lineValue = lineValue
 }
 
@@ -439,19 +484,28 @@ func ParseDateString(dts string) (time.Time, error) {
 
 //
 // Update a json transaction node from a key and value
-// 		keys 'date', 'ref', 'val', 'type'
-// If node is a number then value is a float as a string
+// 		keys 'date', 'ref', 'val', 'type', 'category'
+// If node is a number then value is a float as a string. The 'val' is a money value (see ParseMoney)
 // else node is a non empty string
-//
+// The optional 'category' is added if it does not exist.
 //
 func UpdateNodeFromTranactionData(txNode parser.NodeC, key, value string) int {
 	vn := txNode.GetNodeWithName(key)
 	if vn == nil {
+		if key == IdTxCategory && strings.TrimSpace(value) != "" {
+			txNode.Add(parser.NewJsonString(IdTxCategory, strings.TrimSpace(value)))
+			return 1
+		}
 		return 0
 	}
 	switch vn.GetNodeType() {
 	case parser.NT_NUMBER:
 		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if key == IdTxVal {
+			var m Money
+			m, err = ParseMoney(value)
+			v = m.Float64()
+		}
 		if err == nil {
 			if v != vn.(*parser.JsonNumber).GetValue() {
 				vn.(*parser.JsonNumber).SetValue(v)
@@ -495,13 +549,18 @@ func NewTranactionDataFromNode(n parser.NodeI) *TranactionData {
 		if vn.GetNodeType() != parser.NT_NUMBER {
 			return newTranactionDataError("invalid Transaction node 'val' member is  not a number'%s'", n)
 		}
-		val := vn.(*parser.JsonNumber).GetValue()
+		val := MoneyFromFloat(vn.(*parser.JsonNumber).GetValue())
 		rn := n.(parser.NodeC).GetNodeWithName(IdTxRef)
 		if rn == nil {
 			return newTranactionDataError(fmt.Sprintf("Invalid Transaction node has no '%s' member", IdTxRef), n)
 		}
 		ref := rn.String()
-		return newTranactionData(dt, val, ref, tys, n)
+		td := newTranactionData(dt, val, ref, tys, n)
+		cn := n.(parser.NodeC).GetNodeWithName(IdTxCategory)
+		if cn != nil {
+			td.category = cn.String()
+		}
+		return td
 	} else {
 		return newTranactionDataError("invalid Transaction node has no members (date, val and ref) '%s'", n)
 	}
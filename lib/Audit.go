@@ -0,0 +1,164 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+type AuditIssueEnum int
+
+const (
+	AUDIT_DUPLICATE AuditIssueEnum = iota
+	AUDIT_WEAK
+	AUDIT_STALE
+)
+
+var (
+	AuditIssueNames    = []string{"Duplicate", "Weak", "Stale"}
+	DefaultAuditIgnore = []string{"notes", "userId", "link"}
+)
+
+type AuditOptions struct {
+	Ignore    []string                             // Field names (without annotation) that are not audited. Not case sensitive
+	MinBits   float64                              // Values with less entropy (PasswordEntropy) are Weak
+	MaxAge    time.Duration                        // Values not changed for longer are Stale. 0 is no check
+	Now       time.Time                            // Time used for the MaxAge check
+	ChangedAt func(*parser.Path) (time.Time, bool) // When a value was last changed. Optional
+	Fallback  time.Time                            // Used if ChangedAt has no time. E.g. the data time stamp. Zero is not known (never Stale)
+}
+
+type AuditResult struct {
+	Path  *parser.Path // user|pwHints|hint|field
+	Issue AuditIssueEnum
+	Desc  string
+}
+
+func NewAuditOptions() *AuditOptions {
+	return &AuditOptions{Ignore: DefaultAuditIgnore, MinBits: passwordStrengthBits[1], MaxAge: 0, Now: time.Now(), ChangedAt: nil}
+}
+
+//
+// A Stale result if the value at path has not changed for MaxAge. nil otherwise.
+//
+func (o *AuditOptions) stale(path *parser.Path) *AuditResult {
+	desc := "Not changed for %d days"
+	var changed time.Time
+	ok := false
+	if o.ChangedAt != nil {
+		changed, ok = o.ChangedAt(path)
+	}
+	if !ok && !o.Fallback.IsZero() {
+		changed, ok = o.Fallback, true
+		desc = "Not changed for at least %d days (data time stamp)"
+	}
+	if !ok || o.Now.Sub(changed) <= o.MaxAge {
+		return nil
+	}
+	return &AuditResult{Path: path, Issue: AUDIT_STALE, Desc: fmt.Sprintf(desc, int(o.Now.Sub(changed).Hours()/24))}
+}
+
+func (r *AuditResult) String() string {
+	return fmt.Sprintf("%s: %s [%s] %s", AuditIssueNames[r.Issue], r.Path.StringAt(2), r.Path.StringLast(), r.Desc)
+}
+
+//
+// The path of the hint (user|pwHints|hint). This is the node selectable in the tree.
+//
+func (r *AuditResult) HintPath() *parser.Path {
+	return r.Path.PathParent()
+}
+
+//
+// Audit the single line and positional fields of every user's hints (pwHints).
+// Empty values and fields in o.Ignore are skipped.
+// Values are flagged as duplicated (in any other hint for any user), weak or stale.
+// Results are ordered by path then issue.
+//
+func (p *JsonData) Audit(o *AuditOptions) []*AuditResult {
+	results := make([]*AuditResult, 0)
+	values := make(map[string][]*parser.Path)
+	ignore := make(map[string]bool)
+	for _, n := range o.Ignore {
+		ignore[strings.ToLower(n)] = true
+	}
+	users := p.GetUserRoot()
+	for _, userName := range users.GetSortedKeys() {
+		user, ok := users.GetNodeWithName(userName).(*parser.JsonObject)
+		if !ok {
+			continue
+		}
+		hints, ok := user.GetNodeWithName(IdHints).(*parser.JsonObject)
+		if !ok {
+			continue
+		}
+		for _, hintName := range hints.GetSortedKeys() {
+			hint, ok := hints.GetNodeWithName(hintName).(*parser.JsonObject)
+			if !ok {
+				continue
+			}
+			for _, fieldName := range hint.GetSortedKeys() {
+				field := hint.GetNodeWithName(fieldName)
+				at, name := GetNodeAnnotationTypeAndName(fieldName)
+				if field.GetNodeType() != parser.NT_STRING || (at != NODE_TYPE_SL && at != NODE_TYPE_PO) || ignore[strings.ToLower(name)] {
+					continue
+				}
+				value := field.String()
+				if value == "" {
+					continue
+				}
+				path := parser.NewBarPath(userName).StringAppend(IdHints).StringAppend(hintName).StringAppend(fieldName)
+				values[value] = append(values[value], path)
+				bits := PasswordEntropy(value)
+				if bits < o.MinBits {
+					results = append(results, &AuditResult{Path: path, Issue: AUDIT_WEAK, Desc: PasswordStrengthDesc(value)})
+				}
+				if o.MaxAge > 0 {
+					if r := o.stale(path); r != nil {
+						results = append(results, r)
+					}
+				}
+			}
+		}
+	}
+	for _, paths := range values {
+		if len(paths) < 2 {
+			continue
+		}
+		for _, path := range paths {
+			others := make([]string, 0)
+			for _, other := range paths {
+				if other != path {
+					others = append(others, fmt.Sprintf("%s [%s]", other.StringAt(2), other.StringLast()))
+				}
+			}
+			results = append(results, &AuditResult{Path: path, Issue: AUDIT_DUPLICATE, Desc: "Same as " + strings.Join(others, ", ")})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Path.String() == results[j].Path.String() {
+			return results[i].Issue < results[j].Issue
+		}
+		return results[i].Path.String() < results[j].Path.String()
+	})
+	return results
+}
@@ -0,0 +1,275 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+const (
+	UncategorisedName = "Uncategorised"
+)
+
+//
+// A rule is defined as 'category=regex'. E.g. "Groceries=(?i)tesco|sainsbury"
+// The regex is matched against the transaction ref.
+//
+type CategoryRule struct {
+	Category string
+	Pattern  string
+	re       *regexp.Regexp
+}
+
+//
+// Rules are checked in order. The first match gives the category.
+//
+type CategoryRules struct {
+	rules []*CategoryRule
+}
+
+type CategoryTotal struct {
+	Category string
+	Credit   Money
+	Debit    Money
+	Count    int
+}
+
+//
+// The category totals for the accounts in one currency.
+// Values in different currencies are never added together.
+//
+type CurrencyCategoryTotals struct {
+	Currency string
+	Totals   []*CategoryTotal // Sorted by category. Uncategorised is last
+	Credit   Money
+	Debit    Money
+	Count    int
+}
+
+type CategoryReport struct {
+	Title      string
+	From       time.Time
+	To         time.Time
+	Currencies []*CurrencyCategoryTotals // DefaultBaseCurrency first then sorted by currency
+	Count      int
+}
+
+func ParseCategoryRule(s string) (*CategoryRule, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return nil, fmt.Errorf("category rule '%s' must be 'category=regex'", s)
+	}
+	cat := strings.TrimSpace(s[:i])
+	pat := strings.TrimSpace(s[i+1:])
+	if cat == "" || pat == "" {
+		return nil, fmt.Errorf("category rule '%s' must have a category and a regex", s)
+	}
+	re, err := regexp.Compile(pat)
+	if err != nil {
+		return nil, fmt.Errorf("category rule '%s' regex is invalid. %s", s, err.Error())
+	}
+	return &CategoryRule{Category: cat, Pattern: pat, re: re}, nil
+}
+
+func NewCategoryRules(list []string) (*CategoryRules, error) {
+	rules := make([]*CategoryRule, 0)
+	for _, s := range list {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		r, err := ParseCategoryRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return &CategoryRules{rules: rules}, nil
+}
+
+func (r *CategoryRule) String() string {
+	return fmt.Sprintf("%s=%s", r.Category, r.Pattern)
+}
+
+func (r *CategoryRules) Len() int {
+	return len(r.rules)
+}
+
+//
+// Return the category of the first rule that matches the ref. Empty if none match.
+//
+func (r *CategoryRules) Categorise(ref string) string {
+	for _, rule := range r.rules {
+		if rule.re.MatchString(ref) {
+			return rule.Category
+		}
+	}
+	return ""
+}
+
+//
+// Add a category to transaction nodes that do not have one. The initial value (iv) is not categorised.
+// Returns the number of nodes categorised.
+//
+func (r *CategoryRules) Apply(nodes []*parser.JsonObject) int {
+	count := 0
+	for _, n := range nodes {
+		if n.GetNodeWithName(IdTxCategory) != nil {
+			continue
+		}
+		td := NewTranactionDataFromNode(n)
+		if td.HasError() || td.TxType() == TX_TYPE_IV {
+			continue
+		}
+		cat := r.Categorise(td.Ref())
+		if cat != "" {
+			n.Add(parser.NewJsonString(IdTxCategory, cat))
+			count++
+		}
+	}
+	return count
+}
+
+//
+// Total the credits and debits by category for the accounts. There are separate totals for each currency.
+// Transactions dated from 'from' up to and including the day 'to' are included.
+// Initial values (iv) and transactions with errors are not included.
+//
+func NewCategoryReport(title string, accounts []*AccountData, from, to time.Time) *CategoryReport {
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, to.Location()).AddDate(0, 0, 1)
+	currencies := make(map[string]*CurrencyCategoryTotals)
+	totals := make(map[string]map[string]*CategoryTotal)
+	rep := &CategoryReport{Title: title, From: from, To: to, Currencies: make([]*CurrencyCategoryTotals, 0)}
+	for _, acc := range accounts {
+		c := acc.CurrencyCode()
+		for _, tx := range acc.Transactions {
+			if tx.HasError() || tx.TxType() == TX_TYPE_IV || tx.dateTime.Before(from) || !tx.dateTime.Before(end) {
+				continue
+			}
+			cc, ok := currencies[c]
+			if !ok {
+				cc = &CurrencyCategoryTotals{Currency: c, Totals: make([]*CategoryTotal, 0)}
+				currencies[c] = cc
+				totals[c] = make(map[string]*CategoryTotal)
+				rep.Currencies = append(rep.Currencies, cc)
+			}
+			cat := tx.Category()
+			if cat == "" {
+				cat = UncategorisedName
+			}
+			ct, ok := totals[c][cat]
+			if !ok {
+				ct = &CategoryTotal{Category: cat}
+				totals[c][cat] = ct
+				cc.Totals = append(cc.Totals, ct)
+			}
+			if tx.TxType() == TX_TYPE_DEB {
+				ct.Debit = ct.Debit + tx.AbsValue()
+				cc.Debit = cc.Debit + tx.AbsValue()
+			} else {
+				ct.Credit = ct.Credit + tx.AbsValue()
+				cc.Credit = cc.Credit + tx.AbsValue()
+			}
+			ct.Count++
+			cc.Count++
+			rep.Count++
+		}
+	}
+	sort.Slice(rep.Currencies, func(i, j int) bool {
+		ci, cj := rep.Currencies[i].Currency, rep.Currencies[j].Currency
+		if ci == DefaultBaseCurrency || cj == DefaultBaseCurrency {
+			return ci == DefaultBaseCurrency && cj != DefaultBaseCurrency
+		}
+		return ci < cj
+	})
+	for _, cc := range rep.Currencies {
+		sort.Slice(cc.Totals, func(i, j int) bool {
+			ci, cj := cc.Totals[i].Category, cc.Totals[j].Category
+			if ci == UncategorisedName || cj == UncategorisedName {
+				return cj == UncategorisedName && ci != UncategorisedName
+			}
+			return strings.ToLower(ci) < strings.ToLower(cj)
+		})
+	}
+	return rep
+}
+
+func (t *CategoryTotal) Net() Money {
+	return t.Credit - t.Debit
+}
+
+func (c *CurrencyCategoryTotals) Net() Money {
+	return c.Credit - c.Debit
+}
+
+//
+// The report title and period
+//
+func (r *CategoryReport) Heading() string {
+	return fmt.Sprintf("%s: %s to %s", r.Title, r.From.Format(DATE_FORMAT_TXN), r.To.Format(DATE_FORMAT_TXN))
+}
+
+//
+// The width of the category column so all currencies line up
+//
+func (r *CategoryReport) CategoryWidth() int {
+	w := len(UncategorisedName)
+	for _, c := range r.Currencies {
+		for _, t := range c.Totals {
+			if len(t.Category) > w {
+				w = len(t.Category)
+			}
+		}
+	}
+	return w
+}
+
+//
+// The totals for the currency as lines of text with fixed width columns.
+// The first line is the column heading and the last line is the total.
+//
+func (c *CurrencyCategoryTotals) Lines(w int) []string {
+	lines := []string{fmt.Sprintf("%-*s %6s %12s %12s %12s", w, "Category", "Count", "In "+c.Currency, "Out "+c.Currency, "Net "+c.Currency)}
+	for _, t := range c.Totals {
+		lines = append(lines, fmt.Sprintf("%-*s %6d %12s %12s %12s", w, t.Category, t.Count, t.Credit, t.Debit, t.Net()))
+	}
+	return append(lines, fmt.Sprintf("%-*s %6d %12s %12s %12s", w, "Total", c.Count, c.Credit, c.Debit, c.Net()))
+}
+
+//
+// The report as lines of text. The heading then the lines for each currency separated by an empty line.
+//
+func (r *CategoryReport) Lines() []string {
+	w := r.CategoryWidth()
+	lines := []string{r.Heading()}
+	for i, c := range r.Currencies {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, c.Lines(w)...)
+	}
+	return lines
+}
+
+func (r *CategoryReport) String() string {
+	return strings.Join(r.Lines(), "\n")
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+type CompareEnum int
+
+const (
+	COMPARE_ONLY_HERE CompareEnum = iota
+	COMPARE_ONLY_OTHER
+	COMPARE_CHANGED
+)
+
+var CompareNames = []string{"Only here", "Only in other", "Changed"}
+
+type CompareResult struct {
+	Path  *parser.Path // user|pwHints|hint|field or exchangeRates|currency
+	Issue CompareEnum
+	Desc  string
+}
+
+func (r *CompareResult) String() string {
+	if r.Desc == "" {
+		return fmt.Sprintf("%s: %s", CompareNames[r.Issue], r.Path.String())
+	}
+	return fmt.Sprintf("%s: %s %s", CompareNames[r.Issue], r.Path.String(), r.Desc)
+}
+
+//
+// Compare this data with other data (for example the data file changed by another program).
+// Users, hints, assets and fields are compared by name. List entries (transactions etc) are compared by value.
+// The time stamp and change history are not compared.
+//
+func (p *JsonData) CompareWith(content []byte) ([]*CompareResult, error) {
+	other, err := NewJsonData(content, func(string, *parser.Path, error) {})
+	if err != nil {
+		return nil, fmt.Errorf("the data to compare could not be read. %s", err.Error())
+	}
+	results := make([]*CompareResult, 0)
+	results = compareObject(results, parser.NewBarPath(""), p.GetUserRoot(), other.GetUserRoot())
+	return compareNamed(results, parser.NewBarPath(""), p.dataMap, other.dataMap, IdExchangeRates), nil
+}
+
+func compareNamed(results []*CompareResult, path *parser.Path, here, other *parser.JsonObject, name string) []*CompareResult {
+	h := here.GetNodeWithName(name)
+	o := other.GetNodeWithName(name)
+	p := comparePath(path, name)
+	switch {
+	case h == nil && o == nil:
+		return results
+	case o == nil:
+		return append(results, &CompareResult{Path: p, Issue: COMPARE_ONLY_HERE})
+	case h == nil:
+		return append(results, &CompareResult{Path: p, Issue: COMPARE_ONLY_OTHER})
+	case h.GetNodeType() != o.GetNodeType():
+		return append(results, &CompareResult{Path: p, Issue: COMPARE_CHANGED})
+	}
+	switch h.GetNodeType() {
+	case parser.NT_OBJECT:
+		return compareObject(results, p, h.(*parser.JsonObject), o.(*parser.JsonObject))
+	case parser.NT_LIST:
+		return compareList(results, p, h.(*parser.JsonList), o.(*parser.JsonList))
+	}
+	if mergeKey(h) != mergeKey(o) {
+		return append(results, &CompareResult{Path: p, Issue: COMPARE_CHANGED})
+	}
+	return results
+}
+
+func compareObject(results []*CompareResult, path *parser.Path, here, other *parser.JsonObject) []*CompareResult {
+	names := here.GetSortedKeys()
+	for _, name := range other.GetSortedKeys() {
+		if here.GetNodeWithName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		results = compareNamed(results, path, here, other, name)
+	}
+	return results
+}
+
+//
+// Lists are different if an entry (compared by mergeKey) is only in one of them.
+//
+func compareList(results []*CompareResult, path *parser.Path, here, other *parser.JsonList) []*CompareResult {
+	counts := make(map[string]int)
+	for _, v := range here.GetValues() {
+		counts[mergeKey(v)]++
+	}
+	for _, v := range other.GetValues() {
+		counts[mergeKey(v)]--
+	}
+	onlyHere := 0
+	onlyOther := 0
+	for _, c := range counts {
+		if c > 0 {
+			onlyHere = onlyHere + c
+		} else {
+			onlyOther = onlyOther - c
+		}
+	}
+	if onlyHere > 0 || onlyOther > 0 {
+		results = append(results, &CompareResult{Path: path, Issue: COMPARE_CHANGED, Desc: fmt.Sprintf("(%d entries only here, %d only in other)", onlyHere, onlyOther)})
+	}
+	return results
+}
+
+//
+// A new path. Path.StringAppend can share the slice of the original path.
+//
+func comparePath(path *parser.Path, name string) *parser.Path {
+	if path.IsEmpty() {
+		return parser.NewBarPath(name)
+	}
+	return parser.NewBarPath(path.String() + PATH_SEP + name)
+}
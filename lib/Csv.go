@@ -2,14 +2,49 @@ package lib
 
 import (
 	"bufio"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
 
+const (
+	csvBOM = '\uFEFF' // Byte order mark
+)
+
+//
+// Return the delimiter for a name. Empty is a comma. 'tab' or '\t' is a tab.
+// Otherwise the delimiter must be a single character.
+//
+func CsvDelimiter(name string) (rune, error) {
+	switch strings.ToLower(name) {
+	case "", ",", "comma":
+		return ',', nil
+	case "\t", "\\t", "tab":
+		return '\t', nil
+	case ";", "semicolon":
+		return ';', nil
+	}
+	r := []rune(name)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' || r[0] == csvBOM {
+		return 0, fmt.Errorf("csv delimiter '%s' is not valid. It must be a single character", name)
+	}
+	return r[0], nil
+}
+
 func ParseFileToMap(fileName string, skipRowZero bool, names []string) ([]map[string]string, error) {
+	return ParseFileToMapWithDelimiter(fileName, ',', skipRowZero, names)
+}
+
+//
+// Parse a CSV file in to a list of maps (one per row). Each column value is mapped using the name at the same index in names.
+// Columns with an empty name are ignored.
+//
+func ParseFileToMapWithDelimiter(fileName string, delimiter rune, skipRowZero bool, names []string) ([]map[string]string, error) {
 	m := make([]map[string]string, 0)
-	err := ParseFile(fileName, func(row, col int, s string) error {
+	err := ParseFileWithDelimiter(fileName, delimiter, func(row, col int, s string) error {
 		if skipRowZero {
 			if row == 0 {
 				return nil
@@ -33,50 +68,69 @@ func ParseFileToMap(fileName string, skipRowZero bool, names []string) ([]map[st
 }
 
 func ParseFile(fileName string, call func(int, int, string) error) error {
+	return ParseFileWithDelimiter(fileName, ',', call)
+}
+
+//
+// Parse a CSV file (RFC 4180). Quoted fields can contain the delimiter, escaped ("") quotes and new lines.
+// A leading byte order mark is removed. Blank lines are skipped. Values are trimmed.
+// call(row, col, value) is called for each value. row and col start at 0.
+// Errors are reported with the row (line) and column number (starting at 1).
+//
+func ParseFileWithDelimiter(fileName string, delimiter rune, call func(int, int, string) error) error {
 	f, err := os.Open(fileName)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	row := 0
-	for scanner.Scan() {
-		s := scanner.Text()
-		s = strings.TrimSpace(s)
-		if len(s) > 1 {
-			err := parseLine(fileName, row, s, call)
-			if err != nil {
-				return err
-			}
-			row++
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return err
+	err = ParseCsv(f, delimiter, call)
+	if err != nil {
+		return fmt.Errorf("file '%s' %s", fileName, err.Error())
 	}
 	return nil
 }
 
-func parseLine(fileName string, row int, line string, call func(int, int, string) error) error {
-	col := 0
-	var sb strings.Builder
-	for _, c := range line {
-		if c == ',' {
-			err := call(row, col, strings.TrimSpace(sb.String()))
+//
+// Parse CSV data (RFC 4180) from a reader. See ParseFileWithDelimiter.
+//
+func ParseCsv(r io.Reader, delimiter rune, call func(int, int, string) error) error {
+	br := bufio.NewReader(r)
+	c, _, err := br.ReadRune()
+	if err == nil && c != csvBOM {
+		br.UnreadRune()
+	}
+	reader := csv.NewReader(br)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	reader.TrimLeadingSpace = delimiter != '\t' && delimiter != ' ' // Allow: a, "b, c"
+	row := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				return fmt.Errorf("row %d column %d: %s", pe.Line, pe.Column, pe.Err.Error())
+			}
+			return err
+		}
+		if isBlankCsvRecord(record) {
+			continue
+		}
+		for col, v := range record {
+			err = call(row, col, strings.TrimSpace(v))
 			if err != nil {
-				return err
+				line, _ := reader.FieldPos(col)
+				return fmt.Errorf("row %d column %d: %s", line, col+1, err.Error())
 			}
-			col++
-			sb.Reset()
-		} else {
-			sb.WriteRune(c)
 		}
+		row++
 	}
-	err := call(row, col, strings.TrimSpace(sb.String()))
-	if err != nil {
-		return err
-	}
-	return nil
+}
+
+func isBlankCsvRecord(record []string) bool {
+	return len(record) == 1 && strings.TrimSpace(record[0]) == ""
 }
@@ -0,0 +1,247 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+//
+// An asset account can have a currency code. E.g. "currency": "EUR".
+// Accounts without a currency are in DefaultBaseCurrency. The base currency used to
+// display the converted totals is a separate (local) preference and can be changed.
+//
+// The exchange rates are in the data root:
+//	"exchangeRates": {"GBP": 1, "EUR": 1.17, "USD": 1.27}
+// Each rate is the number of units of the currency for one unit of a common reference currency.
+// The reference is usually the base currency (rate 1) but any currency can be used.
+//
+const (
+	IdCurrency          = "currency"      // Optional. On an asset account
+	IdExchangeRates     = "exchangeRates" // Optional. In the data root
+	DefaultBaseCurrency = "GBP"
+)
+
+var (
+	currencySymbols = map[string]string{"GBP": "£", "EUR": "€", "USD": "$", "JPY": "¥"}
+)
+
+type ExchangeRates map[string]float64
+
+//
+// Totals for all of the accounts in a currency.
+// Converted is the total in the base currency. It is only valid if HasRate is true.
+//
+type CurrencyTotal struct {
+	Currency  string
+	Total     Money
+	Converted Money
+	HasRate   bool
+	Count     int
+}
+
+type AssetSummary struct {
+	Base       string
+	Totals     []*CurrencyTotal // Sorted by currency. The base currency is first
+	GrandTotal Money            // The sum of the converted totals
+	Missing    []string         // Currencies without an exchange rate. Not in the GrandTotal
+}
+
+func NormaliseCurrency(s string) string {
+	return strings.ToUpper(strings.TrimSpace(s))
+}
+
+//
+// A currency code is 3 letters (ISO 4217). E.g. GBP, EUR, USD
+//
+func ValidateCurrency(s string) error {
+	c := NormaliseCurrency(s)
+	if len(c) != 3 || strings.Trim(c, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return fmt.Errorf("currency '%s' must be a 3 letter code. E.g. GBP, EUR, USD", s)
+	}
+	return nil
+}
+
+//
+// Format a value in a currency. E.g. "£12.50", "-€3.00" or "12.50 CHF".
+// Without a currency the value is formatted as a number. E.g. "    12.50"
+//
+func FormatMoney(v Money, currency string) string {
+	c := NormaliseCurrency(currency)
+	if c == "" {
+		return fmt.Sprintf("%9s", v)
+	}
+	sym, ok := currencySymbols[c]
+	if !ok {
+		return fmt.Sprintf("%9s %s", v, c)
+	}
+	s := fmt.Sprintf("%s%s", sym, v.Abs())
+	if v < 0 {
+		s = "-" + s
+	}
+	return fmt.Sprintf("%10s", s)
+}
+
+//
+// Return the exchange rates from the data root. Rates that are not positive numbers are ignored.
+//
+func GetExchangeRates(root parser.NodeI) ExchangeRates {
+	rates := make(ExchangeRates)
+	if root == nil || root.GetNodeType() != parser.NT_OBJECT {
+		return rates
+	}
+	n := root.(*parser.JsonObject).GetNodeWithName(IdExchangeRates)
+	if n == nil || n.GetNodeType() != parser.NT_OBJECT {
+		return rates
+	}
+	for _, v := range n.(*parser.JsonObject).GetValues() {
+		if v.GetNodeType() == parser.NT_NUMBER {
+			r := v.(*parser.JsonNumber).GetValue()
+			if r > 0 {
+				rates[NormaliseCurrency(v.GetName())] = r
+			}
+		}
+	}
+	return rates
+}
+
+//
+// Replace the exchange rates in the data root. An empty list removes them.
+// The selected path is unchanged (it is returned to dataMapUpdated).
+//
+func (p *JsonData) SetExchangeRates(rates ExchangeRates, selectedPath *parser.Path) error {
+	rn := parser.NewJsonObject(IdExchangeRates)
+	for c, r := range rates {
+		if err := ValidateCurrency(c); err != nil {
+			return err
+		}
+		if r <= 0 {
+			return fmt.Errorf("exchange rate for '%s' must be greater than 0", c)
+		}
+		rn.Add(parser.NewJsonNumber(NormaliseCurrency(c), r))
+	}
+	before := p.beginUndo()
+	if old := p.dataMap.GetNodeWithName(IdExchangeRates); old != nil {
+		p.dataMap.Remove(old)
+	}
+	if rn.Len() > 0 {
+		p.dataMap.Add(rn)
+	}
+	p.pushUndo("Exchange Rates", selectedPath.String(), selectedPath.String(), before)
+	p.dataMapUpdated("Exchange Rates updated", selectedPath, nil)
+	return nil
+}
+
+func (r ExchangeRates) Equal(o ExchangeRates) bool {
+	if len(r) != len(o) {
+		return false
+	}
+	for c, v := range r {
+		if ov, ok := o[c]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (r ExchangeRates) HasRate(currency string) bool {
+	_, ok := r[NormaliseCurrency(currency)]
+	return ok
+}
+
+//
+// Convert a value from one currency to another. The same currency does not need a rate.
+// The result is rounded to the nearest minor unit.
+//
+func (r ExchangeRates) Convert(v Money, from, to string) (Money, error) {
+	f, t := NormaliseCurrency(from), NormaliseCurrency(to)
+	if f == t {
+		return v, nil
+	}
+	rf, ok := r[f]
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate for '%s'", f)
+	}
+	rt, ok := r[t]
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate for '%s'", t)
+	}
+	return MoneyFromFloat(v.Float64() / rf * rt), nil
+}
+
+//
+// Return the currencies used by the accounts. Accounts without a currency use DefaultBaseCurrency.
+//
+func AccountCurrencies(accounts []*AccountData) []string {
+	found := make(map[string]bool)
+	l := make([]string, 0)
+	for _, acc := range accounts {
+		c := acc.CurrencyCode()
+		if !found[c] {
+			found[c] = true
+			l = append(l, c)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
+//
+// Total the closing values of the accounts for each currency and convert the totals to the base currency.
+// Accounts without a currency are in DefaultBaseCurrency, whatever the base currency is.
+//
+func NewAssetSummary(accounts []*AccountData, rates ExchangeRates, base string) *AssetSummary {
+	b := NormaliseCurrency(base)
+	if b == "" {
+		b = DefaultBaseCurrency
+	}
+	sum := &AssetSummary{Base: b, Totals: make([]*CurrencyTotal, 0), Missing: make([]string, 0)}
+	totals := make(map[string]*CurrencyTotal)
+	for _, acc := range accounts {
+		c := acc.CurrencyCode()
+		ct, ok := totals[c]
+		if !ok {
+			ct = &CurrencyTotal{Currency: c}
+			totals[c] = ct
+			sum.Totals = append(sum.Totals, ct)
+		}
+		ct.Total = ct.Total + acc.ClosingValue
+		ct.Count++
+	}
+	sort.Slice(sum.Totals, func(i, j int) bool {
+		ci, cj := sum.Totals[i].Currency, sum.Totals[j].Currency
+		if ci == b || cj == b {
+			return ci == b && cj != b
+		}
+		return ci < cj
+	})
+	for _, ct := range sum.Totals {
+		v, err := rates.Convert(ct.Total, ct.Currency, b)
+		if err != nil {
+			sum.Missing = append(sum.Missing, ct.Currency)
+			continue
+		}
+		ct.Converted = v
+		ct.HasRate = true
+		sum.GrandTotal = sum.GrandTotal + v
+	}
+	return sum
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ExportFormatCsv  = "csv"
+	ExportFormatJson = "json"
+	ExportFormatQif  = "qif"
+)
+
+var (
+	ExportFileExtensions = []string{".csv", ".json", QifFileExtension}
+	exportCsvHeader      = []string{"Date", "Reference", "Type", "In", "Out", "Balance", "Category"}
+)
+
+//
+// A transaction as it is exported to JSON
+//
+type exportTransaction struct {
+	Date     string  `json:"date"`
+	Ref      string  `json:"ref"`
+	Type     string  `json:"type"`
+	Val      float64 `json:"val"`
+	Balance  float64 `json:"balance"`
+	Category string  `json:"category,omitempty"`
+}
+
+//
+// Return the export format for a file name from its extension
+//
+func ExportFormatForFile(fileName string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, e := range ExportFileExtensions {
+		if e == ext {
+			return ext[1:], nil
+		}
+	}
+	return "", fmt.Errorf("cannot export to file '%s'. The file type must be one of %s", filepath.Base(fileName), ExportFileExtensions)
+}
+
+//
+// Return true if the filter is empty or is found in the date, ref, value, balance or category of the transaction.
+//
+func (t *TranactionData) Matches(filter string) bool {
+	if filter == "" {
+		return true
+	}
+	for _, s := range []string{t.DateTime(), t.Ref(), t.Val(), t.LineVal(), t.Category()} {
+		if strings.Contains(s, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// The transactions in display order that match the filter.
+// The initial value (iv) is always included as the running balance starts with it.
+//
+func (t *AccountData) FilteredTransactions(filter string) []*TranactionData {
+	txs := make([]*TranactionData, 0)
+	for _, tx := range t.Transactions {
+		if tx.TxType() == TX_TYPE_IV || tx.Matches(filter) {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+//
+// Write the transactions of an account that match the filter as CSV or JSON.
+// Each transaction includes the running balance (the line value).
+// Use ExportQif for QIF.
+//
+func ExportTransactions(w io.Writer, acc *AccountData, format, filter string) error {
+	txs := acc.FilteredTransactions(filter)
+	for _, tx := range txs {
+		if tx.HasError() {
+			return fmt.Errorf("account '%s' transaction %s", acc.AccountName, tx.err.Error())
+		}
+	}
+	switch format {
+	case ExportFormatCsv:
+		return exportCsv(w, txs)
+	case ExportFormatJson:
+		return exportJson(w, txs)
+	}
+	return fmt.Errorf("export format '%s' is not valid. Use '%s' or '%s'", format, ExportFormatCsv, ExportFormatJson)
+}
+
+func exportCsv(w io.Writer, txs []*TranactionData) error {
+	cw := csv.NewWriter(w)
+	cw.Write(exportCsvHeader)
+	for _, tx := range txs {
+		in, out := "", ""
+		switch tx.TxType() {
+		case TX_TYPE_DEB:
+			out = tx.AbsValue().String()
+		default:
+			in = tx.AbsValue().String()
+		}
+		cw.Write([]string{tx.DateTime(), tx.Ref(), string(tx.TxType()), in, out, tx.LineValue().String(), tx.Category()})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func exportJson(w io.Writer, txs []*TranactionData) error {
+	l := make([]*exportTransaction, 0)
+	for _, tx := range txs {
+		l = append(l, &exportTransaction{Date: tx.DateTime(), Ref: tx.Ref(), Type: string(tx.TxType()), Val: tx.AbsValue().Float64(), Balance: tx.LineValue().Float64(), Category: tx.Category()})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(map[string][]*exportTransaction{IdTxTransactions: l})
+}
@@ -17,9 +17,11 @@
 package lib
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -27,12 +29,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/stuartdd2/JsonParser4go/parser"
-
 	"golang.org/x/crypto/scrypt"
 )
 
@@ -56,6 +57,7 @@ type BackupFileDef struct {
 	post       string
 	max        int
 	tempSource string
+	log        func(string) // Optional. Where CleanFiles reports the files it removes
 }
 
 type FileData struct {
@@ -67,17 +69,52 @@ type FileData struct {
 	content       []byte
 	isEmpty       bool
 	isEncrypted   bool
+	encVersion    int
+	lockedContent []byte             // Content encrypted in memory while locked
+	lockedModel   []byte             // Model (un-saved) data encrypted in memory while locked
+	verifier      func([]byte) error // Optional check of the (decrypted) content before it replaces the file
+	revision      string             // Revision (ETag) of the remote data when loaded or stored
+	watcher       *FileWatcher       // Optional. Stores are done in watcher.Update so they are not reported as changes
+}
+
+//
+// The header written in front of the encrypted (base64) data.
+//	ENCTEST:<version>:<base64 salt>:<N>:<r>:<p>\n
+// Files without a header are legacy (version 0) files that use encSalt and encIterations.
+//
+type encHeader struct {
+	version int
+	salt    []byte
+	n       int
+	r       int
+	p       int
 }
 
+const (
+	encMagic       = "ENCTEST"
+	encVersion     = 1
+	encSaltLen     = 32
+	encHeaderParts = 6
+	encMaxN        = 1024 * 1024       // Refuse headers that would make scrypt unreasonably slow or large
+	encMaxR        = 32                // scrypt memory is 128 * N * r bytes
+	encMaxP        = 16                // scrypt time is proportional to N * r * p
+	encMaxMem      = 256 * 1024 * 1024 // The most memory (128 * N * r) allowed. 4 times the default
+	encMaxNRP      = 1024 * 1024 * 8   // The most work (N * r * p) allowed. 16 times the default
+)
+
 /**
-Changing any of this will prevent previous encryptions from being decrypted.
+Changing any of this will prevent LEGACY (version 0) encryptions from being decrypted.
 More encIterations will produce slower encryption and decryption times
 Note that encIterations is multiplied by 1024
+Version 1 files store the salt and the scrypt N, r and p values in the file header.
 */
 var (
 	supportedImageExtenstions = []string{".jpg", "png", ".svg"}
 	encIterations             = 64                                         // Keep as power of 2.
 	encSalt                   = []byte("SQhMXVt8rQED2MxHTHxmuZLMxdJz5DQI") // Keep as 32 randomly generated chars
+	encScryptN                = 1024 * encIterations                       // Used for new files. Must be power of 2.
+	encScryptR                = 8
+	encScryptP                = 1
 )
 
 func FileExists(fileName string) bool {
@@ -91,11 +128,22 @@ func NewBackupFileDef(path, sep, pre, mask, post string, max int64) *BackupFileD
 	return &BackupFileDef{path: path, sep: sep, pre: pre, mask: mask, post: post, max: int(max)}
 }
 
+//
+// Report removed files with log instead of printing them.
+//
+func (r *BackupFileDef) SetLog(log func(string)) {
+	r.log = log
+}
+
 func (r *BackupFileDef) CleanFiles() error {
 	list2, _ := r.ListFiles()
 	if len(list2) > int(r.max) {
 		for i := 0; i < len(list2)-r.max; i++ {
-			fmt.Printf("Remove file %s\n", list2[i])
+			if r.log != nil {
+				r.log(fmt.Sprintf("Remove file %s", list2[i]))
+			} else {
+				fmt.Printf("Remove file %s\n", list2[i])
+			}
 			err := os.Remove(fmt.Sprintf("%s%s%s", r.path, r.sep, list2[i]))
 			if err != nil {
 				return fmt.Errorf("could not delete backup file %s%s%s.\nError: %s", r.path, r.sep, list2[i], err.Error())
@@ -161,6 +209,24 @@ func (r *BackupFileDef) IsRequired() bool {
 	return r.path != ""
 }
 
+func (r *BackupFileDef) GetPath() string {
+	return r.path
+}
+
+//
+// The full name of the newest backup file. Empty if there are none.
+//
+func (r *BackupFileDef) GetLatest() string {
+	if !r.IsRequired() {
+		return ""
+	}
+	l, err := r.ListFiles()
+	if err != nil || len(l) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s%s%s", r.path, r.sep, l[len(l)-1])
+}
+
 func (r *BackupFileDef) ComposeFullName() string {
 	return fmt.Sprintf("%s%s%s", r.path, r.sep, r.ComposeFileName())
 }
@@ -169,19 +235,26 @@ func (r *BackupFileDef) ComposeTemplateName() string {
 	return fmt.Sprintf("%s%c%s%s%s", r.path, os.PathSeparator, r.pre, r.mask, r.post)
 }
 
+//
+// The mask can contain %d (date), %h (hour), %m (minute), %s (second) and %n (nanosecond).
+//
 func (r *BackupFileDef) ComposeFileName() string {
+	now := time.Now()
 	mfn := r.mask
 	if strings.Contains(mfn, "%d") {
-		mfn = strings.ReplaceAll(mfn, "%d", time.Now().Format("2006-01-02"))
+		mfn = strings.ReplaceAll(mfn, "%d", now.Format("2006-01-02"))
 	}
 	if strings.Contains(mfn, "%h") {
-		mfn = strings.ReplaceAll(mfn, "%h", strPad2(time.Now().Hour()))
+		mfn = strings.ReplaceAll(mfn, "%h", strPad2(now.Hour()))
 	}
 	if strings.Contains(mfn, "%m") {
-		mfn = strings.ReplaceAll(mfn, "%m", strPad2(time.Now().Minute()))
+		mfn = strings.ReplaceAll(mfn, "%m", strPad2(now.Minute()))
 	}
 	if strings.Contains(mfn, "%s") {
-		mfn = strings.ReplaceAll(mfn, "%s", strPad2(time.Now().Second()))
+		mfn = strings.ReplaceAll(mfn, "%s", strPad2(now.Second()))
+	}
+	if strings.Contains(mfn, "%n") {
+		mfn = strings.ReplaceAll(mfn, "%n", fmt.Sprintf("%09d", now.Nanosecond()))
 	}
 	return fmt.Sprintf("%s%s%s", r.pre, mfn, r.post)
 }
@@ -198,36 +271,43 @@ func (r *FileData) DecryptContents(encKey []byte) error {
 	if r.IsEmpty() {
 		return errors.New("cannot decrypt empty content data")
 	}
-	cont, err := decrypt(encKey, r.content)
+	cont, version, err := decrypt(encKey, r.content)
 	if err != nil {
 		return err
 	}
-	r.key = encKey
+	r.key = append([]byte{}, encKey...) // A copy so it can be cleared without changing the caller's data
 	r.content = cont
+	r.encVersion = version
 	return nil
 }
 
+//
+// Encrypt the content with a new random salt and store it.
+// Legacy (version 0) files are upgraded to the current version by this.
+//
 func (r *FileData) StoreContentEncrypted(encKey []byte, callbackWhenDone func()) error {
 	cont, err := encrypt(encKey, r.content)
 
 	if err != nil {
 		return err
 	}
-	err = r.storeData(cont)
+	err = r.storeData(cont, encKey)
 	if err != nil {
 		return err
 	}
-	r.key = encKey
+	r.key = append([]byte{}, encKey...)
+	r.encVersion = encVersion
 	callbackWhenDone()
 	return nil
 }
 
 func (r *FileData) StoreContentUnEncrypted(callbackWhenDone func()) error {
-	r.key = make([]byte, 0)
-	err := r.storeData(r.content)
+	err := r.storeData(r.content, nil)
 	if err != nil {
 		return err
 	}
+	r.key = make([]byte, 0)
+	r.encVersion = 0
 	callbackWhenDone()
 	return nil
 }
@@ -269,6 +349,81 @@ func (r *FileData) HasEncData() bool {
 	return len(r.key) > 0
 }
 
+//
+// Check a key (password) against the one used to decrypt or store the data.
+// Returns false if the data has no key.
+//
+func (r *FileData) KeyMatches(key []byte) bool {
+	if !r.HasEncData() {
+		return false
+	}
+	return subtle.ConstantTimeCompare(r.key, key) == 1
+}
+
+//
+// The version of the encryption format of the data when it was loaded (or last saved).
+// 0 is a legacy file without a header or a file that is not encrypted.
+//
+func (r *FileData) GetEncVersion() int {
+	return r.encVersion
+}
+
+//
+// Lock the data in memory. The content and the model (which may contain un-saved changes)
+// are encrypted with the key and the key and content are cleared.
+// Unlock with the same key to get the model back. Data cannot be stored while it is locked.
+//
+func (r *FileData) Lock(model []byte) error {
+	if r.IsLocked() {
+		return errors.New("data is already locked")
+	}
+	if !r.HasEncData() {
+		return errors.New("cannot lock data that has no key")
+	}
+	lc, err := encrypt(r.key, r.content)
+	if err != nil {
+		return err
+	}
+	lm, err := encrypt(r.key, model)
+	if err != nil {
+		return err
+	}
+	r.lockedContent = lc
+	r.lockedModel = lm
+	clearBytes(r.content)
+	clearBytes(r.key)
+	r.key = make([]byte, 0)
+	r.content = make([]byte, 0)
+	return nil
+}
+
+//
+// Unlock data locked by Lock. The key must be the one used to decrypt or store the data.
+// Returns the model passed to Lock. The data remains locked if the key is wrong.
+//
+func (r *FileData) Unlock(key []byte) ([]byte, error) {
+	if !r.IsLocked() {
+		return nil, errors.New("data is not locked")
+	}
+	cont, _, err := decrypt(key, r.lockedContent)
+	if err != nil {
+		return nil, errors.New("the password is incorrect")
+	}
+	model, _, err := decrypt(key, r.lockedModel)
+	if err != nil {
+		return nil, errors.New("the password is incorrect")
+	}
+	r.key = append([]byte{}, key...)
+	r.content = cont
+	r.lockedContent = nil
+	r.lockedModel = nil
+	return model, nil
+}
+
+func (r *FileData) IsLocked() bool {
+	return r.lockedContent != nil
+}
+
 func (r *FileData) GetFileName() string {
 	return r.fileName
 }
@@ -294,12 +449,106 @@ func (r *FileData) SetContent(data []byte) {
 	r.content = data
 }
 
-func (r *FileData) storeData(data []byte) error {
+//
+// Stores are done in watcher.Update so that our own saves are not reported as changes.
+// A store fails with ErrFileChanged if the data file has been changed by another program.
+//
+func (r *FileData) SetWatcher(watcher *FileWatcher) {
+	r.watcher = watcher
+}
+
+//
+// The local paths to watch for changes by other programs. The data file (if it is not remote) and the backup directory.
+//
+func (r *FileData) GetWatchPaths() []string {
+	paths := make([]string, 0)
+	if !r.IsRemote() {
+		paths = append(paths, r.fileName)
+	}
+	if r.backupFileDef.IsRequired() {
+		paths = append(paths, r.backupFileDef.GetPath())
+	}
+	return paths
+}
+
+//
+// The full name of the newest backup file. Empty if there are none.
+//
+func (r *FileData) GetLatestBackup() string {
+	return r.backupFileDef.GetLatest()
+}
+
+//
+// Read a local file (the data file or a backup) and decrypt it with the key used to load or store the data.
+//
+func (r *FileData) ReadStoredContent(fileName string) ([]byte, error) {
+	dat, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return r.decryptStored(dat)
+}
+
+//
+// Decrypt stored data (local or remote) with the key used to load or store the data. Raw json is returned as is.
+//
+func (r *FileData) decryptStored(dat []byte) ([]byte, error) {
+	fd := &FileData{content: dat}
+	if fd.IsRawJson() {
+		return dat, nil
+	}
+	if !r.HasEncData() {
+		return nil, errors.New("the stored data is encrypted. The local data has no key")
+	}
+	dat, _, err := decrypt(r.key, dat)
+	if err != nil {
+		return nil, fmt.Errorf("the stored data could not be decrypted. %s", err.Error())
+	}
+	return dat, nil
+}
+
+//
+// Set a check that the stored content can be used. It is called with the decrypted content
+// read back from the temp file before the temp file replaces the data file. E.g. VerifyJsonData
+//
+func (r *FileData) SetVerifier(verifier func([]byte) error) {
+	r.verifier = verifier
+}
+
+//
+// Store the data (encrypted with key if key is not empty).
+// A local file is written to a temp file in the same directory, synced, read back and verified
+// and then renamed over the data file. The data file is not changed if any step fails.
+// Remote data is verified before it is posted with the revision it was loaded from. A backup is only written if the store succeeds.
+//
+func (r *FileData) storeData(data []byte, key []byte) error {
+	if r.IsLocked() {
+		return errors.New("cannot store data while it is locked")
+	}
+	if r.watcher != nil {
+		return r.watcher.Update(r.fileName, func() error {
+			return r.storeDataAndBackup(data, key)
+		})
+	}
+	return r.storeDataAndBackup(data, key)
+}
+
+func (r *FileData) storeDataAndBackup(data []byte, key []byte) error {
 	var err error
 	if r.postDataUrl != "" {
-		_, err = parser.PostJsonBytes(fmt.Sprintf("%s/%s", r.postDataUrl, r.fileName), data)
+		err = r.verifyData(data, key)
+		if err == nil {
+			var revision string
+			revision, err = postRemote(fmt.Sprintf("%s/%s", r.postDataUrl, r.fileName), data, r.revision)
+			if err == nil {
+				r.revision = revision
+			}
+		}
 	} else {
-		err = ioutil.WriteFile(r.fileName, data, 0644)
+		err = r.writeFileVerified(data, key)
+	}
+	if err != nil {
+		return err
 	}
 	if r.backupFileDef.IsRequired() {
 		mfn := r.backupFileDef.ComposeFullName()
@@ -312,7 +561,80 @@ func (r *FileData) storeData(data []byte) error {
 			fmt.Printf("Error deleting Backup file [%s]. Error message: %s\n", mfn, err3)
 		}
 	}
-	return err
+	return nil
+}
+
+func (r *FileData) writeFileVerified(data []byte, key []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(r.fileName), filepath.Base(r.fileName)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temp file for '%s'. %s", r.fileName, err.Error())
+	}
+	tmpName := tmp.Name()
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmpName)
+		}
+	}()
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err2 := tmp.Close()
+	if err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("could not write temp file '%s'. %s", tmpName, err.Error())
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(r.fileName); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err = os.Chmod(tmpName, mode); err != nil {
+		return fmt.Errorf("could not set the mode of temp file '%s'. %s", tmpName, err.Error())
+	}
+	written, err := ioutil.ReadFile(tmpName)
+	if err != nil {
+		return fmt.Errorf("could not read back temp file '%s'. %s", tmpName, err.Error())
+	}
+	if err = r.verifyData(written, key); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, r.fileName); err != nil {
+		return fmt.Errorf("could not replace '%s' with temp file '%s'. %s", r.fileName, tmpName, err.Error())
+	}
+	renamed = true
+	// Sync the directory so the rename is durable. Not supported on all platforms so errors are ignored
+	if dir, err := os.Open(filepath.Dir(r.fileName)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+//
+// Check that data (as written) decrypts (if key is not empty) to the content being stored
+// and that the content passes the verifier if there is one.
+//
+func (r *FileData) verifyData(data []byte, key []byte) error {
+	plain := data
+	if len(key) > 0 {
+		var err error
+		plain, _, err = decrypt(key, data)
+		if err != nil {
+			return fmt.Errorf("saved data could not be decrypted. %s", err.Error())
+		}
+	}
+	if !bytes.Equal(plain, r.content) {
+		return errors.New("saved data does not match the data being saved")
+	}
+	if r.verifier != nil {
+		if err := r.verifier(plain); err != nil {
+			return fmt.Errorf("saved data could not be read. %s", err.Error())
+		}
+	}
+	return nil
 }
 
 func (r *FileData) loadData() error {
@@ -322,7 +644,7 @@ func (r *FileData) loadData() error {
 		dat, err = ioutil.ReadFile(r.backupFileDef.GetTempSource())
 	} else {
 		if r.getDataUrl != "" {
-			dat, err = parser.GetJson(fmt.Sprintf("%s/%s", r.getDataUrl, r.fileName))
+			dat, r.revision, err = getRemote(fmt.Sprintf("%s/%s", r.getDataUrl, r.fileName))
 		} else {
 			dat, err = ioutil.ReadFile(r.fileName)
 		}
@@ -332,6 +654,13 @@ func (r *FileData) loadData() error {
 	}
 	r.SetContent(dat)
 	r.isEncrypted = !r.IsRawJson()
+	r.encVersion = 0
+	if r.isEncrypted {
+		h, _, err := parseEncHeader(dat)
+		if err == nil && h != nil {
+			r.encVersion = h.version
+		}
+	}
 	return nil
 }
 
@@ -387,41 +716,58 @@ func checkSupportedImage(fileName string) ImageRefType {
 	return IMAGE_NOT_SUPPORTED
 }
 
-func decrypt(key []byte, data []byte) ([]byte, error) {
-
-	key, err := deriveKey(key)
+func decrypt(key []byte, data []byte) ([]byte, int, error) {
+	h, body, err := parseEncHeader(data)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	var dKey []byte
+	version := 0
+	if h == nil {
+		dKey, err = deriveKey(key, encSalt, 1024*encIterations, 8, 1)
+	} else {
+		version = h.version
+		dKey, err = deriveKey(key, h.salt, h.n, h.r, h.p)
+	}
+	if err != nil {
+		return nil, 0, err
 	}
 
-	blockCipher, err := aes.NewCipher(key)
+	blockCipher, err := aes.NewCipher(dKey)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	gcm, err := cipher.NewGCM(blockCipher)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	dd, err := base64.StdEncoding.DecodeString(string(data))
+	dd, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(body)))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	if len(dd) < gcm.NonceSize() {
+		return nil, 0, errors.New("decrypt: encrypted data is too short")
 	}
 
 	nonce, ciphertext := dd[:gcm.NonceSize()], dd[gcm.NonceSize():]
 
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return plaintext, nil
+	return plaintext, version, nil
 }
 
 func encrypt(key, data []byte) ([]byte, error) {
+	h := &encHeader{version: encVersion, salt: make([]byte, encSaltLen), n: encScryptN, r: encScryptR, p: encScryptP}
+	if _, err := rand.Read(h.salt); err != nil {
+		return nil, err
+	}
 
-	key, err := deriveKey(key)
+	key, err := deriveKey(key, h.salt, h.n, h.r, h.p)
 	if err != nil {
 		return nil, err
 	}
@@ -443,17 +789,74 @@ func encrypt(key, data []byte) ([]byte, error) {
 
 	ciphertext := gcm.Seal(nonce, nonce, data, nil)
 
-	return []byte(base64.StdEncoding.EncodeToString(ciphertext)), nil
+	return []byte(h.String() + base64.StdEncoding.EncodeToString(ciphertext)), nil
 }
 
-func deriveKey(key []byte) ([]byte, error) {
+func clearBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func deriveKey(key, salt []byte, n, r, p int) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, errors.New("deriveKey: key was not provided")
 	}
-	key, err := scrypt.Key(key, encSalt, 1024*encIterations, 8, 1, 32)
+	key, err := scrypt.Key(key, salt, n, r, p, 32)
 	if err != nil {
 		return nil, err
 	}
 
 	return key, nil
 }
+
+func (h *encHeader) String() string {
+	return fmt.Sprintf("%s:%d:%s:%d:%d:%d\n", encMagic, h.version, base64.StdEncoding.EncodeToString(h.salt), h.n, h.r, h.p)
+}
+
+//
+// Split the header from the encrypted data.
+// If there is no header (legacy file) then the header is nil and the data is returned as is.
+//
+func parseEncHeader(data []byte) (*encHeader, []byte, error) {
+	if !strings.HasPrefix(string(data), encMagic+":") {
+		return nil, data, nil
+	}
+	pos := strings.IndexByte(string(data), '\n')
+	if pos < 0 {
+		return nil, nil, errors.New("encryption header: header is not terminated")
+	}
+	parts := strings.Split(strings.TrimSpace(string(data[:pos])), ":")
+	if len(parts) != encHeaderParts {
+		return nil, nil, fmt.Errorf("encryption header: expected %d fields found %d", encHeaderParts, len(parts))
+	}
+	nums := make([]int, 0)
+	for _, i := range []int{1, 3, 4, 5} {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil || v < 1 {
+			return nil, nil, fmt.Errorf("encryption header: field %d value '%s' is invalid", i, parts[i])
+		}
+		nums = append(nums, v)
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil || len(salt) == 0 {
+		return nil, nil, errors.New("encryption header: salt is invalid")
+	}
+	h := &encHeader{version: nums[0], salt: salt, n: nums[1], r: nums[2], p: nums[3]}
+	if h.version > encVersion {
+		return nil, nil, fmt.Errorf("encryption header: version %d is not supported. Max version is %d", h.version, encVersion)
+	}
+	if h.n < 2 || h.n > encMaxN || h.n&(h.n-1) != 0 {
+		return nil, nil, fmt.Errorf("encryption header: N value %d must be a power of 2 and not more than %d", h.n, encMaxN)
+	}
+	if h.r > encMaxR || h.p > encMaxP {
+		return nil, nil, fmt.Errorf("encryption header: r value %d must not be more than %d and p value %d must not be more than %d", h.r, encMaxR, h.p, encMaxP)
+	}
+	if 128*h.n*h.r > encMaxMem {
+		return nil, nil, fmt.Errorf("encryption header: memory 128 * N * r (128 * %d * %d) must not be more than %d", h.n, h.r, encMaxMem)
+	}
+	if h.n*h.r*h.p > encMaxNRP {
+		return nil, nil, fmt.Errorf("encryption header: N * r * p (%d * %d * %d) must not be more than %d", h.n, h.r, h.p, encMaxNRP)
+	}
+	return h, data[pos+1:], nil
+}
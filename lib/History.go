@@ -0,0 +1,207 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+//
+// Field change history is kept in a hidden object in the data root (not in 'groups').
+// Each item is a list named with the (bar) path of the field. Oldest first:
+//	"history": {"user|pwHints|app|pre": [{"time": "2022-05-25 16:48:15", "value": "previous value"}]}
+//
+const (
+	historyName      = "history"
+	historyTimeName  = "time"
+	historyValueName = "value"
+)
+
+var (
+	historyMax = 10
+)
+
+type HistoryEntry struct {
+	Time  time.Time // When the value was changed
+	Value string    // The value before the change
+}
+
+func (h *HistoryEntry) String() string {
+	return fmt.Sprintf("%s '%s'", h.Time.Format(dateTimeFormatStr), h.Value)
+}
+
+//
+// The maximum number of history entries kept for each field. 0 or less means no history is kept.
+//
+func SetHistoryMax(max int) {
+	historyMax = max
+}
+
+//
+// Record that the value of a field was changed. oldValue is the value before the change.
+// The oldest entries are discarded to keep the number of entries within the maximum.
+//
+func AddHistory(root parser.NodeI, path *parser.Path, oldValue string, changed time.Time) {
+	if historyMax <= 0 {
+		return
+	}
+	h := getHistoryObject(root, true)
+	if h == nil {
+		return
+	}
+	entries := append(GetHistory(root, path), &HistoryEntry{Time: changed, Value: oldValue})
+	if len(entries) > historyMax {
+		entries = entries[len(entries)-historyMax:]
+	}
+	removeHistoryNode(h, path.String())
+	list := parser.NewJsonList(path.String())
+	for _, e := range entries {
+		o := parser.NewJsonObject("")
+		o.Add(parser.NewJsonString(historyTimeName, e.Time.Format(dateTimeFormatStr)))
+		o.Add(parser.NewJsonString(historyValueName, e.Value))
+		list.Add(o)
+	}
+	h.Add(list)
+}
+
+//
+// The change history of a field. Oldest first. Empty if there is no history.
+//
+func GetHistory(root parser.NodeI, path *parser.Path) []*HistoryEntry {
+	entries := make([]*HistoryEntry, 0)
+	h := getHistoryObject(root, false)
+	if h == nil {
+		return entries
+	}
+	l, ok := h.GetNodeWithName(path.String()).(*parser.JsonList)
+	if !ok {
+		return entries
+	}
+	for _, v := range l.GetValues() {
+		o, ok := v.(*parser.JsonObject)
+		if !ok {
+			continue
+		}
+		tn := o.GetNodeWithName(historyTimeName)
+		vn := o.GetNodeWithName(historyValueName)
+		if tn == nil || vn == nil {
+			continue
+		}
+		t, err := time.ParseInLocation(dateTimeFormatStr, tn.String(), time.Local)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, &HistoryEntry{Time: t, Value: vn.String()})
+	}
+	return entries
+}
+
+//
+// When the value of a field was last changed. false if there is no history for the field.
+//
+func LastChanged(root parser.NodeI, path *parser.Path) (time.Time, bool) {
+	entries := GetHistory(root, path)
+	if len(entries) == 0 {
+		return time.Time{}, false
+	}
+	return entries[len(entries)-1].Time, true
+}
+
+func (p *JsonData) GetHistory(path *parser.Path) []*HistoryEntry {
+	return GetHistory(p.dataMap, path)
+}
+
+func (p *JsonData) LastChanged(path *parser.Path) (time.Time, bool) {
+	return LastChanged(p.dataMap, path)
+}
+
+//
+// When an item is renamed the history for it (and everything below it) is moved to the new path.
+//
+func renameHistory(root parser.NodeI, oldPath, newPath string) {
+	h := getHistoryObject(root, false)
+	if h == nil {
+		return
+	}
+	for _, k := range historyKeysFor(h, oldPath) {
+		l := h.GetNodeWithName(k).(*parser.JsonList)
+		removeHistoryNode(h, k)
+		nl := parser.NewJsonList(newPath + strings.TrimPrefix(k, oldPath))
+		for _, v := range l.GetValues() {
+			nl.Add(v)
+		}
+		removeHistoryNode(h, nl.GetName())
+		h.Add(nl)
+	}
+}
+
+//
+// When an item is removed the history for it (and everything below it) is removed.
+// Old values must not remain in the data after the item is gone.
+//
+func removeHistory(root parser.NodeI, path *parser.Path) {
+	h := getHistoryObject(root, false)
+	if h == nil {
+		return
+	}
+	for _, k := range historyKeysFor(h, path.String()) {
+		removeHistoryNode(h, k)
+	}
+}
+
+func historyKeysFor(h *parser.JsonObject, ps string) []string {
+	keys := make([]string, 0)
+	for _, k := range h.GetSortedKeys() {
+		if k == ps || strings.HasPrefix(k, ps+PATH_SEP) {
+			if _, ok := h.GetNodeWithName(k).(*parser.JsonList); ok {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+func removeHistoryNode(h *parser.JsonObject, name string) {
+	n := h.GetNodeWithName(name)
+	if n != nil {
+		h.Remove(n)
+	}
+}
+
+func getHistoryObject(root parser.NodeI, create bool) *parser.JsonObject {
+	r, ok := root.(*parser.JsonObject)
+	if !ok {
+		return nil
+	}
+	n := r.GetNodeWithName(historyName)
+	if n == nil {
+		if !create {
+			return nil
+		}
+		n = parser.NewJsonObject(historyName)
+		r.Add(n)
+	}
+	h, ok := n.(*parser.JsonObject)
+	if !ok {
+		return nil
+	}
+	return h
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+type ImportMatchEnum int
+
+const (
+	IMPORT_NEW ImportMatchEnum = iota
+	IMPORT_DUPLICATE
+	IMPORT_PROBABLE
+
+	importRefSimilarity = 0.6 // Refs at least this similar (0..1) are a probable match
+)
+
+var (
+	ImportMatchNames = []string{"New", "Duplicate", "Probable"}
+)
+
+//
+// An incoming transaction and how it matches the transactions already in the account.
+// Only candidates with Accept set are added by ApplyImport.
+//
+type ImportCandidate struct {
+	Node        *parser.JsonObject // The transaction node that will be added
+	Tx          *TranactionData
+	Match       ImportMatchEnum
+	MatchedWith *TranactionData // The existing (or earlier incoming) transaction matched. nil for IMPORT_NEW
+	Accept      bool
+}
+
+func (c *ImportCandidate) String() string {
+	if c.MatchedWith == nil {
+		return fmt.Sprintf("%s: %s %s", ImportMatchNames[c.Match], c.Tx.Description(), c.Tx.TxType())
+	}
+	return fmt.Sprintf("%s: %s %s matches %s", ImportMatchNames[c.Match], c.Tx.Description(), c.Tx.TxType(), c.MatchedWith.Description())
+}
+
+//
+// Compare incoming transaction nodes with the transactions in a 'transactions' node.
+// Incoming transactions are also compared with the incoming transactions before them.
+//	IMPORT_DUPLICATE: Same date, ref, type and value or the same bank id (fitid).
+//		An initial value (iv) is a duplicate if the account already has one.
+//	IMPORT_PROBABLE: Same day, type and value with a similar ref.
+// If both transactions have a bank id only the bank id is compared. Two identical purchases on the same day are both new.
+// New transactions are accepted. Duplicates and probable duplicates are not.
+// Nothing is changed until ApplyImport is called.
+//
+func PreviewImport(txNode parser.NodeC, nodes []*parser.JsonObject) []*ImportCandidate {
+	existing := make([]*TranactionData, 0)
+	existingFitIds := make([]string, 0)
+	fitIds := make(map[string]*TranactionData)
+	var iv *TranactionData
+	add := func(n parser.NodeI, td *TranactionData) {
+		f := nodeFitId(n)
+		existing = append(existing, td)
+		existingFitIds = append(existingFitIds, f)
+		if td.TxType() == TX_TYPE_IV && iv == nil {
+			iv = td
+		}
+		if f != "" {
+			fitIds[f] = td
+		}
+	}
+	for _, v := range txNode.GetValues() {
+		if v.IsContainer() {
+			add(v, NewTranactionDataFromNode(v))
+		}
+	}
+	candidates := make([]*ImportCandidate, 0)
+	for _, n := range nodes {
+		td := NewTranactionDataFromNode(n)
+		fitId := nodeFitId(n)
+		c := &ImportCandidate{Node: n, Tx: td, Match: IMPORT_NEW}
+		if m, ok := fitIds[fitId]; ok && fitId != "" {
+			c.Match, c.MatchedWith = IMPORT_DUPLICATE, m
+		} else if td.TxType() == TX_TYPE_IV && iv != nil {
+			c.Match, c.MatchedWith = IMPORT_DUPLICATE, iv
+		} else {
+			for i, e := range existing {
+				if fitId != "" && existingFitIds[i] != "" {
+					continue // Different bank ids are different transactions
+				}
+				if e.Key() == td.Key() {
+					c.Match, c.MatchedWith = IMPORT_DUPLICATE, e
+					break
+				}
+				if c.MatchedWith == nil && IsProbableDuplicate(e, td) {
+					c.Match, c.MatchedWith = IMPORT_PROBABLE, e
+				}
+			}
+		}
+		c.Accept = c.Match == IMPORT_NEW
+		candidates = append(candidates, c)
+		add(n, td)
+	}
+	return candidates
+}
+
+//
+// Add the accepted candidates to a 'transactions' node. Returns the number added.
+// Nothing accepted (for example all duplicates) is not an error. 0 is returned.
+//
+func ApplyImport(txNode parser.NodeC, candidates []*ImportCandidate) (int, error) {
+	count := 0
+	for _, c := range candidates {
+		if c.Accept {
+			txNode.Add(c.Node)
+			count++
+		}
+	}
+	return count, nil
+}
+
+//
+// The number of duplicates (exact and probable) that are not accepted.
+//
+func CountSkippedDuplicates(candidates []*ImportCandidate) int {
+	count := 0
+	for _, c := range candidates {
+		if !c.Accept && c.Match != IMPORT_NEW {
+			count++
+		}
+	}
+	return count
+}
+
+//
+// Add the transactions that are not duplicates. Probable duplicates are added.
+//
+func importNewNodes(txNode parser.NodeC, nodes []*parser.JsonObject) (int, error) {
+	candidates := PreviewImport(txNode, nodes)
+	for _, c := range candidates {
+		c.Accept = c.Match != IMPORT_DUPLICATE
+	}
+	return ApplyImport(txNode, candidates)
+}
+
+//
+// Two transactions on the same day with the same type and value where the refs are similar.
+//
+func IsProbableDuplicate(t1, t2 *TranactionData) bool {
+	if t1.HasError() || t2.HasError() || t1.TxType() != t2.TxType() || t1.amount() != t2.amount() {
+		return false
+	}
+	if t1.dateTime.Format(DATE_FORMAT_TXN) != t2.dateTime.Format(DATE_FORMAT_TXN) {
+		return false
+	}
+	return RefSimilarity(t1.Ref(), t2.Ref()) >= importRefSimilarity
+}
+
+//
+// Return how similar two refs are. 0 is nothing in common, 1 is the same.
+// Case, punctuation and extra spaces are ignored. If one ref contains the other they are similar (1).
+// Otherwise the similarity is based on the edit (Levenshtein) distance.
+//
+func RefSimilarity(a, b string) float64 {
+	na := []rune(normaliseRef(a))
+	nb := []rune(normaliseRef(b))
+	if len(na) == 0 || len(nb) == 0 {
+		if len(na) == len(nb) {
+			return 1
+		}
+		return 0
+	}
+	short, long := na, nb
+	if len(short) > len(long) {
+		short, long = long, short
+	}
+	if len(short) >= 3 && strings.Contains(string(long), string(short)) {
+		return 1
+	}
+	return 1 - float64(editDistance(na, nb))/float64(len(long))
+}
+
+func normaliseRef(s string) string {
+	f := strings.FieldsFunc(strings.ToUpper(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(f, " ")
+}
+
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func nodeFitId(n parser.NodeI) string {
+	if n.IsContainer() {
+		f := n.(parser.NodeC).GetNodeWithName(IdTxFitId)
+		if f != nil {
+			return f.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//
+// Column names with a meaning to the import. Any other (non empty) column name
+// can be used in the RefFormat. Empty column names are ignored.
+//
+const (
+	ImportColDate   = "date"
+	ImportColType   = "type"
+	ImportColRef    = "ref"
+	ImportColDebit  = "db"
+	ImportColCredit = "cr"
+	ImportColAmount = "amount" // Signed. Use instead of 'db' and 'cr'
+
+	DefaultImportProfileName = "default"
+	defaultImportRefFormat   = "{ref} [{type}]"
+)
+
+var (
+	importRefUnusedName = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+//
+// How to read a CSV file exported by a particular bank.
+//
+type ImportProfile struct {
+	Name         string
+	DateFormat   string   // Go time layout. E.g. 02/01/2006
+	Delimiter    string   // Column separator. Empty is a comma. E.g. ";" or "tab"
+	Decimal      string   // Decimal separator. E.g. "." or ","
+	Thousands    string   // Thousands separator. E.g. ",", "." or "" for none
+	Columns      []string // Name for each column in the file
+	SkipHeader   bool     // The first row contains column headings
+	RefFormat    string   // Reference built from columns. E.g. "{ref} [{type}]". Empty brackets are removed
+	InvertAmount bool     // The 'amount' column is positive for a debit (for example a credit card)
+}
+
+//
+// A profile that reads the files the import has always read.
+//
+func NewImportProfile(name string) *ImportProfile {
+	cols := make([]string, len(IMPORT_CSV_COLUM_NAMES))
+	copy(cols, IMPORT_CSV_COLUM_NAMES)
+	return &ImportProfile{Name: name, DateFormat: TIME_FORMAT_CSV, Delimiter: ",", Decimal: ".", Thousands: "", Columns: cols, SkipHeader: true, RefFormat: defaultImportRefFormat, InvertAmount: false}
+}
+
+func (p *ImportProfile) String() string {
+	return fmt.Sprintf("Profile:%s Date:'%s' Delimiter:'%s' Decimal:'%s' Thousands:'%s' Columns:%s SkipHeader:%t Ref:'%s' Invert:%t", p.Name, p.DateFormat, p.Delimiter, p.Decimal, p.Thousands, p.Columns, p.SkipHeader, p.RefFormat, p.InvertAmount)
+}
+
+func (p *ImportProfile) hasColumn(name string) bool {
+	for _, c := range p.Columns {
+		if strings.TrimSpace(c) == name {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Check that the profile can be used to import a file.
+//
+func (p *ImportProfile) Validate() error {
+	if strings.TrimSpace(p.DateFormat) == "" {
+		return fmt.Errorf("import profile '%s' does not define a date format", p.Name)
+	}
+	d, err := CsvDelimiter(p.Delimiter)
+	if err != nil {
+		return fmt.Errorf("import profile '%s' %s", p.Name, err.Error())
+	}
+	if string(d) == p.Decimal {
+		return fmt.Errorf("import profile '%s' decimal separator and delimiter must be different", p.Name)
+	}
+	if len(p.Decimal) != 1 {
+		return fmt.Errorf("import profile '%s' decimal separator must be a single character", p.Name)
+	}
+	if len(p.Thousands) > 1 {
+		return fmt.Errorf("import profile '%s' thousands separator must be a single character or empty", p.Name)
+	}
+	if p.Decimal == p.Thousands {
+		return fmt.Errorf("import profile '%s' decimal and thousands separators must be different", p.Name)
+	}
+	if !p.hasColumn(ImportColDate) {
+		return fmt.Errorf("import profile '%s' does not have a '%s' column", p.Name, ImportColDate)
+	}
+	if !p.hasColumn(ImportColAmount) && !p.hasColumn(ImportColDebit) && !p.hasColumn(ImportColCredit) {
+		return fmt.Errorf("import profile '%s' requires an '%s' column or '%s' and '%s' columns", p.Name, ImportColAmount, ImportColDebit, ImportColCredit)
+	}
+	return nil
+}
+
+func (p *ImportProfile) ParseDate(s string) (time.Time, error) {
+	dt, err := time.Parse(p.DateFormat, strings.TrimSpace(s))
+	if err != nil {
+		return dt, fmt.Errorf("date '%s' does not match the format '%s'", s, p.DateFormat)
+	}
+	return dt, nil
+}
+
+//
+// Parse an amount using the separators of the profile. A leading or trailing '-' or
+// enclosing brackets make the amount negative. Exponents and other characters are rejected.
+//
+func (p *ImportProfile) ParseAmount(s string) (Money, error) {
+	v := strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")") {
+		neg = true
+		v = strings.TrimSpace(v[1 : len(v)-1])
+	}
+	if strings.HasPrefix(v, "-") {
+		neg = !neg
+		v = v[1:]
+	} else if strings.HasSuffix(v, "-") {
+		neg = !neg
+		v = v[:len(v)-1]
+	} else {
+		v = strings.TrimPrefix(v, "+")
+	}
+	if p.Thousands != "" {
+		v = strings.ReplaceAll(v, p.Thousands, "")
+	}
+	v = strings.Replace(v, p.Decimal, ".", 1)
+	if v == "" || v == "." {
+		return 0, fmt.Errorf("amount '%s' is not a number", s)
+	}
+	for _, c := range v {
+		if (c < '0' || c > '9') && c != '.' {
+			return 0, fmt.Errorf("amount '%s' is not a valid amount", s)
+		}
+	}
+	m, err := ParseMoney(v)
+	if err != nil {
+		return 0, fmt.Errorf("amount '%s' is not a valid amount. %s", s, err.Error())
+	}
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+//
+// Return the type (cr or db) and the (positive) value of a row.
+// If the 'amount' column is defined it is used, otherwise 'cr' then 'db'.
+// TX_TYPE_ERR is returned if the row has no amount.
+//
+func (p *ImportProfile) RowAmount(m map[string]string) (TransactionTypeEnum, Money, error) {
+	if p.hasColumn(ImportColAmount) && strings.TrimSpace(m[ImportColAmount]) != "" {
+		va, err := p.ParseAmount(m[ImportColAmount])
+		if err != nil {
+			return TX_TYPE_ERR, 0, err
+		}
+		if p.InvertAmount {
+			va = -va
+		}
+		if va < 0 {
+			return TX_TYPE_DEB, -va, nil
+		}
+		return TX_TYPE_CRE, va, nil
+	}
+	if strings.TrimSpace(m[ImportColCredit]) != "" {
+		va, err := p.ParseAmount(m[ImportColCredit])
+		return TX_TYPE_CRE, va, err
+	}
+	if strings.TrimSpace(m[ImportColDebit]) != "" {
+		va, err := p.ParseAmount(m[ImportColDebit])
+		return TX_TYPE_DEB, va, err
+	}
+	return TX_TYPE_ERR, 0, nil
+}
+
+//
+// Build the reference for a row from the RefFormat. {name} is replaced by the value of column 'name'.
+// Names that are not columns are removed. Brackets left empty by missing values are removed along with extra spaces.
+//
+func (p *ImportProfile) RowRef(m map[string]string) string {
+	format := p.RefFormat
+	if strings.TrimSpace(format) == "" {
+		format = "{" + ImportColRef + "}"
+	}
+	s := format
+	for k, v := range m {
+		s = strings.ReplaceAll(s, "{"+k+"}", strings.TrimSpace(v))
+	}
+	s = importRefUnusedName.ReplaceAllString(s, "")
+	for _, e := range []string{"[]", "()"} {
+		s = strings.ReplaceAll(s, e, "")
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
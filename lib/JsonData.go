@@ -19,6 +19,7 @@ package lib
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,15 +44,16 @@ const (
 	NODE_TYPE_RT NodeAnnotationEnum = 2 // Rich Text
 	NODE_TYPE_PO NodeAnnotationEnum = 3 // POsitinal
 	NODE_TYPE_IM NodeAnnotationEnum = 4 // IMage
+	NODE_TYPE_OT NodeAnnotationEnum = 5 // One Time password (TOTP)
 )
 
 var (
-	nodeAnnotationPrefix      = []string{"", "!ml", "!rt", "!po", "!im"}
-	NodeAnnotationPrefixNames = []string{"Single Line", "Multi Line", "Rich Text", "Positional", "Image"}
-	NodeAnnotationEnums       = []NodeAnnotationEnum{NODE_TYPE_SL, NODE_TYPE_ML, NODE_TYPE_RT, NODE_TYPE_PO, NODE_TYPE_IM}
-	NodeAnnotationsSingleLine = []bool{true, false, false, true, true}
+	nodeAnnotationPrefix      = []string{"", "!ml", "!rt", "!po", "!im", "!otp"}
+	NodeAnnotationPrefixNames = []string{"Single Line", "Multi Line", "Rich Text", "Positional", "Image", "One Time (2FA)"}
+	NodeAnnotationEnums       = []NodeAnnotationEnum{NODE_TYPE_SL, NODE_TYPE_ML, NODE_TYPE_RT, NODE_TYPE_PO, NODE_TYPE_IM, NODE_TYPE_OT}
+	NodeAnnotationsSingleLine = []bool{true, false, false, true, true, true}
 	defaultHintNames          = []string{"notes", "post", "pre", "userId"}
-	defaultAssetNames         = []string{"Account Num.", "Sort Code", "Site"}
+	defaultAssetNames         = []string{"Account Num.", "Sort Code", "Site", IdCurrency}
 	timeStampPath             = parser.NewBarPath(timeStampName)
 	dataMapRootPath           = parser.NewBarPath(DataMapRootName)
 	nameMap                   = make(map[string]string)
@@ -61,6 +63,7 @@ type JsonData struct {
 	dataMap        *parser.JsonObject
 	navIndex       *map[string][]string
 	dataMapUpdated func(string, *parser.Path, error)
+	undoStack      *undoStack
 }
 
 func InitNameMap(m map[string]string) {
@@ -136,10 +139,18 @@ func NewJsonData(j []byte, dataMapUpdated func(string, *parser.Path, error)) (*J
 		}
 	}
 
-	dr := &JsonData{dataMap: rO, navIndex: createNavIndex(rO), dataMapUpdated: dataMapUpdated}
+	dr := &JsonData{dataMap: rO, navIndex: createNavIndex(rO), dataMapUpdated: dataMapUpdated, undoStack: newUndoStack()}
 	return dr, nil
 }
 
+//
+// Check that the content can be loaded by NewJsonData. Used to verify data before it is saved.
+//
+func VerifyJsonData(j []byte) error {
+	_, err := NewJsonData(j, func(string, *parser.Path, error) {})
+	return err
+}
+
 func (p *JsonData) GetNavIndex(id string) []string {
 	ni := *p.navIndex
 	return ni[id]
@@ -238,6 +249,21 @@ func (p *JsonData) GetTimeStampString() string {
 	return "Undefined"
 }
 
+//
+// The time the data was last saved. false if there is no time stamp or it cannot be parsed.
+//
+func (p *JsonData) GetTimeStamp() (time.Time, bool) {
+	dtNode, err := parser.Find(p.dataMap, timeStampPath)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := parseTime(dtNode.String())
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
 func (p *JsonData) ToJson() string {
 	return p.dataMap.JsonValue()
 }
@@ -247,7 +273,7 @@ func (p *JsonData) Search(addTrailFunc func(*parser.Trail), needle string, ignor
 	searchGroups(addTrailFunc, needle, dataRoot, ignoreCase)
 }
 
-func (p *JsonData) AddTransaction(transactionPath *parser.Path, date time.Time, ref string, amount float64, txType TransactionTypeEnum) error {
+func (p *JsonData) AddTransaction(transactionPath *parser.Path, date time.Time, ref string, amount Money, txType TransactionTypeEnum, category string) error {
 	userRoot := p.GetUserRoot()
 	if userRoot == nil {
 		return fmt.Errorf("the user root node cannot be found")
@@ -259,8 +285,12 @@ func (p *JsonData) AddTransaction(transactionPath *parser.Path, date time.Time,
 	if txNode.GetNodeType() != parser.NT_LIST {
 		return fmt.Errorf("the transaction node for '%s' is not a List node", transactionPath)
 	}
-	addTransactionToAsset(txNode.(*parser.JsonList), newTranactionData(date, amount, ref, txType, txNode))
+	before := p.beginUndo()
+	td := newTranactionData(date, amount, ref, txType, txNode)
+	td.category = strings.TrimSpace(category)
+	addTransactionToAsset(txNode.(*parser.JsonList), td)
 	p.navIndex = createNavIndex(p.dataMap)
+	p.pushUndo("Add Transaction", transactionPath.PathParent().String(), transactionPath.PathParent().String(), before)
 	p.dataMapUpdated("Add Transaction", transactionPath.PathParent(), nil)
 	return nil
 }
@@ -277,9 +307,11 @@ func (p *JsonData) CloneHint(dataPath *parser.Path, hintItemName string, cloneLe
 	if parent.(parser.NodeC).GetNodeWithName(hintItemName) != nil {
 		return fmt.Errorf("the cloned item '%s' name already exists", dataPath)
 	}
+	before := p.beginUndo()
 	cl := parser.Clone(h, hintItemName, cloneLeafNodeData)
 	parent.(parser.NodeC).Add(cl)
 	p.navIndex = createNavIndex(p.dataMap)
+	p.pushUndo(fmt.Sprintf("Clone Item '%s'", hintItemName), dataPath.String(), dataPath.PathFirst(2).StringAppend(hintItemName).String(), before)
 	p.dataMapUpdated(fmt.Sprintf("Cloned Item '%s' added", hintItemName), dataPath.PathFirst(2).StringAppend(hintItemName), nil)
 	return nil
 }
@@ -293,9 +325,11 @@ func (p *JsonData) AddSubItem(dataPath *parser.Path, subItemName string, itemDis
 		return fmt.Errorf("the item '%s' is not a Json Object", dataPath)
 	}
 	hO := h.(*parser.JsonObject)
+	before := p.beginUndo()
 	ok := addStringIfDoesNotExist(hO, subItemName)
 	if ok {
 		p.navIndex = createNavIndex(p.dataMap)
+		p.pushUndo(fmt.Sprintf("Add Item '%s'", subItemName), dataPath.String(), dataPath.String(), before)
 		p.dataMapUpdated("AddSubItem", dataPath.StringAppend(subItemName), nil)
 		return nil
 	}
@@ -307,8 +341,10 @@ func (p *JsonData) AddAsset(userPath *parser.Path, assetName string) error {
 	if u == nil {
 		return fmt.Errorf("the user '%s' cannot be found", userPath)
 	}
+	before := p.beginUndo()
 	addAssetToUser(u, assetName)
 	p.navIndex = createNavIndex(p.dataMap)
+	p.pushUndo(fmt.Sprintf("Add Asset '%s'", assetName), userPath.String(), userPath.StringAppend(IdAssets).StringAppend(assetName).String(), before)
 	p.dataMapUpdated("AddAsset", userPath.StringAppend(IdAssets).StringAppend(assetName), nil)
 	return nil
 }
@@ -318,8 +354,10 @@ func (p *JsonData) AddHint(userUid *parser.Path, hintName string) error {
 	if u == nil {
 		return fmt.Errorf("the user '%s' cannot be found", userUid)
 	}
+	before := p.beginUndo()
 	addHintToUser(u, hintName)
 	p.navIndex = createNavIndex(p.dataMap)
+	p.pushUndo(fmt.Sprintf("Add Hint '%s'", hintName), userUid.String(), userUid.StringAppend(IdHints).StringAppend(hintName).String(), before)
 	p.dataMapUpdated("AddHint", userUid.StringAppend(IdHints).StringAppend(hintName), nil)
 	return nil
 }
@@ -329,10 +367,12 @@ func (p *JsonData) AddUser(userName string) error {
 	if u != nil {
 		return fmt.Errorf("the user '%s' already exists", userName)
 	}
+	before := p.beginUndo()
 	userO := parser.NewJsonObject(userName)
 	addHintToUser(userO, "App1")
 	p.GetUserRoot().Add(userO)
 	p.navIndex = createNavIndex(p.dataMap)
+	p.pushUndo(fmt.Sprintf("Add User '%s'", userName), "", userName, before)
 	p.dataMapUpdated("AddUser", parser.NewDotPath(userName), nil)
 	return nil
 }
@@ -346,11 +386,16 @@ func (p *JsonData) Rename(dataPath *parser.Path, newName string) error {
 	if !ok {
 		return fmt.Errorf("the item to rename '%s' does not have a valid parent", dataPath)
 	}
+	oldPathStr := dataPath.String()
+	newPath := parser.NewBarPath(oldPathStr).PathParent().StringAppend(newName)
+	before := p.beginUndo()
 	err = parser.Rename(p.dataMap, n, newName)
 	if err != nil {
 		return fmt.Errorf("rename '%s' failed. Error: '%s'", dataPath, err.Error())
 	}
+	renameHistory(p.dataMap, oldPathStr, newPath.String())
 	p.navIndex = createNavIndex(p.dataMap)
+	p.pushUndo(fmt.Sprintf("Rename '%s' to '%s'", dataPath.StringLast(), newName), oldPathStr, newPath.String(), before)
 	if parent.GetName() == DataMapRootName { // If the parent is groups then the user was renamed
 		p.dataMapUpdated(fmt.Sprintf("Renamed User '%s'", n.GetName()), parser.NewBarPath(newName), nil)
 	} else {
@@ -376,11 +421,14 @@ func (p *JsonData) Remove(dataPath *parser.Path, min int) error {
 	if count <= min {
 		return fmt.Errorf("there must be at least %d element(s) remaining in this item", min)
 	}
+	before := p.beginUndo()
 	parser.Remove(p.dataMap, n)
+	removeHistory(p.dataMap, dataPath)
 	if min < 0 && parentObj.Len() == 0 {
 		parser.Remove(p.dataMap, parentObj)
 	}
 	p.navIndex = createNavIndex(p.dataMap)
+	p.pushUndo(fmt.Sprintf("Remove '%s'", n.GetName()), dataPath.String(), dataPath.PathParent().String(), before)
 	if parent.GetName() == DataMapRootName { // If the parent is groups then the user was renamed
 		p.dataMapUpdated(fmt.Sprintf("Removed User '%s'", n.GetName()), parser.NewBarPath(""), nil)
 	} else {
@@ -389,6 +437,43 @@ func (p *JsonData) Remove(dataPath *parser.Path, min int) error {
 	return nil
 }
 
+//
+// Set the value of an existing value (string, number or bool) node.
+// Numbers and bools must parse or the value is not changed.
+//
+func (p *JsonData) SetLeafValue(dataPath *parser.Path, value string) error {
+	n, err := p.FindNodeForUserDataPath(dataPath)
+	if err != nil {
+		return fmt.Errorf("the item to set '%s' was not found in the data", dataPath)
+	}
+	oldValue := n.String()
+	before := p.beginUndo()
+	switch n.GetNodeType() {
+	case parser.NT_STRING:
+		n.(*parser.JsonString).SetValue(value)
+	case parser.NT_NUMBER:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("the item to set '%s' is a number. '%s' is not a valid number", dataPath, value)
+		}
+		n.(*parser.JsonNumber).SetValue(f)
+	case parser.NT_BOOL:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("the item to set '%s' is a bool. '%s' is not true or false", dataPath, value)
+		}
+		n.(*parser.JsonBool).SetValue(b)
+	default:
+		return fmt.Errorf("the item to set '%s' is not a value item", dataPath)
+	}
+	if n.String() != oldValue {
+		AddHistory(p.dataMap, dataPath, oldValue, time.Now())
+	}
+	p.pushUndo(fmt.Sprintf("Set Item '%s'", n.GetName()), dataPath.PathParent().String(), dataPath.PathParent().String(), before)
+	p.dataMapUpdated(fmt.Sprintf("Set Item '%s'", n.GetName()), dataPath.PathParent(), nil)
+	return nil
+}
+
 func (p *JsonData) FindNodeForUserDataPath(dataPath *parser.Path) (parser.NodeI, error) {
 	return FindNodeForUserDataPath(p.GetDataRoot(), dataPath)
 }
@@ -479,7 +564,7 @@ func addDefaultAccountItemsToAsset(account *parser.JsonObject) {
 	tx := account.GetNodeWithName(IdTxTransactions)
 	if tx == nil {
 		txl := parser.NewJsonList(IdTxTransactions)
-		addTransactionToAsset(txl, newTranactionData(time.Now(), 0.0, "Opening Balance", TX_TYPE_IV, txl))
+		addTransactionToAsset(txl, newTranactionData(time.Now(), 0, "Opening Balance", TX_TYPE_IV, txl))
 		account.Add(txl)
 	}
 }
@@ -488,8 +573,11 @@ func addTransactionToAsset(transactions *parser.JsonList, tx *TranactionData) {
 	txo := parser.NewJsonObject("")
 	txo.Add(parser.NewJsonString(IdTxDate, tx.DateTime()))
 	txo.Add(parser.NewJsonString(IdTxRef, tx.ref))
-	txo.Add(parser.NewJsonNumber(IdTxVal, tx.value))
+	txo.Add(parser.NewJsonNumber(IdTxVal, tx.value.Float64()))
 	txo.Add(parser.NewJsonString(IdTxType, string(tx.txType)))
+	if tx.category != "" {
+		txo.Add(parser.NewJsonString(IdTxCategory, tx.category))
+	}
 	transactions.Add(txo)
 }
 
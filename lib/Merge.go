@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+//
+// Merge other data (for example the remote copy that has changed) in to this data.
+//	Users, hints, assets and fields that are only in the other data are added.
+//	List entries (transactions etc) that are only in the other data are added.
+//	Transactions are matched by date, ref, type and value. Other fields (category, notes) are not compared.
+//	Where both have a value the value in this data is kept.
+// Nothing is removed so entries removed from either copy will be in the merged data.
+// Returns the number of items added.
+//
+func (p *JsonData) MergeFrom(content []byte, selectedPath *parser.Path) (int, error) {
+	other, err := NewJsonData(content, func(string, *parser.Path, error) {})
+	if err != nil {
+		return 0, fmt.Errorf("the data to merge could not be read. %s", err.Error())
+	}
+	before := p.beginUndo()
+	count := mergeObject(p.GetUserRoot(), other.GetUserRoot()) + mergeNamed(p.dataMap, other.dataMap, IdExchangeRates)
+	if count == 0 {
+		return 0, nil
+	}
+	p.navIndex = createNavIndex(p.dataMap)
+	p.pushUndo(fmt.Sprintf("Merge %d items", count), selectedPath.String(), selectedPath.String(), before)
+	p.dataMapUpdated("Merged data", selectedPath, nil)
+	return count, nil
+}
+
+func mergeNamed(to, from *parser.JsonObject, name string) int {
+	f := from.GetNodeWithName(name)
+	if f == nil {
+		return 0
+	}
+	t := to.GetNodeWithName(name)
+	if t == nil {
+		to.Add(parser.Clone(f, name, true))
+		return 1
+	}
+	if t.GetNodeType() != f.GetNodeType() {
+		return 0
+	}
+	switch t.GetNodeType() {
+	case parser.NT_OBJECT:
+		return mergeObject(t.(*parser.JsonObject), f.(*parser.JsonObject))
+	case parser.NT_LIST:
+		return mergeList(t.(*parser.JsonList), f.(*parser.JsonList))
+	}
+	return 0
+}
+
+func mergeObject(to, from *parser.JsonObject) int {
+	count := 0
+	for _, name := range from.GetSortedKeys() {
+		count = count + mergeNamed(to, from, name)
+	}
+	return count
+}
+
+//
+// Add the entries in 'from' that are not in 'to'. Entries are compared by listEntryKey.
+//
+func mergeList(to, from *parser.JsonList) int {
+	values := make(map[string]bool)
+	for _, v := range to.GetValues() {
+		values[listEntryKey(to, v)] = true
+	}
+	count := 0
+	for _, v := range from.GetValues() {
+		k := listEntryKey(to, v)
+		if !values[k] {
+			to.Add(parser.Clone(v, v.GetName(), true))
+			values[k] = true
+			count++
+		}
+	}
+	return count
+}
+
+//
+// A transaction is identified by its Key (date, ref, type and value) so a transaction
+// edited in both copies (for example a different category or note) is not added again.
+// Other list entries are identified by mergeKey.
+//
+func listEntryKey(list *parser.JsonList, n parser.NodeI) string {
+	if list.GetName() == IdTxTransactions && n.IsContainer() {
+		txd := NewTranactionDataFromNode(n)
+		if !txd.HasError() {
+			return txd.Key()
+		}
+	}
+	return mergeKey(n)
+}
+
+//
+// The json value of a node with the object fields in name order.
+// JsonValue cannot be compared as the order of object fields is not fixed.
+//
+func mergeKey(n parser.NodeI) string {
+	var sb strings.Builder
+	switch n.GetNodeType() {
+	case parser.NT_OBJECT:
+		sb.WriteString("{")
+		for _, v := range n.(*parser.JsonObject).GetValuesSorted() {
+			sb.WriteString(fmt.Sprintf("%q:%s,", v.GetName(), mergeKey(v)))
+		}
+		sb.WriteString("}")
+	case parser.NT_LIST:
+		sb.WriteString("[")
+		for _, v := range n.(*parser.JsonList).GetValues() {
+			sb.WriteString(mergeKey(v))
+			sb.WriteString(",")
+		}
+		sb.WriteString("]")
+	default:
+		sb.WriteString(fmt.Sprintf("%d:%q", n.GetNodeType(), n.String()))
+	}
+	return sb.String()
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+//
+// Money is a value in minor units (pence, cents). 1234.56 is Money(123456).
+// Money values are added and subtracted exactly. Use float64 only to read and write json number nodes.
+//
+type Money int64
+
+const (
+	moneyMinorDigits = 2
+	moneyMinorUnits  = 100
+	moneyMaxDigits   = 15 // Larger values cannot be held exactly in a json (float64) number
+)
+
+//
+// Parse a decimal amount. E.g. "12", "-12.5", "+0.05", ".50"
+// Only digits, one '.' and a leading sign are allowed. Exponents (1e3), thousands separators and
+// more than 2 decimal places (other than trailing zeros) are rejected.
+//
+func ParseMoney(s string) (Money, error) {
+	v := strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(v, "-") {
+		neg = true
+		v = v[1:]
+	} else {
+		v = strings.TrimPrefix(v, "+")
+	}
+	whole, frac := v, ""
+	if i := strings.Index(v, "."); i >= 0 {
+		whole, frac = v[:i], v[i+1:]
+	}
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("amount '%s' is not a number", s)
+	}
+	if strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, fmt.Errorf("amount '%s' is not a valid amount", s)
+	}
+	for len(frac) > moneyMinorDigits && strings.HasSuffix(frac, "0") {
+		frac = frac[:len(frac)-1]
+	}
+	if len(frac) > moneyMinorDigits {
+		return 0, fmt.Errorf("amount '%s' has more than %d decimal places", s, moneyMinorDigits)
+	}
+	if len(whole) > moneyMaxDigits-moneyMinorDigits {
+		return 0, fmt.Errorf("amount '%s' is too large", s)
+	}
+	var m Money
+	for _, c := range whole + frac + strings.Repeat("0", moneyMinorDigits-len(frac)) {
+		m = m*10 + Money(c-'0')
+	}
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+//
+// Convert a float (from a json number node) to Money. The value is rounded to the nearest minor unit.
+// A float read from a value with 2 or fewer decimal places converts exactly.
+//
+func MoneyFromFloat(f float64) Money {
+	return Money(math.Round(f * moneyMinorUnits))
+}
+
+//
+// The value as a float for a json number node. Converting back with MoneyFromFloat gives the same value.
+//
+func (m Money) Float64() float64 {
+	return float64(m) / moneyMinorUnits
+}
+
+func (m Money) Abs() Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+//
+// The value with 2 decimal places. E.g. "-1234.50"
+//
+func (m Money) String() string {
+	a := m.Abs()
+	s := fmt.Sprintf("%d.%02d", a/moneyMinorUnits, a%moneyMinorUnits)
+	if m < 0 {
+		return "-" + s
+	}
+	return s
+}
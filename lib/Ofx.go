@@ -0,0 +1,279 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+//
+// OFX (and Quicken QFX) bank statements.
+// Version 1.x files are SGML where elements (values) are not closed:
+//	<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20220309<TRNAMT>-27.56<FITID>123<NAME>TESCO</STMTTRN>
+// Version 2.x files are XML where all elements are closed. Both are read the same way.
+// The value of an element is the text up to the next tag.
+//
+const (
+	IdTxFitId = "fitid" // The bank's unique id for a transaction. Used to prevent duplicates
+
+	ofxTransactionTag = "STMTTRN"
+)
+
+var (
+	OfxFileExtensions = []string{".ofx", ".qfx"}
+	ofxEntities       = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", "\"", "&apos;", "'", "&nbsp;", " ")
+)
+
+type OfxTransaction struct {
+	Date    time.Time
+	Amount  Money  // Negative for a debit
+	TrnType string // E.g. DEBIT, CREDIT, POS, DIRECTDEBIT
+	FitId   string
+	Name    string
+	Memo    string
+}
+
+func (t *OfxTransaction) String() string {
+	return fmt.Sprintf("%s %s %s %s %s", FormatDateTime(t.Date), t.FitId, t.Amount, t.TrnType, t.Ref())
+}
+
+//
+// The transaction ref is the NAME with the MEMO in brackets if it adds anything.
+//
+func (t *OfxTransaction) Ref() string {
+	if t.Memo == "" || t.Memo == t.Name {
+		return t.Name
+	}
+	if t.Name == "" {
+		return t.Memo
+	}
+	return fmt.Sprintf("%s [%s]", t.Name, t.Memo)
+}
+
+func IsOfxFile(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, e := range OfxFileExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func ParseOfxFile(fileName string) ([]*OfxTransaction, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := ParseOfx(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("file '%s' %s", fileName, err.Error())
+	}
+	return txs, nil
+}
+
+//
+// Return all of the statement transactions (bank and credit card) in OFX data.
+//
+func ParseOfx(data string) ([]*OfxTransaction, error) {
+	if !utf8.ValidString(data) {
+		data = decodeWindows1252(data) // OFX 1.x files are usually CHARSET:1252
+	}
+	upper := ofxUpper(data)
+	if !strings.Contains(upper, "<OFX>") {
+		return nil, fmt.Errorf("is not an OFX file. <OFX> was not found")
+	}
+	txs := make([]*OfxTransaction, 0)
+	open := "<" + ofxTransactionTag + ">"
+	close := "</" + ofxTransactionTag + ">"
+	pos := 0
+	for {
+		start := strings.Index(upper[pos:], open)
+		if start < 0 {
+			break
+		}
+		start = pos + start + len(open)
+		end := strings.Index(upper[start:], close)
+		if end < 0 {
+			return nil, fmt.Errorf("transaction %d is not closed. %s was not found", len(txs)+1, close)
+		}
+		end = start + end
+		tx, err := parseOfxTransaction(ofxElements(data[start:end]))
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d %s", len(txs)+1, err.Error())
+		}
+		txs = append(txs, tx)
+		pos = end + len(close)
+	}
+	return txs, nil
+}
+
+//
+// Import the transactions in an OFX file in to a 'transactions' node.
+// Transactions with a FITID that is already in the node are not added.
+// If a transaction does not have a FITID it is not added if an identical transaction exists.
+//
+func ImportOfxData(txNode parser.NodeC, fileName string) (int, error) {
+	nodes, err := OfxTransactionNodes(fileName)
+	if err != nil {
+		return 0, err
+	}
+	return importNewNodes(txNode, nodes)
+}
+
+//
+// Read the transactions in an OFX file. See PreviewImport.
+//
+func OfxTransactionNodes(fileName string) ([]*parser.JsonObject, error) {
+	txs, err := ParseOfxFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*parser.JsonObject, 0)
+	for _, tx := range txs {
+		txType := TX_TYPE_CRE
+		if tx.Amount < 0 {
+			txType = TX_TYPE_DEB
+		}
+		tn := newTransactionNode(tx.Date, tx.Ref(), txType, tx.Amount.Abs())
+		if tx.FitId != "" {
+			tn.Add(parser.NewJsonString(IdTxFitId, tx.FitId))
+		}
+		nodes = append(nodes, tn)
+	}
+	return nodes, nil
+}
+
+func parseOfxTransaction(el map[string]string) (*OfxTransaction, error) {
+	dts, ok := el["DTPOSTED"]
+	if !ok {
+		return nil, fmt.Errorf("does not have a DTPOSTED")
+	}
+	dt, err := parseOfxDate(dts)
+	if err != nil {
+		return nil, err
+	}
+	ams, ok := el["TRNAMT"]
+	if !ok {
+		return nil, fmt.Errorf("does not have a TRNAMT")
+	}
+	am, err := parseOfxAmount(ams)
+	if err != nil {
+		return nil, err
+	}
+	name := el["NAME"]
+	if name == "" {
+		name = el["PAYEE"]
+	}
+	return &OfxTransaction{Date: dt, Amount: am, TrnType: el["TRNTYPE"], FitId: el["FITID"], Name: name, Memo: el["MEMO"]}, nil
+}
+
+//
+// The elements (name --> value) in a block. Closing tags (XML) and nested blocks are ignored.
+//
+func ofxElements(block string) map[string]string {
+	el := make(map[string]string)
+	for _, part := range strings.Split(block, "<")[1:] {
+		i := strings.Index(part, ">")
+		if i <= 0 || part[0] == '/' {
+			continue
+		}
+		name := ofxUpper(strings.TrimSpace(part[:i]))
+		value := strings.TrimSpace(ofxEntities.Replace(part[i+1:]))
+		if _, found := el[name]; !found {
+			el[name] = value
+		}
+	}
+	return el
+}
+
+//
+// OFX dates are YYYYMMDD[HHMMSS[.XXX]][[gmt offset[:tz name]]]
+// The time zone is ignored. The date is as the bank recorded it.
+//
+func parseOfxDate(s string) (time.Time, error) {
+	v := strings.TrimSpace(s)
+	if i := strings.IndexAny(v, ".["); i >= 0 {
+		v = v[:i]
+	}
+	switch len(v) {
+	case 8:
+		return time.Parse("20060102", v)
+	case 12:
+		return time.Parse("200601021504", v)
+	case 14:
+		return time.Parse("20060102150405", v)
+	}
+	return time.Time{}, fmt.Errorf("date '%s' is not a valid OFX date", s)
+}
+
+func parseOfxAmount(s string) (Money, error) {
+	v := strings.TrimSpace(s)
+	if !strings.Contains(v, ".") {
+		v = strings.Replace(v, ",", ".", 1) // Some banks use a decimal comma
+	}
+	m, err := ParseMoney(v)
+	if err != nil {
+		return 0, fmt.Errorf("amount '%s' is not a valid amount", s)
+	}
+	return m, nil
+}
+
+//
+// Upper case ASCII letters only. The result is the same length as s so indexes in to it can be used with s.
+// strings.ToUpper can change the length (for example invalid UTF-8 becomes U+FFFD).
+//
+func ofxUpper(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		}
+	}
+	return string(b)
+}
+
+//
+// Windows-1252 to UTF-8. 0x80..0x9F are mapped using the table. Other bytes are the same as Latin-1 (Unicode).
+//
+var windows1252 = [32]rune{
+	'€', 0xFFFD, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0xFFFD, 'Ž', 0xFFFD,
+	0xFFFD, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0xFFFD, 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c < 0x80:
+			sb.WriteByte(c)
+		case c < 0xA0:
+			sb.WriteRune(windows1252[c-0x80])
+		default:
+			sb.WriteRune(rune(c))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	pwSymbols = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~ "
+)
+
+var (
+	PasswordStrengthNames = []string{"Very Weak", "Weak", "Reasonable", "Strong", "Very Strong"}
+	passwordStrengthBits  = []float64{28, 36, 60, 128}
+)
+
+//
+// Estimate the entropy (in bits) of a password.
+// The size of the character pool is derived from the classes of characters used.
+// Each character adds log2(pool) bits but repeated characters only count once
+// so 'aaaaaaaa' is not rated the same as 'ahdkeoqp'.
+//
+func PasswordEntropy(pw string) float64 {
+	if pw == "" {
+		return 0
+	}
+	pool := 0
+	var lower, upper, digit, symbol, other bool
+	unique := make(map[rune]bool)
+	for _, c := range pw {
+		unique[c] = true
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case strings.ContainsRune(pwSymbols, c):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	if lower {
+		pool = pool + 26
+	}
+	if upper {
+		pool = pool + 26
+	}
+	if digit {
+		pool = pool + 10
+	}
+	if symbol {
+		pool = pool + len(pwSymbols)
+	}
+	if other {
+		pool = pool + 100
+	}
+	count := float64(len(unique)) + (float64(len([]rune(pw))-len(unique)) / 4.0)
+	return count * math.Log2(float64(pool))
+}
+
+//
+// Return an index in to PasswordStrengthNames for the password
+//
+func PasswordStrength(pw string) int {
+	bits := PasswordEntropy(pw)
+	for i, v := range passwordStrengthBits {
+		if bits < v {
+			return i
+		}
+	}
+	return len(passwordStrengthBits)
+}
+
+//
+// Return a readable description of the password strength. E.g. 'Strong (75 bits)'
+//
+func PasswordStrengthDesc(pw string) string {
+	return fmt.Sprintf("%s (%.0f bits)", PasswordStrengthNames[PasswordStrength(pw)], PasswordEntropy(pw))
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	pwGenLower     = "abcdefghijklmnopqrstuvwxyz"
+	pwGenUpper     = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	pwGenDigits    = "0123456789"
+	pwGenSymbols   = "!#$%&*+-.=?@^_~"
+	pwGenAmbiguous = "Il1O0o"
+	pwGenMinLength = 4
+	pwGenMaxLength = 128
+	pwGenMaxWords  = 20
+)
+
+//
+// The bundled word list for passphrases. One lower case word per line.
+//
+//go:embed wordlist.txt
+var pwGenWordListData string
+var pwGenWordList = strings.Fields(pwGenWordListData)
+
+type PasswordGenOptions struct {
+	Length           int    // Number of characters (not Passphrase)
+	Lower            bool   // Include a-z
+	Upper            bool   // Include A-Z
+	Digits           bool   // Include 0-9
+	Symbols          bool   // Include pwGenSymbols
+	ExcludeAmbiguous bool   // Exclude characters that look alike. E.g. I l 1 O 0 o
+	Passphrase       bool   // Generate words from the word list instead of characters
+	Words            int    // Number of words (Passphrase)
+	Separator        string // Between words (Passphrase)
+}
+
+func NewPasswordGenOptions() *PasswordGenOptions {
+	return &PasswordGenOptions{Length: 16, Lower: true, Upper: true, Digits: true, Symbols: true, ExcludeAmbiguous: true, Passphrase: false, Words: 5, Separator: "-"}
+}
+
+func (o *PasswordGenOptions) String() string {
+	if o.Passphrase {
+		return fmt.Sprintf("Passphrase: Words:%d Separator:'%s'", o.Words, o.Separator)
+	}
+	return fmt.Sprintf("Password: Length:%d Lower:%t Upper:%t Digits:%t Symbols:%t ExcludeAmbiguous:%t", o.Length, o.Lower, o.Upper, o.Digits, o.Symbols, o.ExcludeAmbiguous)
+}
+
+//
+// Generate a random password or passphrase using a cryptographically secure random source.
+// A password contains at least one character from each of the selected character classes.
+//
+func GeneratePassword(o *PasswordGenOptions) (string, error) {
+	if o.Passphrase {
+		return generatePassphrase(o.Words, o.Separator)
+	}
+	if o.Length < pwGenMinLength || o.Length > pwGenMaxLength {
+		return "", fmt.Errorf("password length must be between %d and %d", pwGenMinLength, pwGenMaxLength)
+	}
+	classes := make([]string, 0)
+	for _, c := range []struct {
+		chars    string
+		selected bool
+	}{{pwGenLower, o.Lower}, {pwGenUpper, o.Upper}, {pwGenDigits, o.Digits}, {pwGenSymbols, o.Symbols}} {
+		if c.selected {
+			if o.ExcludeAmbiguous {
+				c.chars = removeChars(c.chars, pwGenAmbiguous)
+			}
+			classes = append(classes, c.chars)
+		}
+	}
+	if len(classes) == 0 {
+		return "", errors.New("at least one character class must be selected")
+	}
+	all := strings.Join(classes, "")
+	pw := make([]byte, o.Length)
+	for i := range pw {
+		chars := all
+		if i < len(classes) {
+			chars = classes[i] // One from each class first. They are shuffled below
+		}
+		n, err := randomInt(len(chars))
+		if err != nil {
+			return "", err
+		}
+		pw[i] = chars[n]
+	}
+	for i := len(pw) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		pw[i], pw[j] = pw[j], pw[i]
+	}
+	return string(pw), nil
+}
+
+func generatePassphrase(words int, sep string) (string, error) {
+	if words < 1 || words > pwGenMaxWords {
+		return "", fmt.Errorf("number of words must be between 1 and %d", pwGenMaxWords)
+	}
+	if len(pwGenWordList) == 0 {
+		return "", errors.New("word list is empty")
+	}
+	list := make([]string, words)
+	for i := range list {
+		n, err := randomInt(len(pwGenWordList))
+		if err != nil {
+			return "", err
+		}
+		list[i] = pwGenWordList[n]
+	}
+	return strings.Join(list, sep), nil
+}
+
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
+
+func removeChars(s, remove string) string {
+	var sb strings.Builder
+	for _, c := range s {
+		if !strings.ContainsRune(remove, c) {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,334 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+//
+// QIF (Quicken Interchange Format) bank accounts.
+//	!Type:Bank
+//	D09/03/2022		Date
+//	T-27.56			Amount. Negative for a debit
+//	PTESCO STORE	Payee
+//	MMemo			Memo (optional)
+//	^				End of the transaction
+// The opening balance is a transaction with the payee 'Opening Balance' and the account name as the category:
+//	POpening Balance
+//	L[Account name]
+//
+const (
+	QifDateOrderDMY = "dmy" // 31/12/2022
+	QifDateOrderMDY = "mdy" // 12/31/2022
+
+	QifFileExtension     = ".qif"
+	qifBankType          = "!Type:Bank"
+	qifOpeningBalanceRef = "Opening Balance"
+)
+
+var (
+	qifAccountTypes = []string{"!type:bank", "!type:cash", "!type:ccard"}
+)
+
+type QifTransaction struct {
+	Date     time.Time
+	Amount   Money // Negative for a debit
+	Payee    string
+	Memo     string
+	Category string
+	Opening  bool // The opening balance of the account
+}
+
+func (t *QifTransaction) String() string {
+	return fmt.Sprintf("%s %s %s open:%t", FormatDateTime(t.Date), t.Amount, t.Ref(), t.Opening)
+}
+
+//
+// The transaction ref is the payee with the memo in brackets if it adds anything.
+//
+func (t *QifTransaction) Ref() string {
+	if t.Memo == "" || t.Memo == t.Payee {
+		return t.Payee
+	}
+	if t.Payee == "" {
+		return t.Memo
+	}
+	return fmt.Sprintf("%s [%s]", t.Payee, t.Memo)
+}
+
+func IsQifFile(fileName string) bool {
+	return strings.ToLower(filepath.Ext(fileName)) == QifFileExtension
+}
+
+func ParseQifFile(fileName, dateOrder string) ([]*QifTransaction, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	txs, err := ParseQif(f, dateOrder)
+	if err != nil {
+		return nil, fmt.Errorf("file '%s' %s", fileName, err.Error())
+	}
+	return txs, nil
+}
+
+//
+// Return the transactions in the bank (cash or credit card) sections of QIF data.
+// Other sections (for example !Account or !Type:Cat) are ignored.
+//
+func ParseQif(r io.Reader, dateOrder string) ([]*QifTransaction, error) {
+	txs := make([]*QifTransaction, 0)
+	scanner := bufio.NewScanner(r)
+	inBank := false
+	found := false
+	var tx *QifTransaction
+	var hasDate, hasAmount bool
+	line := 0
+	for scanner.Scan() {
+		line++
+		s := strings.TrimRight(strings.TrimPrefix(scanner.Text(), string(csvBOM)), " \t\r")
+		if s == "" {
+			continue
+		}
+		if strings.HasPrefix(s, "!") {
+			inBank = false
+			for _, t := range qifAccountTypes {
+				if strings.HasPrefix(strings.ToLower(strings.ReplaceAll(s, " ", "")), t) {
+					inBank = true
+					found = true
+				}
+			}
+			continue
+		}
+		if !inBank {
+			continue
+		}
+		if tx == nil {
+			tx = &QifTransaction{}
+			hasDate, hasAmount = false, false
+		}
+		v := strings.TrimSpace(s[1:])
+		switch s[0] {
+		case 'D':
+			dt, err := ParseQifDate(v, dateOrder)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err.Error())
+			}
+			tx.Date = dt
+			hasDate = true
+		case 'T', 'U':
+			am, err := parseQifAmount(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err.Error())
+			}
+			tx.Amount = am
+			hasAmount = true
+		case 'P':
+			tx.Payee = v
+		case 'M':
+			tx.Memo = v
+		case 'L':
+			tx.Category = v
+		case '^':
+			if !hasDate {
+				return nil, fmt.Errorf("line %d: transaction %d does not have a date (D)", line, len(txs)+1)
+			}
+			if !hasAmount {
+				return nil, fmt.Errorf("line %d: transaction %d does not have an amount (T)", line, len(txs)+1)
+			}
+			tx.Opening = strings.HasPrefix(tx.Category, "[") && strings.EqualFold(tx.Payee, qifOpeningBalanceRef)
+			txs = append(txs, tx)
+			tx = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("does not contain bank transactions. %s was not found", qifBankType)
+	}
+	if tx != nil {
+		return nil, fmt.Errorf("line %d: transaction %d is not ended with '^'", line, len(txs)+1)
+	}
+	return txs, nil
+}
+
+//
+// Import the transactions in a QIF file in to a 'transactions' node.
+// Transactions that already exist are not added.
+// The opening balance is added as the initial value (iv) if the account does not already have one.
+//
+func ImportQifData(txNode parser.NodeC, fileName, dateOrder string) (int, error) {
+	nodes, err := QifTransactionNodes(fileName, dateOrder)
+	if err != nil {
+		return 0, err
+	}
+	return importNewNodes(txNode, nodes)
+}
+
+//
+// Read the transactions in a QIF file. The opening balance is an initial value (iv). See PreviewImport.
+// An initial value cannot be negative so a negative opening balance (for example a credit card) is an error.
+//
+func QifTransactionNodes(fileName, dateOrder string) ([]*parser.JsonObject, error) {
+	txs, err := ParseQifFile(fileName, dateOrder)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*parser.JsonObject, 0)
+	for _, tx := range txs {
+		txType := TX_TYPE_CRE
+		if tx.Opening {
+			if tx.Amount < 0 {
+				return nil, fmt.Errorf("file '%s' opening balance %s is negative. The initial value of an account cannot be negative", fileName, tx.Amount)
+			}
+			txType = TX_TYPE_IV
+		} else if tx.Amount < 0 {
+			txType = TX_TYPE_DEB
+		}
+		nodes = append(nodes, newTransactionNode(tx.Date, tx.Ref(), txType, tx.Amount.Abs()))
+	}
+	return nodes, nil
+}
+
+//
+// Write an account as QIF. The opening balance is written first followed by the transactions, oldest first.
+// The opening balance is the balance after the initial value (iv) transaction.
+//
+func ExportQif(w io.Writer, acc *AccountData, dateOrder string) error {
+	layout, err := qifDateLayout(dateOrder)
+	if err != nil {
+		return err
+	}
+	txs := make([]*TranactionData, 0)
+	var iv *TranactionData
+	for _, tx := range acc.Transactions {
+		if tx.HasError() {
+			return fmt.Errorf("account '%s' transaction %s", acc.AccountName, tx.err.Error())
+		}
+		if tx.TxType() == TX_TYPE_IV {
+			iv = tx
+		} else {
+			txs = append(txs, tx)
+		}
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].dateTime.Before(txs[j].dateTime)
+	})
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s\n", qifBankType)
+	if iv != nil || acc.InitialValue != 0 {
+		dt := time.Now()
+		opening := acc.InitialValue
+		if iv != nil {
+			dt = iv.dateTime
+			opening = iv.LineValue()
+		}
+		if len(txs) > 0 && txs[0].dateTime.Before(dt) {
+			dt = txs[0].dateTime
+		}
+		fmt.Fprintf(bw, "D%s\nT%s\nCX\nP%s\nL[%s]\n^\n", dt.Format(layout), opening, qifOpeningBalanceRef, acc.AccountName)
+	}
+	for _, tx := range txs {
+		am := tx.AbsValue()
+		if tx.TxType() == TX_TYPE_DEB {
+			am = -am
+		}
+		fmt.Fprintf(bw, "D%s\nT%s\nP%s\n^\n", tx.dateTime.Format(layout), am, qifLine(tx.Ref()))
+	}
+	return bw.Flush()
+}
+
+//
+// Parse a QIF date. Quicken writes dates in many ways, for example 31/12/2022, 31/12/22, 12/31'22 or 1/ 5'22.
+// Two digit years are 19xx unless followed by an apostrophe (Quicken for 20xx) or less than 50.
+//
+func ParseQifDate(s, dateOrder string) (time.Time, error) {
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '\'' || r == '-' || r == '.'
+	})
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("date '%s' is not a valid QIF date", s)
+	}
+	n := make([]int, 3)
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("date '%s' is not a valid QIF date", s)
+		}
+		n[i] = v
+	}
+	var d, m int
+	switch dateOrder {
+	case QifDateOrderDMY, "":
+		d, m = n[0], n[1]
+	case QifDateOrderMDY:
+		m, d = n[0], n[1]
+	default:
+		return time.Time{}, fmt.Errorf("QIF date order '%s' is not valid. Use '%s' or '%s'", dateOrder, QifDateOrderDMY, QifDateOrderMDY)
+	}
+	y := n[2]
+	if y < 100 {
+		if strings.Contains(s, "'") || y < 50 {
+			y = y + 2000
+		} else {
+			y = y + 1900
+		}
+	}
+	dt := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	if dt.Day() != d || int(dt.Month()) != m {
+		return time.Time{}, fmt.Errorf("date '%s' is not a valid QIF date", s)
+	}
+	return dt, nil
+}
+
+func qifDateLayout(dateOrder string) (string, error) {
+	switch dateOrder {
+	case QifDateOrderDMY, "":
+		return "02/01/2006", nil
+	case QifDateOrderMDY:
+		return "01/02/2006", nil
+	}
+	return "", fmt.Errorf("QIF date order '%s' is not valid. Use '%s' or '%s'", dateOrder, QifDateOrderDMY, QifDateOrderMDY)
+}
+
+func parseQifAmount(s string) (Money, error) {
+	m, err := ParseMoney(strings.ReplaceAll(s, ",", ""))
+	if err != nil {
+		return 0, fmt.Errorf("amount '%s' is not a valid amount", s)
+	}
+	return m, nil
+}
+
+//
+// Values must be on a single line
+//
+func qifLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
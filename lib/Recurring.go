@@ -0,0 +1,387 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+//
+// Recurring transactions (standing orders) are templates in a 'recurring' list in an asset account:
+//	"recurring": [{"ref":"Rent","val":500,"type":"db","schedule":"monthly","day":1,"start":"2022-01-01","last":"2022-03-01"}]
+// Schedules:
+//	monthly: On 'day' of each month. The last day of the month is used for short months.
+//	weekly:  Every 7 days from 'start'.
+//	yearly:  On the day and month of 'start' each year.
+// 'last' is the date of the last transaction added. Instances after 'last' (or from 'start') up to today are due.
+//
+const (
+	IdTxRecurring   = "recurring"
+	IdRecurSchedule = "schedule"
+	IdRecurDay      = "day"
+	IdRecurStart    = "start"
+	IdRecurLast     = "last" // Optional. Set when transactions are added
+
+	RECUR_MONTHLY = "monthly"
+	RECUR_WEEKLY  = "weekly"
+	RECUR_YEARLY  = "yearly"
+
+	recurMaxDue = 1000 // Limit the instances from a single template
+)
+
+var (
+	RECUR_SCHEDULE_OPTIONS = []string{RECUR_MONTHLY, RECUR_WEEKLY, RECUR_YEARLY}
+	RECUR_SCHEDULE_LABELS  = []string{"Monthly", "Weekly", "Yearly"}
+)
+
+type RecurringTransaction struct {
+	Ref      string
+	Value    Money
+	TxType   TransactionTypeEnum
+	Category string
+	Schedule string
+	Day      int // Day of the month for monthly
+	Start    time.Time
+	Last     time.Time // Zero if no transactions have been added
+	Err      error     // Set if the template in the account is not valid
+}
+
+//
+// A transaction that is due from a recurring template.
+// Exists is true if the account already has the same transaction. It will not be added again.
+//
+type DueTransaction struct {
+	User     string
+	Account  string
+	Date     time.Time
+	Template *RecurringTransaction
+	Exists   bool
+	node     *parser.JsonObject // The template node. Updated with 'last'
+	txNode   *parser.JsonList
+}
+
+func NewRecurringTransaction(ref string, value Money, txType TransactionTypeEnum, category, schedule string, day int, start time.Time) (*RecurringTransaction, error) {
+	r := &RecurringTransaction{Ref: strings.TrimSpace(ref), Value: value, TxType: txType, Category: strings.TrimSpace(category), Schedule: schedule, Day: day, Start: dateOnly(start)}
+	return r, r.validate()
+}
+
+func (r *RecurringTransaction) validate() error {
+	if r.Ref == "" {
+		return fmt.Errorf("recurring transaction must have a ref")
+	}
+	if r.Value <= 0 {
+		return fmt.Errorf("recurring transaction '%s' value must be greater than 0", r.Ref)
+	}
+	if r.TxType != TX_TYPE_CRE && r.TxType != TX_TYPE_DEB {
+		return fmt.Errorf("recurring transaction '%s' type must be '%s' or '%s'", r.Ref, TX_TYPE_CRE, TX_TYPE_DEB)
+	}
+	switch r.Schedule {
+	case RECUR_MONTHLY:
+		if r.Day < 1 || r.Day > 31 {
+			return fmt.Errorf("recurring transaction '%s' day must be 1 to 31", r.Ref)
+		}
+	case RECUR_WEEKLY, RECUR_YEARLY:
+	default:
+		return fmt.Errorf("recurring transaction '%s' schedule '%s' must be one of %s", r.Ref, r.Schedule, RECUR_SCHEDULE_OPTIONS)
+	}
+	return nil
+}
+
+//
+// Read a template from a 'recurring' list node
+//
+func NewRecurringTransactionFromNode(n parser.NodeI) (*RecurringTransaction, error) {
+	if n == nil || n.GetNodeType() != parser.NT_OBJECT {
+		return nil, fmt.Errorf("recurring transaction is not an object")
+	}
+	o := n.(*parser.JsonObject)
+	str := func(name string) string {
+		v := o.GetNodeWithName(name)
+		if v == nil || v.GetNodeType() != parser.NT_STRING {
+			return ""
+		}
+		return strings.TrimSpace(v.String())
+	}
+	num := func(name string) float64 {
+		v := o.GetNodeWithName(name)
+		if v == nil || v.GetNodeType() != parser.NT_NUMBER {
+			return 0
+		}
+		return v.(*parser.JsonNumber).GetValue()
+	}
+	start, err := ParseDateString(str(IdRecurStart))
+	if err != nil {
+		return nil, fmt.Errorf("recurring transaction '%s' start '%s' is not a valid date", str(IdTxRef), str(IdRecurStart))
+	}
+	r := &RecurringTransaction{Ref: str(IdTxRef), Value: MoneyFromFloat(num(IdTxVal)), TxType: TransactionTypeEnum(str(IdTxType)), Category: str(IdTxCategory), Schedule: str(IdRecurSchedule), Day: int(num(IdRecurDay)), Start: dateOnly(start)}
+	if str(IdRecurLast) != "" {
+		last, err := ParseDateString(str(IdRecurLast))
+		if err != nil {
+			return nil, fmt.Errorf("recurring transaction '%s' last '%s' is not a valid date", r.Ref, str(IdRecurLast))
+		}
+		r.Last = dateOnly(last)
+	}
+	return r, r.validate()
+}
+
+func (r *RecurringTransaction) toNode() *parser.JsonObject {
+	n := parser.NewJsonObject("")
+	n.Add(parser.NewJsonString(IdTxRef, r.Ref))
+	n.Add(parser.NewJsonNumber(IdTxVal, r.Value.Float64()))
+	n.Add(parser.NewJsonString(IdTxType, string(r.TxType)))
+	if r.Category != "" {
+		n.Add(parser.NewJsonString(IdTxCategory, r.Category))
+	}
+	n.Add(parser.NewJsonString(IdRecurSchedule, r.Schedule))
+	if r.Schedule == RECUR_MONTHLY {
+		n.Add(parser.NewJsonNumber(IdRecurDay, float64(r.Day)))
+	}
+	n.Add(parser.NewJsonString(IdRecurStart, FormatDateTime(r.Start)))
+	if !r.Last.IsZero() {
+		n.Add(parser.NewJsonString(IdRecurLast, FormatDateTime(r.Last)))
+	}
+	return n
+}
+
+//
+// E.g. "Monthly on day 1 from 2022-01-01"
+//
+func (r *RecurringTransaction) ScheduleDesc() string {
+	s := ""
+	switch r.Schedule {
+	case RECUR_MONTHLY:
+		s = fmt.Sprintf("Monthly on day %d", r.Day)
+	case RECUR_WEEKLY:
+		s = fmt.Sprintf("Weekly on %s", r.Start.Weekday())
+	case RECUR_YEARLY:
+		s = fmt.Sprintf("Yearly on %d %s", r.Start.Day(), r.Start.Month())
+	}
+	s = fmt.Sprintf("%s from %s", s, FormatDateTime(r.Start))
+	if !r.Last.IsZero() {
+		s = fmt.Sprintf("%s. Last %s", s, FormatDateTime(r.Last))
+	}
+	return s
+}
+
+func (r *RecurringTransaction) String() string {
+	if r.Err != nil {
+		return r.Err.Error()
+	}
+	return fmt.Sprintf("%s %s %s. %s", r.Ref, r.TxType, r.Value, r.ScheduleDesc())
+}
+
+//
+// The dates of the instances after Last (or from Start) up to and including the day 'now'.
+//
+func (r *RecurringTransaction) Due(now time.Time) []time.Time {
+	from := r.Start
+	if !r.Last.IsZero() && !r.Last.Before(from) {
+		from = r.Last.AddDate(0, 0, 1)
+	}
+	to := dateOnly(now)
+	due := make([]time.Time, 0)
+	for i := 0; len(due) < recurMaxDue; i++ {
+		dt := r.instance(i)
+		if dt.After(to) {
+			break
+		}
+		if !dt.Before(from) {
+			due = append(due, dt)
+		}
+	}
+	return due
+}
+
+//
+// The date of instance i counting from the Start month (monthly), day (weekly) or year (yearly).
+// Monthly instances before Start are returned and ignored by Due.
+//
+func (r *RecurringTransaction) instance(i int) time.Time {
+	switch r.Schedule {
+	case RECUR_WEEKLY:
+		return r.Start.AddDate(0, 0, 7*i)
+	case RECUR_YEARLY:
+		return dayInMonth(r.Start.Year()+i, r.Start.Month(), r.Start.Day())
+	default:
+		m := int(r.Start.Month()) - 1 + i
+		return dayInMonth(r.Start.Year()+m/12, time.Month(m%12+1), r.Day)
+	}
+}
+
+//
+// The day in the month. If the month is shorter the last day of the month.
+//
+func dayInMonth(year int, month time.Month, day int) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+//
+// Return the recurring transactions that are due for all users up to the day 'now'.
+// Templates that are invalid are returned as errors. They do not stop other templates.
+//
+func (p *JsonData) FindDueTransactions(now time.Time) ([]*DueTransaction, []error) {
+	due := make([]*DueTransaction, 0)
+	errs := make([]error, 0)
+	for _, user := range p.GetUserRoot().GetValuesSorted() {
+		if !user.IsContainer() {
+			continue
+		}
+		assets := user.(parser.NodeC).GetNodeWithName(IdAssets)
+		if assets == nil || assets.GetNodeType() != parser.NT_OBJECT {
+			continue
+		}
+		for _, acc := range assets.(*parser.JsonObject).GetValuesSorted() {
+			if acc.GetNodeType() != parser.NT_OBJECT {
+				continue
+			}
+			rl := acc.(*parser.JsonObject).GetNodeWithName(IdTxRecurring)
+			if rl == nil || rl.GetNodeType() != parser.NT_LIST {
+				continue
+			}
+			txn := acc.(*parser.JsonObject).GetNodeWithName(IdTxTransactions)
+			if txn == nil || txn.GetNodeType() != parser.NT_LIST {
+				errs = append(errs, fmt.Errorf("user '%s' account '%s' has no '%s' list", user.GetName(), acc.GetName(), IdTxTransactions))
+				continue
+			}
+			keys := make(map[string]bool)
+			for _, t := range txn.(*parser.JsonList).GetValues() {
+				keys[NewTranactionDataFromNode(t).Key()] = true
+			}
+			for _, rn := range rl.(*parser.JsonList).GetValues() {
+				r, err := NewRecurringTransactionFromNode(rn)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("user '%s' account '%s' %s", user.GetName(), acc.GetName(), err.Error()))
+					continue
+				}
+				for _, dt := range r.Due(now) {
+					d := &DueTransaction{User: user.GetName(), Account: acc.GetName(), Date: dt, Template: r, node: rn.(*parser.JsonObject), txNode: txn.(*parser.JsonList)}
+					d.Exists = keys[NewTranactionDataFromNode(d.newTransactionNode()).Key()]
+					due = append(due, d)
+				}
+			}
+		}
+	}
+	return due, errs
+}
+
+//
+// Add the recurring transactions that are due up to the day 'now' and update 'last' in each template.
+// Transactions that already exist are not added again. Returns the number added.
+// The selected path is unchanged (it is returned to dataMapUpdated).
+//
+func (p *JsonData) ApplyDueTransactions(now time.Time, selectedPath *parser.Path) (int, error) {
+	before := p.beginUndo()
+	due, _ := p.FindDueTransactions(now)
+	if len(due) == 0 {
+		return 0, nil
+	}
+	count := 0
+	for _, d := range due {
+		if !d.Exists {
+			d.txNode.Add(d.newTransactionNode())
+			count++
+		}
+		last := d.node.GetNodeWithName(IdRecurLast)
+		if last == nil {
+			d.node.Add(parser.NewJsonString(IdRecurLast, FormatDateTime(d.Date)))
+		} else {
+			last.(*parser.JsonString).SetValue(FormatDateTime(d.Date))
+		}
+	}
+	p.navIndex = createNavIndex(p.dataMap)
+	p.pushUndo("Add Recurring Transactions", selectedPath.String(), selectedPath.String(), before)
+	p.dataMapUpdated("Add Recurring Transactions", selectedPath, nil)
+	return count, nil
+}
+
+func (d *DueTransaction) newTransactionNode() *parser.JsonObject {
+	n := newTransactionNode(d.Date, d.Template.Ref, d.Template.TxType, d.Template.Value)
+	if d.Template.Category != "" {
+		n.Add(parser.NewJsonString(IdTxCategory, d.Template.Category))
+	}
+	return n
+}
+
+func (d *DueTransaction) String() string {
+	s := fmt.Sprintf("%s %s: %s %s %s %s", d.User, d.Account, FormatDateTime(d.Date), d.Template.Ref, d.Template.TxType, d.Template.Value)
+	if d.Exists {
+		return s + " (exists)"
+	}
+	return s
+}
+
+//
+// Add a recurring template to an account. accountPath is user|assets|account.
+//
+func (p *JsonData) AddRecurring(accountPath *parser.Path, r *RecurringTransaction) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	acc, err := parser.Find(p.GetUserRoot(), accountPath)
+	if err != nil || acc.GetNodeType() != parser.NT_OBJECT {
+		return fmt.Errorf("the account '%s' cannot be found", accountPath)
+	}
+	before := p.beginUndo()
+	rl := acc.(*parser.JsonObject).GetNodeWithName(IdTxRecurring)
+	if rl == nil {
+		rl = parser.NewJsonList(IdTxRecurring)
+		acc.(*parser.JsonObject).Add(rl)
+	}
+	if rl.GetNodeType() != parser.NT_LIST {
+		return fmt.Errorf("the account '%s' '%s' node is not a List node", accountPath, IdTxRecurring)
+	}
+	rl.(*parser.JsonList).Add(r.toNode())
+	p.pushUndo(fmt.Sprintf("Add Recurring '%s'", r.Ref), accountPath.String(), accountPath.String(), before)
+	p.dataMapUpdated("Add Recurring", accountPath, nil)
+	return nil
+}
+
+//
+// Remove the recurring template at index from an account. accountPath is user|assets|account.
+// The list is removed when it is empty.
+//
+func (p *JsonData) RemoveRecurring(accountPath *parser.Path, index int) error {
+	acc, err := parser.Find(p.GetUserRoot(), accountPath)
+	if err != nil || acc.GetNodeType() != parser.NT_OBJECT {
+		return fmt.Errorf("the account '%s' cannot be found", accountPath)
+	}
+	rl := acc.(*parser.JsonObject).GetNodeWithName(IdTxRecurring)
+	if rl == nil || rl.GetNodeType() != parser.NT_LIST || index < 0 || index >= rl.(*parser.JsonList).Len() {
+		return fmt.Errorf("the account '%s' does not have recurring transaction %d", accountPath, index)
+	}
+	before := p.beginUndo()
+	values := rl.(*parser.JsonList).GetValues()
+	rl.(*parser.JsonList).Remove(values[index])
+	if rl.(*parser.JsonList).Len() == 0 {
+		acc.(*parser.JsonObject).Remove(rl)
+	}
+	p.pushUndo("Remove Recurring", accountPath.String(), accountPath.String(), before)
+	p.dataMapUpdated("Remove Recurring", accountPath, nil)
+	return nil
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//
+// Remote storage protocol (file.getDataUrl and file.postDataUrl):
+//	GET  <getDataUrl>/<file>  returns 200 with the data. The ETag header is the revision of the data.
+//	POST <postDataUrl>/<file> returns 201. The If-Match header is the revision the data was loaded from.
+//	     The server returns 412 (Precondition Failed) if its copy has a different revision.
+//	     The ETag header of the response is the new revision.
+// A server that does not return an ETag is not checked. The last POST wins.
+//
+const (
+	remoteRevisionHeader = "ETag"
+	remoteIfMatchHeader  = "If-Match"
+	remoteContentType    = "application/octet-stream"
+)
+
+//
+// Returned (wrapped) by a store when the remote data has changed since it was loaded.
+// Use errors.Is(err, ErrRemoteChanged).
+//
+var ErrRemoteChanged = errors.New("the remote data has changed since it was loaded")
+
+//
+// Settings for the HTTP client used for remote data (file.remote in the preferences).
+//	Timeout      For each request (including reading the response). 0 is no timeout.
+//	Retries      The number of times a request is repeated after a connection error or a 5xx response.
+//	             A POST is only repeated if the connection could not be made (the data was not sent).
+//	Backoff      The wait before the first retry. Doubled for each retry.
+//	Token        Sent as 'Authorization: Bearer <token>'.
+//	User         With Password sent as basic auth if there is no Token.
+//	CaFile       PEM file of CA certificates trusted in addition to the system certificates.
+//	CertFile     PEM client certificate for mutual TLS.
+//	KeyFile      PEM private key for the client certificate.
+//
+type RemoteConfig struct {
+	Timeout  time.Duration
+	Retries  int
+	Backoff  time.Duration
+	Token    string
+	User     string
+	Password string
+	CaFile   string
+	CertFile string
+	KeyFile  string
+}
+
+type RemoteClient struct {
+	config *RemoteConfig
+	client *http.Client
+}
+
+var (
+	defaultRemoteClient = &RemoteClient{config: &RemoteConfig{}, client: http.DefaultClient}
+	remoteClient        = defaultRemoteClient
+)
+
+func NewRemoteClient(config *RemoteConfig) (*RemoteClient, error) {
+	if config.Retries < 0 || config.Timeout < 0 || config.Backoff < 0 {
+		return nil, errors.New("remote timeout, retries and backoff cannot be less than 0")
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("remote client certificate requires both a certificate file and a key file")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CaFile != "" {
+		pem, err := ioutil.ReadFile(config.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("remote CA file '%s' could not be read. %s", config.CaFile, err.Error())
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("remote CA file '%s' does not contain any PEM certificates", config.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("remote client certificate '%s' could not be loaded. %s", config.CertFile, err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &RemoteClient{config: config, client: &http.Client{Timeout: config.Timeout, Transport: transport}}, nil
+}
+
+//
+// Set the client used to get and post remote data.
+// nil restores the default client that has no timeout, retries or auth.
+//
+func SetRemoteClient(c *RemoteClient) {
+	if c == nil {
+		c = defaultRemoteClient
+	}
+	remoteClient = c
+}
+
+//
+// Send a request. Connection errors and 5xx responses are retried with a backoff.
+// A POST is only retried if the connection could not be made. Once the data has been sent
+// the server may have stored it so a retry could be refused (412) or store it twice.
+// The returned response body must be closed by the caller.
+//
+func (c *RemoteClient) do(method, url string, data []byte, header map[string]string) (*http.Response, error) {
+	wait := c.config.Backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		for n, v := range header {
+			req.Header.Set(n, v)
+		}
+		if c.config.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(c.config.Token))
+		} else if c.config.User != "" {
+			req.SetBasicAuth(c.config.User, c.config.Password)
+		}
+		resp, err := c.client.Do(req)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		if attempt >= c.config.Retries || (method == http.MethodPost && !isDialError(err)) {
+			if err != nil {
+				return nil, fmt.Errorf("%s %s failed after %d attempt(s). %s", method, url, attempt+1, err.Error())
+			}
+			return resp, nil
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		time.Sleep(wait)
+		wait = wait * 2
+	}
+}
+
+//
+// True if the connection to the server could not be made so the request was not sent.
+//
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func getRemote(getUrl string) ([]byte, string, error) {
+	resp, err := remoteClient.do(http.MethodGet, getUrl, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to get data from server. Return Code: %d Url: %s", resp.StatusCode, getUrl)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, resp.Header.Get(remoteRevisionHeader), nil
+}
+
+//
+// Post the data. If revision is not empty the server must still have that revision.
+// Returns the new revision from the server (empty if the server does not return one).
+//
+func postRemote(postUrl string, data []byte, revision string) (string, error) {
+	header := map[string]string{"Content-Type": remoteContentType}
+	if revision != "" {
+		header[remoteIfMatchHeader] = revision
+	}
+	resp, err := remoteClient.do(http.MethodPost, postUrl, data, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return "", fmt.Errorf("%w. Url: %s", ErrRemoteChanged, postUrl)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("failed to post data to server. Return Code: %d Url: %s", resp.StatusCode, postUrl)
+	}
+	return resp.Header.Get(remoteRevisionHeader), nil
+}
+
+//
+// Create a remote file (used to create a new data file). The file is replaced if it exists.
+//
+func PostRemote(postUrl string, data []byte) error {
+	_, err := postRemote(postUrl, data, "")
+	return err
+}
+
+func (r *FileData) IsRemote() bool {
+	return r.getDataUrl != "" || r.postDataUrl != ""
+}
+
+//
+// The revision of the remote data when it was loaded (or last stored). Empty if not known.
+//
+func (r *FileData) GetRevision() string {
+	return r.revision
+}
+
+//
+// The next store will replace the remote data even if it has changed since it was loaded.
+//
+func (r *FileData) IgnoreRemoteChanges() {
+	r.revision = ""
+}
+
+//
+// Get the current remote data, decrypted with the key used to load or store the data.
+// The revision is updated so that the next store (of data merged with this) is accepted.
+//
+func (r *FileData) GetRemoteContent() ([]byte, error) {
+	if r.getDataUrl == "" {
+		return nil, errors.New("data is not remote")
+	}
+	dat, revision, err := getRemote(fmt.Sprintf("%s/%s", r.getDataUrl, r.fileName))
+	if err != nil {
+		return nil, err
+	}
+	dat, err = r.decryptStored(dat)
+	if err != nil {
+		return nil, err
+	}
+	r.revision = revision
+	return dat, nil
+}
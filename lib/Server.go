@@ -0,0 +1,308 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// A server for the remote storage protocol (see Remote.go) that serves the files in a directory.
+//	GET  /<file>                  The file. ETag is the revision.
+//	POST /<file>                  Replace (or create) the file. If-Match must be the current revision if given.
+//	GET  /<file>/history          Json list of the previous revisions. Oldest first. 404 if there are none.
+//	GET  /<file>/history/<name>   A previous revision.
+// Previous revisions are kept in <dir>/.history/<file>. Retention is the same as for backup files (BackupFileDef).
+// If a token is defined every request must have the header 'Authorization: Bearer <token>'.
+//
+const (
+	serverHistoryDir  = ".history"
+	serverHistoryPath = "history"
+	serverHistoryMask = "%d_%h%m%s%n" // Nanoseconds so revisions posted in the same second are all kept
+	serverHistoryPost = ".bak"
+	serverMaxBody     = 64 * 1024 * 1024
+)
+
+type StorageServer struct {
+	dir        string
+	token      string
+	historyMax int
+	log        func(string)
+	mu         sync.Mutex // Serialise updates so the revision check and the write are atomic
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func NewStorageServer(dir, token string, historyMax int, log func(string)) (*StorageServer, error) {
+	fi, err := os.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("storage directory '%s' does not exist", dir)
+	}
+	if historyMax < 1 {
+		return nil, fmt.Errorf("storage history max '%d' cannot be less than 1", historyMax)
+	}
+	if log == nil {
+		log = func(string) {}
+	}
+	return &StorageServer{dir: dir, token: strings.TrimSpace(token), historyMax: historyMax, log: log}, nil
+}
+
+//
+// Serve on addr. If certFile and keyFile are defined then TLS (https) is used.
+//
+func (s *StorageServer) ListenAndServe(addr, certFile, keyFile string) error {
+	srv := &http.Server{Addr: addr, Handler: s, ReadTimeout: 60 * time.Second, WriteTimeout: 60 * time.Second}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return errors.New("TLS requires both a certificate file and a key file")
+		}
+		s.log(fmt.Sprintf("Serving '%s' on https://%s", s.dir, addr))
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	}
+	s.log(fmt.Sprintf("Serving '%s' on http://%s", s.dir, addr))
+	return srv.ListenAndServe()
+}
+
+func (s *StorageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	s.serve(sw, r)
+	s.log(fmt.Sprintf("%s %s %s %d %d %s", r.RemoteAddr, r.Method, r.URL.Path, sw.status, sw.size, time.Since(start).Round(time.Millisecond)))
+}
+
+func (s *StorageServer) serve(w http.ResponseWriter, r *http.Request) {
+	if !s.authorised(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorised", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !validStorageName(parts[0]) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.getFile(w, parts[0])
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.postFile(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == serverHistoryPath && r.Method == http.MethodGet:
+		s.getHistoryList(w, parts[0])
+	case len(parts) == 3 && parts[1] == serverHistoryPath && r.Method == http.MethodGet:
+		s.getHistoryFile(w, parts[0], parts[2])
+	case len(parts) <= 3:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (s *StorageServer) authorised(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))), []byte(s.token)) == 1
+}
+
+func (s *StorageServer) getFile(w http.ResponseWriter, name string) {
+	dat, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set(remoteRevisionHeader, StorageRevision(dat))
+	w.Header().Set("Content-Type", remoteContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
+
+func (s *StorageServer) postFile(w http.ResponseWriter, r *http.Request, name string) {
+	dat, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, serverMaxBody))
+	if err != nil {
+		http.Error(w, "request body is too large or could not be read", http.StatusRequestEntityTooLarge)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fileName := filepath.Join(s.dir, name)
+	current, err := ioutil.ReadFile(fileName)
+	exists := err == nil
+	if ifMatch := r.Header.Get(remoteIfMatchHeader); ifMatch != "" {
+		if !exists || (ifMatch != "*" && ifMatch != StorageRevision(current)) {
+			http.Error(w, ErrRemoteChanged.Error(), http.StatusPreconditionFailed)
+			return
+		}
+	}
+	if exists {
+		if err = s.addHistory(name, current); err != nil {
+			s.log(fmt.Sprintf("History error for '%s'. %s", name, err.Error()))
+			http.Error(w, "could not keep the previous revision", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err = writeFileAtomic(fileName, dat); err != nil {
+		s.log(fmt.Sprintf("Write error for '%s'. %s", name, err.Error()))
+		http.Error(w, "could not write the file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(remoteRevisionHeader, StorageRevision(dat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+//
+// The history of a file. The history directory is only created if create is true.
+// If it does not exist and is not created the error satisfies os.IsNotExist.
+//
+func (s *StorageServer) historyDef(name string, create bool) (*BackupFileDef, error) {
+	path := filepath.Join(s.dir, serverHistoryDir, name)
+	if create {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	def := NewBackupFileDef(path, string(os.PathSeparator), name+".", serverHistoryMask, serverHistoryPost, int64(s.historyMax))
+	def.SetLog(s.log)
+	return def, def.Init(name)
+}
+
+func (s *StorageServer) addHistory(name string, dat []byte) error {
+	def, err := s.historyDef(name, true)
+	if err != nil {
+		return err
+	}
+	fileName := def.ComposeFullName()
+	for FileExists(fileName) {
+		// The clock has not moved on since the last revision. Never replace a revision.
+		time.Sleep(time.Millisecond)
+		fileName = def.ComposeFullName()
+	}
+	if err = writeFileAtomic(fileName, dat); err != nil {
+		return err
+	}
+	return def.CleanFiles()
+}
+
+func (s *StorageServer) getHistoryList(w http.ResponseWriter, name string) {
+	def, err := s.historyDef(name, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "history not available", http.StatusInternalServerError)
+		return
+	}
+	list, err := def.ListFiles()
+	if err != nil {
+		http.Error(w, "history not available", http.StatusInternalServerError)
+		return
+	}
+	b, _ := json.Marshal(list)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func (s *StorageServer) getHistoryFile(w http.ResponseWriter, name, revision string) {
+	if !validStorageName(revision) || !strings.HasPrefix(revision, name+".") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	dat, err := ioutil.ReadFile(filepath.Join(s.dir, serverHistoryDir, name, revision))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set(remoteRevisionHeader, StorageRevision(dat))
+	w.Header().Set("Content-Type", remoteContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
+
+//
+// The revision (ETag) of file content. The same content always has the same revision.
+//
+func StorageRevision(dat []byte) string {
+	h := sha256.Sum256(dat)
+	return fmt.Sprintf("\"%s\"", hex.EncodeToString(h[:16]))
+}
+
+//
+// A file name without a path that is not hidden. E.g. data.json
+//
+func validStorageName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\:") && filepath.Base(name) == name
+}
+
+//
+// Write to a temp file in the same directory, sync it and rename it over the file.
+// The temp file is hidden (.name.*.tmp) so it is never a valid storage name.
+//
+func writeFileAtomic(fileName string, dat []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(dat)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err2 := tmp.Close()
+	if err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size = w.size + n
+	return n, err
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	totpScheme        = "otpauth"
+	totpType          = "totp"
+	totpDefaultDigits = 6
+	totpDefaultPeriod = 30
+	totpDefaultAlg    = "SHA1"
+)
+
+//
+// A time based one time password (RFC 6238) key.
+// Defined by an otpauth:// URI or just a base32 secret.
+//
+type TotpKey struct {
+	Secret    []byte
+	Issuer    string
+	Account   string
+	Algorithm string // SHA1, SHA256 or SHA512
+	Digits    int
+	Period    int // Seconds
+}
+
+//
+// Parse the value of a !otp item. Either:
+//	otpauth://totp/Issuer:account?secret=BASE32SECRET&issuer=Issuer&algorithm=SHA1&digits=6&period=30
+// or a base32 secret. Spaces, '-' and missing padding are allowed in the secret. It is not case sensitive.
+//
+func ParseTotp(value string) (*TotpKey, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, errors.New("totp secret is empty")
+	}
+	if !strings.HasPrefix(strings.ToLower(value), totpScheme+"://") {
+		secret, err := decodeTotpSecret(value)
+		if err != nil {
+			return nil, err
+		}
+		return &TotpKey{Secret: secret, Algorithm: totpDefaultAlg, Digits: totpDefaultDigits, Period: totpDefaultPeriod}, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("totp uri is invalid. %s", err.Error())
+	}
+	if strings.ToLower(u.Host) != totpType {
+		return nil, fmt.Errorf("totp uri type '%s' is not supported", u.Host)
+	}
+	q := u.Query()
+	secret, err := decodeTotpSecret(q.Get("secret"))
+	if err != nil {
+		return nil, err
+	}
+	k := &TotpKey{Secret: secret, Issuer: q.Get("issuer"), Algorithm: totpDefaultAlg, Digits: totpDefaultDigits, Period: totpDefaultPeriod}
+	label := strings.TrimPrefix(u.Path, "/")
+	if pos := strings.Index(label, ":"); pos >= 0 {
+		if k.Issuer == "" {
+			k.Issuer = strings.TrimSpace(label[:pos])
+		}
+		label = label[pos+1:]
+	}
+	k.Account = strings.TrimSpace(label)
+	if a := q.Get("algorithm"); a != "" {
+		k.Algorithm = strings.ToUpper(a)
+		if k.hashFunc() == nil {
+			return nil, fmt.Errorf("totp algorithm '%s' is not supported", a)
+		}
+	}
+	if d := q.Get("digits"); d != "" {
+		k.Digits, err = strconv.Atoi(d)
+		if err != nil || k.Digits < 6 || k.Digits > 8 {
+			return nil, fmt.Errorf("totp digits '%s' must be 6, 7 or 8", d)
+		}
+	}
+	if p := q.Get("period"); p != "" {
+		k.Period, err = strconv.Atoi(p)
+		if err != nil || k.Period < 1 {
+			return nil, fmt.Errorf("totp period '%s' is invalid", p)
+		}
+	}
+	return k, nil
+}
+
+//
+// The code for the given time
+//
+func (k *TotpKey) CodeAt(t time.Time) string {
+	return hotp(k.hashFunc(), k.Secret, uint64(t.Unix()/int64(k.Period)), k.Digits)
+}
+
+//
+// The number of seconds the code for the given time remains valid
+//
+func (k *TotpKey) RemainingAt(t time.Time) int {
+	return k.Period - int(t.Unix()%int64(k.Period))
+}
+
+func (k *TotpKey) String() string {
+	if k.Issuer == "" && k.Account == "" {
+		return fmt.Sprintf("%s %d digits every %ds", k.Algorithm, k.Digits, k.Period)
+	}
+	return fmt.Sprintf("%s:%s", k.Issuer, k.Account)
+}
+
+func (k *TotpKey) hashFunc() func() hash.Hash {
+	switch k.Algorithm {
+	case "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+func decodeTotpSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(s))
+	if s == "" {
+		return nil, errors.New("totp secret is empty")
+	}
+	if len(s)%8 != 0 {
+		s = s + strings.Repeat("=", 8-len(s)%8)
+	}
+	b, err := base32.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("totp secret is not valid base32")
+	}
+	return b, nil
+}
+
+//
+// RFC 4226 HOTP with dynamic truncation
+//
+func hotp(h func() hash.Hash, secret []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(h, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, bin%uint32(math.Pow10(digits)))
+}
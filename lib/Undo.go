@@ -0,0 +1,283 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"fmt"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+)
+
+//
+// Every operation that changes the data is recorded as a command on the undo stack.
+// A command holds only the nodes the operation changed. For each changed container the
+// children (and their names) before and after. For each changed value the value before and after.
+// Added, removed and renamed items are the same node objects so Undo and Redo restore them
+// (and any earlier command that refers to them) exactly. The data is not copied.
+// A new command clears the redo stack.
+//
+var (
+	undoMax = 50
+)
+
+type undoCommand struct {
+	desc     string
+	undoPath *parser.Path // The item to select after Undo
+	redoPath *parser.Path // The item to select after Redo
+	changes  []*nodeChange
+}
+
+type nodeChange struct {
+	node   parser.NodeI
+	before *nodeState
+	after  *nodeState
+}
+
+//
+// The shallow state of a node. The names and children of a container or the value of a leaf.
+//
+type nodeState struct {
+	names    []string
+	children []parser.NodeI
+	value    interface{}
+}
+
+//
+// The state of every node before an operation. Only used to find what the operation changed.
+//
+type undoState struct {
+	nodes map[parser.NodeI]*nodeState
+}
+
+type undoStack struct {
+	undo []*undoCommand
+	redo []*undoCommand
+}
+
+func newUndoStack() *undoStack {
+	return &undoStack{undo: make([]*undoCommand, 0), redo: make([]*undoCommand, 0)}
+}
+
+//
+// The maximum number of operations that can be undone. 0 or less means nothing is recorded.
+//
+func SetUndoMax(max int) {
+	undoMax = max
+}
+
+func (p *JsonData) CanUndo() bool {
+	return len(p.undoStack.undo) > 0
+}
+
+func (p *JsonData) CanRedo() bool {
+	return len(p.undoStack.redo) > 0
+}
+
+//
+// Forget everything that can be undone or redone. For example before the data is locked.
+//
+func (p *JsonData) ClearUndo() {
+	p.undoStack = newUndoStack()
+}
+
+//
+// Description of the operation that Undo will reverse. Empty if there is nothing to undo.
+//
+func (p *JsonData) UndoDesc() string {
+	if !p.CanUndo() {
+		return ""
+	}
+	return p.undoStack.undo[len(p.undoStack.undo)-1].desc
+}
+
+//
+// Description of the operation that Redo will repeat. Empty if there is nothing to redo.
+//
+func (p *JsonData) RedoDesc() string {
+	if !p.CanRedo() {
+		return ""
+	}
+	return p.undoStack.redo[len(p.undoStack.redo)-1].desc
+}
+
+//
+// Reverse the last operation. dataMapUpdated is called with the item to select.
+//
+func (p *JsonData) Undo() error {
+	if !p.CanUndo() {
+		return fmt.Errorf("there is nothing to undo")
+	}
+	s := p.undoStack
+	c := s.undo[len(s.undo)-1]
+	for _, ch := range c.changes {
+		setNodeState(ch.node, ch.before)
+	}
+	p.navIndex = createNavIndex(p.dataMap)
+	s.undo = s.undo[:len(s.undo)-1]
+	s.redo = append(s.redo, c)
+	p.dataMapUpdated(fmt.Sprintf("Undo %s", c.desc), c.undoPath, nil)
+	return nil
+}
+
+//
+// Repeat the last operation that was undone. dataMapUpdated is called with the item to select.
+//
+func (p *JsonData) Redo() error {
+	if !p.CanRedo() {
+		return fmt.Errorf("there is nothing to redo")
+	}
+	s := p.undoStack
+	c := s.redo[len(s.redo)-1]
+	for _, ch := range c.changes {
+		setNodeState(ch.node, ch.after)
+	}
+	p.navIndex = createNavIndex(p.dataMap)
+	s.redo = s.redo[:len(s.redo)-1]
+	s.undo = append(s.undo, c)
+	p.dataMapUpdated(fmt.Sprintf("Redo %s", c.desc), c.redoPath, nil)
+	return nil
+}
+
+//
+// Record a change made outside of JsonData (for example an import or a committed edit) so it can be undone.
+// op is called to make the change. Nothing is recorded if the data is not changed.
+// dataMapUpdated is NOT called. That is up to the caller.
+//
+func (p *JsonData) RecordUndo(desc string, path *parser.Path, op func() error) error {
+	before := p.beginUndo()
+	err := op()
+	p.pushUndo(desc, path.String(), path.String(), before)
+	return err
+}
+
+//
+// Called by each operation BEFORE the data is changed. nil if undo is disabled.
+//
+func (p *JsonData) beginUndo() *undoState {
+	if undoMax <= 0 {
+		return nil
+	}
+	st := &undoState{nodes: make(map[parser.NodeI]*nodeState)}
+	st.add(p.dataMap)
+	return st
+}
+
+func (st *undoState) add(n parser.NodeI) {
+	ns := newNodeState(n)
+	st.nodes[n] = ns
+	for _, c := range ns.children {
+		st.add(c)
+	}
+}
+
+//
+// Called by each operation AFTER the data has been changed and BEFORE dataMapUpdated is called.
+// The nodes that differ from before are recorded. Nodes added by the operation are part of their parent's change.
+// The paths are strings as parser.Path values can share (and overwrite) elements when appended to.
+//
+func (p *JsonData) pushUndo(desc, undoPath, redoPath string, before *undoState) {
+	if before == nil || undoMax <= 0 {
+		return
+	}
+	changes := make([]*nodeChange, 0)
+	for n, b := range before.nodes {
+		a := newNodeState(n)
+		if !a.equal(b) {
+			changes = append(changes, &nodeChange{node: n, before: b, after: a})
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	s := p.undoStack
+	s.undo = append(s.undo, &undoCommand{desc: desc, undoPath: parser.NewBarPath(undoPath), redoPath: parser.NewBarPath(redoPath), changes: changes})
+	if len(s.undo) > undoMax {
+		s.undo = s.undo[len(s.undo)-undoMax:]
+	}
+	s.redo = s.redo[:0]
+}
+
+func newNodeState(n parser.NodeI) *nodeState {
+	ns := &nodeState{}
+	switch v := n.(type) {
+	case *parser.JsonObject:
+		ns.children = v.GetValuesSorted()
+	case *parser.JsonList:
+		ns.children = v.GetValues()
+	case *parser.JsonString:
+		ns.value = v.GetValue()
+	case *parser.JsonNumber:
+		ns.value = v.GetValue()
+	case *parser.JsonBool:
+		ns.value = v.GetValue()
+	}
+	ns.names = make([]string, len(ns.children))
+	for i, c := range ns.children {
+		ns.names[i] = c.GetName()
+	}
+	return ns
+}
+
+func (ns *nodeState) equal(other *nodeState) bool {
+	if ns.value != other.value || len(ns.children) != len(other.children) {
+		return false
+	}
+	for i, c := range ns.children {
+		if c != other.children[i] || ns.names[i] != other.names[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// Put a node back in to a recorded state. The children are the same node objects. Only their names are restored.
+//
+func setNodeState(n parser.NodeI, ns *nodeState) {
+	switch v := n.(type) {
+	case *parser.JsonObject:
+		v.Clear()
+		for i, c := range ns.children {
+			setNodeName(c, ns.names[i])
+			v.Add(c)
+		}
+	case *parser.JsonList:
+		v.Clear()
+		for i, c := range ns.children {
+			setNodeName(c, ns.names[i])
+			v.Add(c)
+		}
+	case *parser.JsonString:
+		v.SetValue(ns.value.(string))
+	case *parser.JsonNumber:
+		v.SetValue(ns.value.(float64))
+	case *parser.JsonBool:
+		v.SetValue(ns.value.(bool))
+	}
+}
+
+//
+// A node can only be renamed by parser.Rename in its parent. Use a temporary parent.
+//
+func setNodeName(n parser.NodeI, name string) {
+	if n.GetName() == name || n.GetName() == "" {
+		return
+	}
+	tmp := parser.NewJsonList("")
+	tmp.Add(n)
+	parser.Rename(tmp, n, name)
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright (C) 2021 Stuart Davies (stuartdd)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package lib
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Watch files and directories for changes made by other programs (another instance, a sync tool or a restore).
+// The paths are polled. A file has changed if its size or modification time has changed AND its content has changed.
+// A directory has changed if a file has been added, removed or replaced.
+// Changes made inside Update (our own saves) are not reported.
+// A changed path stays changed until Accept, Watch or a successful Update.
+//
+type FileWatcher struct {
+	mu       sync.Mutex
+	interval time.Duration
+	onChange func(path string)
+	stamps   map[string]*fileStamp
+	changed  map[string]bool // Changed by another program and not yet accepted
+	paths    []string
+	stop     chan bool
+}
+
+//
+// Returned (wrapped) by Update when the file to be saved has been changed by another program.
+// Use errors.Is(err, ErrFileChanged).
+//
+var ErrFileChanged = errors.New("the file has been changed by another program")
+
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+	hash    string // sha256 of a file. The list of names, sizes and times of a directory
+}
+
+func NewFileWatcher(interval time.Duration, onChange func(path string)) *FileWatcher {
+	if onChange == nil {
+		onChange = func(string) {}
+	}
+	return &FileWatcher{interval: interval, onChange: onChange, stamps: make(map[string]*fileStamp), changed: make(map[string]bool), paths: make([]string, 0)}
+}
+
+//
+// Replace the watched paths. The current state of each path is the state that is not reported.
+// Empty paths are ignored.
+//
+func (w *FileWatcher) Watch(paths ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paths = make([]string, 0)
+	for _, p := range paths {
+		if p != "" {
+			w.paths = append(w.paths, p)
+		}
+	}
+	w.accept()
+}
+
+func (w *FileWatcher) GetPaths() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string{}, w.paths...)
+}
+
+//
+// Poll every interval until Stop. Does nothing if the interval is 0 or the watcher is started.
+//
+func (w *FileWatcher) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.interval <= 0 || w.stop != nil {
+		return
+	}
+	stop := make(chan bool)
+	w.stop = stop
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				w.Check()
+			}
+		}
+	}()
+}
+
+func (w *FileWatcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
+
+//
+// Check the paths now. onChange is called for each path that has changed since the last check.
+// A change is only reported once. Returns the changed paths.
+//
+func (w *FileWatcher) Check() []string {
+	w.mu.Lock()
+	changed := w.check()
+	w.mu.Unlock()
+	for _, p := range changed {
+		w.onChange(p)
+	}
+	return changed
+}
+
+//
+// Run f (a save of path). Changes made by other programs before f are reported first.
+// If path has been changed by another program (and not accepted) f is not called and ErrFileChanged is returned.
+// If f succeeds the changes it made are not reported and path is accepted.
+// Changes to other paths that were reported before f stay changed until they are accepted.
+//
+func (w *FileWatcher) Update(path string, f func() error) error {
+	w.mu.Lock()
+	changed := w.check()
+	var err error
+	if w.changed[path] {
+		err = fmt.Errorf("%w. File: %s", ErrFileChanged, path)
+	} else {
+		err = f()
+		if err == nil {
+			w.restamp()
+			delete(w.changed, path)
+		}
+	}
+	w.mu.Unlock()
+	for _, p := range changed {
+		w.onChange(p)
+	}
+	return err
+}
+
+//
+// Accept the changes made to path by another program. The next Update will replace them.
+//
+func (w *FileWatcher) Accept(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.changed, path)
+}
+
+func (w *FileWatcher) accept() {
+	w.changed = make(map[string]bool)
+	w.restamp()
+}
+
+//
+// The current state of each path is the state that is not reported. The changed flags are not cleared.
+//
+func (w *FileWatcher) restamp() {
+	w.stamps = make(map[string]*fileStamp)
+	for _, p := range w.paths {
+		w.stamps[p] = newFileStamp(p, nil)
+	}
+}
+
+func (w *FileWatcher) check() []string {
+	changed := make([]string, 0)
+	for _, p := range w.paths {
+		previous := w.stamps[p]
+		current := newFileStamp(p, previous)
+		if previous != nil && !current.equal(previous) {
+			changed = append(changed, p)
+			w.changed[p] = true
+		}
+		w.stamps[p] = current
+	}
+	return changed
+}
+
+//
+// The stamp of a path. The hash of a file is only re-calculated if the size or time differs from previous.
+//
+func newFileStamp(path string, previous *fileStamp) *fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return &fileStamp{exists: false}
+	}
+	fs := &fileStamp{exists: true, size: fi.Size(), modTime: fi.ModTime()}
+	if fi.IsDir() {
+		fs.hash = dirHash(path)
+		return fs
+	}
+	if previous != nil && previous.exists && previous.size == fs.size && previous.modTime.Equal(fs.modTime) {
+		fs.hash = previous.hash
+		return fs
+	}
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		// Cannot read it (for example while it is being replaced). Try again next time.
+		if previous != nil {
+			return previous
+		}
+		return fs
+	}
+	fs.hash = fmt.Sprintf("%x", sha256.Sum256(dat))
+	return fs
+}
+
+func dirHash(path string) string {
+	list, err := ioutil.ReadDir(path)
+	if err != nil {
+		return ""
+	}
+	names := make([]string, 0)
+	for _, f := range list {
+		names = append(names, fmt.Sprintf("%s:%d:%d", f.Name(), f.Size(), f.ModTime().UnixNano()))
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+func (fs *fileStamp) equal(other *fileStamp) bool {
+	if fs.exists != other.exists {
+		return false
+	}
+	return !fs.exists || fs.hash == other.hash
+}
@@ -0,0 +1,93 @@
+package libtest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+func TestAudit(t *testing.T) {
+	jd := dataLoad(t, "TestDataTypesGold.json")
+	o := lib.NewAuditOptions()
+	res := jd.Audit(o)
+	counts := auditCounts(res)
+	if counts[lib.AUDIT_DUPLICATE] != 8 {
+		t.Errorf("Should be 8 duplicates not %d", counts[lib.AUDIT_DUPLICATE])
+	}
+	if counts[lib.AUDIT_WEAK] != 10 {
+		t.Errorf("Should be 10 weak values not %d", counts[lib.AUDIT_WEAK])
+	}
+	if counts[lib.AUDIT_STALE] != 0 {
+		t.Errorf("Should be no stale values without ChangedAt. Found %d", counts[lib.AUDIT_STALE])
+	}
+	for _, r := range res {
+		name := r.Path.StringLast()
+		if name == "notes" || name == "userId" || name == "link" {
+			t.Errorf("Ignored field %s should not be audited", r.Path)
+		}
+		if r.Path.String() == "UserA|pwHints|MyApp|pre" && r.Issue == lib.AUDIT_DUPLICATE {
+			if r.Desc != "Same as GMail B [pre]" {
+				t.Errorf("Duplicate desc '%s' is incorrect", r.Desc)
+			}
+			if r.HintPath().String() != "UserA|pwHints|MyApp" {
+				t.Errorf("Hint path '%s' is incorrect", r.HintPath())
+			}
+		}
+		if r.Path.String() == "UserB|pwHints|GMail B|post" && r.Issue == lib.AUDIT_WEAK {
+			t.Errorf("'123 Fred' should not be weak")
+		}
+	}
+	if !strings.HasPrefix(res[0].Path.String(), "UserA|pwHints|MyApp|") {
+		t.Errorf("Results should be ordered by path. First is %s", res[0].Path)
+	}
+
+	o.Ignore = append(o.Ignore, "pre", "post", "some more notes", "some More!")
+	o.MinBits = 0
+	o.MaxAge = 24 * time.Hour * 30
+	o.ChangedAt = func(p *parser.Path) (time.Time, bool) {
+		if p.String() == "UserB|pwHints|GMail B|po!positional" {
+			return o.Now.Add(-24 * time.Hour * 31), true
+		}
+		return time.Time{}, false
+	}
+	res = jd.Audit(o)
+	counts = auditCounts(res)
+	if counts[lib.AUDIT_STALE] != 1 || counts[lib.AUDIT_WEAK] != 0 || counts[lib.AUDIT_DUPLICATE] != 0 {
+		t.Errorf("Should only be 1 stale value. %v", counts)
+	}
+	if res[0].Desc != "Not changed for 31 days" {
+		t.Errorf("Stale desc '%s' is incorrect", res[0].Desc)
+	}
+
+	// Values with no change time use the data time stamp
+	ts, ok := jd.GetTimeStamp()
+	if !ok {
+		t.Fatal("Data time stamp should be found")
+	}
+	o.ChangedAt = nil
+	o.Fallback = ts
+	o.Now = ts.Add(24 * time.Hour * 40)
+	res = jd.Audit(o)
+	counts = auditCounts(res)
+	if counts[lib.AUDIT_STALE] == 0 || counts[lib.AUDIT_STALE] != len(res) {
+		t.Errorf("All values should be stale using the data time stamp. %v", counts)
+	}
+	if res[0].Desc != "Not changed for at least 40 days (data time stamp)" {
+		t.Errorf("Stale desc '%s' is incorrect", res[0].Desc)
+	}
+	o.Now = ts.Add(24 * time.Hour * 20)
+	if counts = auditCounts(jd.Audit(o)); counts[lib.AUDIT_STALE] != 0 {
+		t.Errorf("Values should not be stale within MaxAge of the data time stamp. %v", counts)
+	}
+}
+
+func auditCounts(res []*lib.AuditResult) map[lib.AuditIssueEnum]int {
+	counts := make(map[lib.AuditIssueEnum]int)
+	for _, r := range res {
+		counts[r.Issue]++
+	}
+	return counts
+}
@@ -0,0 +1,115 @@
+package libtest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+const categoryAccountsJson = `{"groups":{"UserA":{"assets":{
+	"Current":{"transactions":[
+		{"date":"2021-12-31","ref":"Initial","type":"iv","val":1000},
+		{"date":"2021-12-31","ref":"Last year","type":"db","val":5,"category":"Food"},
+		{"date":"2022-01-01","ref":"TESCO","type":"db","val":20.5,"category":"Food"},
+		{"date":"2022-01-31 23:59:00","ref":"SALARY","type":"cr","val":2000,"category":"Income"},
+		{"date":"2022-01-15","ref":"REFUND","type":"cr","val":4.5,"category":"Food"},
+		{"date":"2022-01-16","ref":"CASH","type":"db","val":50},
+		{"date":"2022-02-01","ref":"Next month","type":"db","val":7,"category":"Food"}
+	]},
+	"Savings":{"transactions":[
+		{"date":"2022-01-10","ref":"SAINSBURY","type":"db","val":10,"category":"Food"}
+	]},
+	"Euro":{"currency":"EUR","transactions":[
+		{"date":"2022-01-01","ref":"Initial","type":"iv","val":500},
+		{"date":"2022-01-12","ref":"CAFE","type":"db","val":12,"category":"Food"}
+	]}}}}, "timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`
+
+func TestCategoryRules(t *testing.T) {
+	rules, err := lib.NewCategoryRules([]string{"Groceries=(?i)tesco|sainsbury", "", "Bills = ^BT ", "Shopping=(?i)tesco"})
+	testErrorNil(t, err, "NewCategoryRules")
+	if rules.Len() != 3 {
+		t.Errorf("Should have 3 rules not %d", rules.Len())
+	}
+	for ref, cat := range map[string]string{"Tesco Store 3144": "Groceries", "BT GROUP PLC": "Bills", "ABT GROUP": "", "SAINSBURY": "Groceries"} {
+		if rules.Categorise(ref) != cat {
+			t.Errorf("'%s' should be '%s' not '%s'", ref, cat, rules.Categorise(ref))
+		}
+	}
+	_, err = lib.NewCategoryRules([]string{"Groceries"})
+	testError(t, err, "must be 'category=regex'")
+	_, err = lib.NewCategoryRules([]string{"=tesco"})
+	testError(t, err, "must have a category and a regex")
+	_, err = lib.NewCategoryRules([]string{"Bad=(tesco"})
+	testError(t, err, "regex is invalid")
+
+	hasCat := txNode("2022-03-09", "TESCO", "db", 1)
+	hasCat.Add(parser.NewJsonString(lib.IdTxCategory, "Treats"))
+	nodes := []*parser.JsonObject{txNode("2022-03-09", "TESCO", "db", 1), txNode("2022-03-09", "TESCO", "iv", 1), txNode("2022-03-09", "OTHER", "db", 1), hasCat}
+	if rules.Apply(nodes) != 1 {
+		t.Error("Only 1 node should be categorised")
+	}
+	for i, cat := range []string{"Groceries", "", "", "Treats"} {
+		if lib.NewTranactionDataFromNode(nodes[i]).Category() != cat {
+			t.Errorf("Node %d category should be '%s'", i, cat)
+		}
+	}
+}
+
+func TestUpdateCategory(t *testing.T) {
+	n := txNode("2022-03-09", "TESCO", "db", 1)
+	if lib.UpdateNodeFromTranactionData(n, lib.IdTxCategory, " ") != 0 {
+		t.Error("An empty category should not be added")
+	}
+	if lib.UpdateNodeFromTranactionData(n, lib.IdTxCategory, "Food") != 1 || lib.NewTranactionDataFromNode(n).Category() != "Food" {
+		t.Error("The category should be added")
+	}
+	if lib.UpdateNodeFromTranactionData(n, lib.IdTxCategory, "Treats") != 1 || lib.NewTranactionDataFromNode(n).Category() != "Treats" {
+		t.Error("The category should be updated")
+	}
+}
+
+func TestCategoryReport(t *testing.T) {
+	jd, err := lib.NewJsonData([]byte(categoryAccountsJson), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	lib.InitUserAssetsCache(jd)
+	accounts, err := lib.FindAllUserAccounts("UserA")
+	testErrorNil(t, err, "FindAllUserAccounts")
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC)
+	r := lib.NewCategoryReport("User 'UserA'", accounts, from, to)
+	if len(r.Currencies) != 2 || r.Currencies[0].Currency != "GBP" || r.Currencies[1].Currency != "EUR" || r.Count != 6 {
+		t.Fatalf("Each currency should have its own totals:\n%s", r)
+	}
+	gbp := r.Currencies[0]
+	if len(gbp.Totals) != 3 || gbp.Totals[0].Category != "Food" || gbp.Totals[1].Category != "Income" || gbp.Totals[2].Category != lib.UncategorisedName {
+		t.Fatalf("Categories are wrong:\n%s", r)
+	}
+	food := gbp.Totals[0]
+	if food.Count != 3 || food.Debit.String() != "30.50" || food.Credit.String() != "4.50" || food.Net().String() != "-26.00" {
+		t.Errorf("Food is wrong:\n%s", r)
+	}
+	if gbp.Count != 5 || gbp.Credit.String() != "2004.50" || gbp.Debit.String() != "80.50" {
+		t.Errorf("Totals are wrong:\n%s", r)
+	}
+	eur := r.Currencies[1]
+	if len(eur.Totals) != 1 || eur.Totals[0].Category != "Food" || eur.Count != 1 || eur.Debit.String() != "12.00" {
+		t.Errorf("EUR should not be added to GBP:\n%s", r)
+	}
+	lines := r.Lines()
+	if lines[0] != "User 'UserA': 2022-01-01 to 2022-01-31" || !strings.HasSuffix(lines[1], "Net GBP") || !strings.HasPrefix(lines[5], "Total") || !strings.HasSuffix(lines[5], "1924.00") {
+		t.Errorf("Report lines are wrong:\n%s", r)
+	}
+	if lines[6] != "" || !strings.HasSuffix(lines[7], "Net EUR") || !strings.HasPrefix(lines[len(lines)-1], "Total") || !strings.HasSuffix(lines[len(lines)-1], "-12.00") {
+		t.Errorf("EUR report lines are wrong:\n%s", r)
+	}
+
+	acc, err := lib.FindUserAccount("UserA", "Savings")
+	testErrorNil(t, err, "FindUserAccount")
+	r = lib.NewCategoryReport("Account 'Savings'", []*lib.AccountData{acc}, from, to)
+	if len(r.Currencies) != 1 || len(r.Currencies[0].Totals) != 1 || r.Currencies[0].Debit.String() != "10.00" {
+		t.Errorf("Account report is wrong:\n%s", r)
+	}
+}
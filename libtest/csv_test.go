@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/stuartdd2/JsonParser4go/parser"
 	"stuartdd.com/lib"
 )
 
@@ -55,6 +56,92 @@ func TestParseFileBasic(t *testing.T) {
 	testError(t, err3, "11,6 ")
 }
 
+func TestParseQuoted(t *testing.T) {
+	m, err := lib.ParseFileToMap("testdata_quoted.csvt", false, []string{"date", "payee", "memo", "amount"})
+	testErrorNil(t, err, "ParseFileToMap quoted")
+	if len(m) != 4 {
+		t.Fatalf("Should be 4 rows (blank line skipped) not %d", len(m))
+	}
+	if m[0]["date"] != "Date" {
+		t.Errorf("Byte order mark should be removed. '%s'", m[0]["date"])
+	}
+	if m[1]["payee"] != "Smith, J" || m[1]["memo"] != "He said \"hi\"" || m[1]["amount"] != "-27.56" {
+		t.Errorf("Quoted delimiter and escaped quotes are wrong. %s", m[1])
+	}
+	if m[2]["payee"] != "Multi\nline" || m[2]["memo"] != "" || m[2]["amount"] != "1,234.50" {
+		t.Errorf("Embedded new line is wrong. %s", m[2])
+	}
+	if m[3]["memo"] != "spaced" || m[3]["amount"] != "2.91" {
+		t.Errorf("Row after a multi line value is wrong. %s", m[3])
+	}
+}
+
+func TestParseDelimiters(t *testing.T) {
+	m, err := lib.ParseFileToMapWithDelimiter("testdata_semicolon.csvt", ';', true, []string{"date", "ref", "amount"})
+	testErrorNil(t, err, "ParseFileToMap semicolon")
+	if len(m) != 2 || m[0]["ref"] != "Albert; Heijn" || m[1]["amount"] != "1.234,50" {
+		t.Errorf("Semicolon file is wrong. %s", m)
+	}
+	rows := make([]string, 0)
+	err = lib.ParseCsv(strings.NewReader("a\t\tc\n\"x\ty\"\tz\n"), '\t', func(row, col int, s string) error {
+		rows = append(rows, fmt.Sprintf("%d,%d=%s", row, col, s))
+		return nil
+	})
+	testErrorNil(t, err, "ParseCsv tab")
+	if strings.Join(rows, " ") != "0,0=a 0,1= 0,2=c 1,0=x\ty 1,1=z" {
+		t.Errorf("Tab values are wrong. %s", rows)
+	}
+	for n, d := range map[string]rune{"": ',', "tab": '\t', "\\t": '\t', ";": ';', "|": '|'} {
+		r, err := lib.CsvDelimiter(n)
+		if err != nil || r != d {
+			t.Errorf("Delimiter '%s' should be '%c'", n, d)
+		}
+	}
+	_, err = lib.CsvDelimiter("ab")
+	testError(t, err, "must be a single character")
+	_, err = lib.CsvDelimiter("\"")
+	testError(t, err, "is not valid")
+}
+
+func TestParseErrors(t *testing.T) {
+	call := func(row, col int, s string) error { return nil }
+	err := lib.ParseCsv(strings.NewReader("a,b\nc,\"unterminated\n"), ',', call)
+	testError(t, err, "row 2 column 17")
+	testError(t, err, "missing \"")
+	err = lib.ParseCsv(strings.NewReader("a,b\nc,d\"e\n"), ',', call)
+	testError(t, err, "row 2 column 4")
+	err = lib.ParseCsv(strings.NewReader("a,b\nc,d\n"), ',', func(row, col int, s string) error {
+		if s == "d" {
+			return fmt.Errorf("bad value")
+		}
+		return nil
+	})
+	testError(t, err, "row 2 column 2: bad value")
+	err = lib.ParseFile("testdata_notfound.csvt", call)
+	testError(t, err, "testdata_notfound.csvt")
+}
+
+func TestImportDelimiterProfile(t *testing.T) {
+	p := lib.NewImportProfile("nl")
+	p.Delimiter = ";"
+	p.DateFormat = "02-01-2006"
+	p.Decimal = ","
+	p.Thousands = "."
+	p.Columns = []string{"date", "ref", "amount"}
+	txl := parser.NewJsonList(lib.IdTxTransactions)
+	count, err := lib.ImportCsvData(txl, "testdata_semicolon.csvt", p)
+	testErrorNil(t, err, "ImportCsvData semicolon")
+	if count != 2 {
+		t.Errorf("Should import 2 not %d", count)
+	}
+	td := lib.NewTranactionDataFromNode(txl.GetValues()[1])
+	if td.Value().String() != "1234.50" || td.Ref() != "Salaris" || td.TxType() != lib.TX_TYPE_CRE {
+		t.Errorf("Semicolon transaction is wrong. %s", td)
+	}
+	p.Decimal = ";"
+	testError(t, p.Validate(), "decimal separator and delimiter must be different")
+}
+
 func testError(t *testing.T, err error, txt string) {
 	if err == nil {
 		t.Errorf("err should not be nil for ParseFile")
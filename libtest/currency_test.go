@@ -0,0 +1,143 @@
+package libtest
+
+import (
+	"testing"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+const currencyAccountsJson = `{"groups":{"UserA":{"assets":{
+	"Current":{"transactions":[
+		{"date":"2022-01-01","ref":"Initial","type":"iv","val":100},
+		{"date":"2022-01-02","ref":"TESCO","type":"db","val":20.5}
+	]},
+	"Euro":{"currency":"eur","transactions":[
+		{"date":"2022-01-01","ref":"Initial","type":"iv","val":117},
+		{"date":"2022-01-02","ref":"CAFE","type":"db","val":200}
+	]},
+	"Dollar":{"currency":"USD","transactions":[
+		{"date":"2022-01-01","ref":"Initial","type":"iv","val":254}
+	]},
+	"Franc":{"currency":"CHF","transactions":[
+		{"date":"2022-01-01","ref":"Initial","type":"iv","val":50}
+	]}}}},
+	"exchangeRates":{"GBP":1,"EUR":1.17,"USD":1.27,"XXX":-1},
+	"timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`
+
+func TestFormatMoney(t *testing.T) {
+	for _, tc := range []struct {
+		v        lib.Money
+		currency string
+		expected string
+	}{
+		{1250, "", "    12.50"},
+		{1250, "GBP", "    £12.50"},
+		{-300, " eur", "    -€3.00"},
+		{123457, "USD", "  $1234.57"},
+		{1250, "CHF", "    12.50 CHF"},
+	} {
+		if lib.FormatMoney(tc.v, tc.currency) != tc.expected {
+			t.Errorf("FormatMoney(%s, %s) should be '%s' not '%s'", tc.v, tc.currency, tc.expected, lib.FormatMoney(tc.v, tc.currency))
+		}
+	}
+	testErrorNil(t, lib.ValidateCurrency(" gbp "), "ValidateCurrency")
+	testError(t, lib.ValidateCurrency("GB"), "must be a 3 letter code")
+	testError(t, lib.ValidateCurrency("G1P"), "must be a 3 letter code")
+}
+
+func TestCurrencyAccounts(t *testing.T) {
+	jd, err := lib.NewJsonData([]byte(currencyAccountsJson), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	lib.InitUserAssetsCache(jd)
+	acc, err := lib.FindUserAccount("UserA", "Euro")
+	testErrorNil(t, err, "FindUserAccount")
+	if acc.Currency != "EUR" {
+		t.Fatalf("Currency should be EUR not '%s'", acc.Currency)
+	}
+	tx := acc.Transactions[1]
+	if tx.Val() != "   €200.00" || tx.LineVal() != "   -€83.00" {
+		t.Errorf("Val '%s' and LineVal '%s' should be in EUR", tx.Val(), tx.LineVal())
+	}
+	if tx.Key() != "2022-01-02 CAFE db    200.00" {
+		t.Errorf("Key should not include the currency '%s'", tx.Key())
+	}
+	acc, _ = lib.FindUserAccount("UserA", "Current")
+	if acc.Currency != "" || acc.Transactions[1].Val() != "    20.50" {
+		t.Errorf("Account without a currency should be formatted as a number '%s'", acc.Transactions[1].Val())
+	}
+	accounts, _ := lib.FindAllUserAccounts("UserA")
+	currencies := lib.AccountCurrencies(accounts)
+	if len(currencies) != 4 || currencies[0] != "CHF" || currencies[1] != "EUR" || currencies[2] != "GBP" || currencies[3] != "USD" {
+		t.Errorf("Currencies are wrong %s", currencies)
+	}
+}
+
+func TestExchangeRates(t *testing.T) {
+	jd, err := lib.NewJsonData([]byte(currencyAccountsJson), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	rates := lib.GetExchangeRates(jd.GetDataRoot())
+	if len(rates) != 3 || rates.HasRate("XXX") || !rates.HasRate("eur") {
+		t.Fatalf("Rates are wrong %v", rates)
+	}
+	v, err := rates.Convert(11700, "EUR", "GBP")
+	testErrorNil(t, err, "Convert")
+	if v.String() != "100.00" {
+		t.Errorf("117 EUR should be 100 GBP not %s", v)
+	}
+	v, err = rates.Convert(5000, "CHF", "CHF")
+	testErrorNil(t, err, "Convert")
+	if v.String() != "50.00" {
+		t.Errorf("The same currency should not be converted %s", v)
+	}
+	_, err = rates.Convert(5000, "CHF", "GBP")
+	testError(t, err, "no exchange rate for 'CHF'")
+
+	testError(t, jd.SetExchangeRates(lib.ExchangeRates{"EU": 1}, parser.NewBarPath("")), "must be a 3 letter code")
+	testError(t, jd.SetExchangeRates(lib.ExchangeRates{"EUR": 0}, parser.NewBarPath("")), "must be greater than 0")
+	testErrorNil(t, jd.SetExchangeRates(lib.ExchangeRates{"GBP": 1, "chf": 1.12}, parser.NewBarPath("")), "SetExchangeRates")
+	rates = lib.GetExchangeRates(jd.GetDataRoot())
+	if !rates.Equal(lib.ExchangeRates{"GBP": 1, "CHF": 1.12}) {
+		t.Errorf("Rates were not updated %v", rates)
+	}
+	testErrorNil(t, jd.Undo(), "Undo")
+	if len(lib.GetExchangeRates(jd.GetDataRoot())) != 3 {
+		t.Error("Undo should restore the rates")
+	}
+	testErrorNil(t, jd.SetExchangeRates(lib.ExchangeRates{}, parser.NewBarPath("")), "SetExchangeRates")
+	if jd.GetDataRoot().GetNodeWithName(lib.IdExchangeRates) != nil {
+		t.Error("No rates should remove the exchange rates")
+	}
+}
+
+func TestAssetSummary(t *testing.T) {
+	jd, err := lib.NewJsonData([]byte(currencyAccountsJson), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	lib.InitUserAssetsCache(jd)
+	accounts, _ := lib.FindAllUserAccounts("UserA")
+	sum := lib.NewAssetSummary(accounts, lib.GetExchangeRates(jd.GetDataRoot()), "gbp")
+	if sum.Base != "GBP" || len(sum.Totals) != 4 {
+		t.Fatalf("Summary is wrong %v", sum)
+	}
+	for i, c := range []string{"GBP", "CHF", "EUR", "USD"} {
+		if sum.Totals[i].Currency != c {
+			t.Errorf("Total %d should be %s not %s", i, c, sum.Totals[i].Currency)
+		}
+	}
+	if sum.Totals[0].Total.String() != "79.50" || sum.Totals[2].Total.String() != "-83.00" || sum.Totals[3].Total.String() != "254.00" {
+		t.Errorf("Totals are wrong %s %s %s", sum.Totals[0].Total, sum.Totals[2].Total, sum.Totals[3].Total)
+	}
+	if sum.Totals[1].HasRate || len(sum.Missing) != 1 || sum.Missing[0] != "CHF" {
+		t.Errorf("CHF should not have a rate %v", sum.Missing)
+	}
+	if lib.FormatMoney(sum.GrandTotal, sum.Base) != "   £208.56" {
+		t.Errorf("Grand total should be £208.56 not %s", lib.FormatMoney(sum.GrandTotal, sum.Base))
+	}
+	sum = lib.NewAssetSummary(accounts, lib.GetExchangeRates(jd.GetDataRoot()), "USD")
+	if len(sum.Totals) != 4 || sum.Totals[0].Currency != "USD" || sum.Totals[0].Total.String() != "254.00" {
+		t.Fatalf("The accounts without a currency should stay in %s when the base is changed %v", lib.DefaultBaseCurrency, sum.Totals)
+	}
+	if sum.Totals[3].Currency != "GBP" || sum.Totals[3].Total.String() != "79.50" || sum.Totals[3].Converted.String() != "100.97" {
+		t.Errorf("GBP should be converted to USD %s", sum.Totals[3].Converted)
+	}
+}
@@ -0,0 +1,73 @@
+package libtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+func exportAccount(t *testing.T) *lib.AccountData {
+	jd, err := lib.NewJsonData([]byte(qifAccountJson), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	lib.InitUserAssetsCache(jd)
+	acc, err := lib.FindUserAccount("UserA", "Current")
+	testErrorNil(t, err, "FindUserAccount")
+	return acc
+}
+
+func TestExportCsv(t *testing.T) {
+	acc := exportAccount(t)
+	var buf bytes.Buffer
+	testErrorNil(t, lib.ExportTransactions(&buf, acc, lib.ExportFormatCsv, ""), "ExportTransactions csv")
+	expected := "Date,Reference,Type,In,Out,Balance,Category\n" +
+		"2022-03-01,Initial,iv,1000.00,,1000.00,\n" +
+		"2022-03-11,BT PENSION A/C,cr,1473.41,,2473.41,Pension\n" +
+		"2022-03-10 12:30:00,BT GROUP PLC,db,,36.56,2436.85,\n" +
+		"2022-03-09,\"TESCO STORE\n3144\",db,,27.56,2409.29,\n"
+	if buf.String() != expected {
+		t.Errorf("CSV export is wrong:\n%s", buf.String())
+	}
+
+	// The filter is applied but the initial value is always exported
+	buf.Reset()
+	testErrorNil(t, lib.ExportTransactions(&buf, acc, lib.ExportFormatCsv, "BT"), "ExportTransactions csv filter")
+	expected = "Date,Reference,Type,In,Out,Balance,Category\n" +
+		"2022-03-01,Initial,iv,1000.00,,1000.00,\n" +
+		"2022-03-11,BT PENSION A/C,cr,1473.41,,2473.41,Pension\n" +
+		"2022-03-10 12:30:00,BT GROUP PLC,db,,36.56,2436.85,\n"
+	if buf.String() != expected {
+		t.Errorf("Filtered CSV export is wrong:\n%s", buf.String())
+	}
+}
+
+func TestExportJson(t *testing.T) {
+	acc := exportAccount(t)
+	var buf bytes.Buffer
+	testErrorNil(t, lib.ExportTransactions(&buf, acc, lib.ExportFormatJson, "2436.85"), "ExportTransactions json")
+	var m map[string][]map[string]interface{}
+	testErrorNil(t, json.Unmarshal(buf.Bytes(), &m), "Unmarshal")
+	txs := m[lib.IdTxTransactions]
+	if len(txs) != 2 {
+		t.Fatalf("Should export 2 transactions not %d", len(txs))
+	}
+	tx := txs[1]
+	if tx["date"] != "2022-03-10 12:30:00" || tx["ref"] != "BT GROUP PLC" || tx["type"] != "db" || tx["val"] != 36.56 || tx["balance"] != 2436.85 {
+		t.Errorf("JSON export is wrong. %s", tx)
+	}
+	testError(t, lib.ExportTransactions(&buf, acc, "xml", ""), "export format 'xml' is not valid")
+}
+
+func TestExportFormatForFile(t *testing.T) {
+	for f, e := range map[string]string{"a/b.CSV": lib.ExportFormatCsv, "b.json": lib.ExportFormatJson, "c.qif": lib.ExportFormatQif} {
+		format, err := lib.ExportFormatForFile(f)
+		testErrorNil(t, err, f)
+		if format != e {
+			t.Errorf("Format for %s should be %s not %s", f, e, format)
+		}
+	}
+	_, err := lib.ExportFormatForFile("a/b.txt")
+	testError(t, err, "cannot export to file 'b.txt'")
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"testing"
 
 	"stuartdd.com/lib"
@@ -18,6 +19,7 @@ var (
 	content5        = []byte("\n  F9")
 	password        = []byte("mysecretpassword")
 	testFileName    = "TempTestData.json"
+	legacyFileName  = "TestDataLegacyEnc.data"
 	storeCalledBack = false
 )
 
@@ -194,6 +196,150 @@ func TestEnc(t *testing.T) {
 	}
 }
 
+func TestEncHeader(t *testing.T) {
+	resetTestFile(testFileName, content1)
+	fd1, _ := lib.NewFileData(testFileName, nil, "", "")
+	if fd1.GetEncVersion() != 0 {
+		t.Errorf("Un-encrypted file should be version 0 not %d", fd1.GetEncVersion())
+	}
+	fd1.StoreContentEncrypted(password, storeCallMeBack)
+	enc1, _ := ioutil.ReadFile(testFileName)
+	if !strings.HasPrefix(string(enc1), "ENCTEST:1:") {
+		t.Errorf("Encrypted file should have a version 1 header:'%s'", string(enc1))
+	}
+	fd1.StoreContentEncrypted(password, storeCallMeBack)
+	enc2, _ := ioutil.ReadFile(testFileName)
+	if strings.Split(string(enc1), ":")[2] == strings.Split(string(enc2), ":")[2] {
+		t.Error("Each save should use a new random salt")
+	}
+	fd2, _ := lib.NewFileData(testFileName, nil, "", "")
+	if fd2.GetEncVersion() != 1 {
+		t.Errorf("Encrypted file should be version 1 not %d", fd2.GetEncVersion())
+	}
+	err := fd2.DecryptContents(password)
+	if err != nil {
+		t.Errorf("Failed to decrypt. %s", err)
+	}
+	if string(fd2.GetContent()) != string(content1) {
+		t.Error("Did not decrypt correctly!")
+	}
+	fd3, _ := lib.NewFileData(testFileName, nil, "", "")
+	err = fd3.DecryptContents([]byte("wrongpassword"))
+	if err == nil {
+		t.Error("Should not decrypt with the wrong password")
+	}
+	resetTestFile(testFileName, []byte(strings.Replace(string(enc2), "ENCTEST:1:", "ENCTEST:99:", 1)))
+	fd4, _ := lib.NewFileData(testFileName, nil, "", "")
+	err = fd4.DecryptContents(password)
+	testError(t, err, "version 99 is not supported")
+	resetTestFile(testFileName, []byte("ENCTEST:1:abc:65536:8:1"))
+	fd5, _ := lib.NewFileData(testFileName, nil, "", "")
+	err = fd5.DecryptContents(password)
+	testError(t, err, "not terminated")
+	for hdr, msg := range map[string]string{"65536:1024:1": "r value 1024", "65536:8:1000": "p value 1000", "4194304:8:1": "N value 4194304", "1048576:8:1": "memory 128 * N * r", "262144:8:16": "N * r * p"} {
+		resetTestFile(testFileName, []byte("ENCTEST:1:YWJj:"+hdr+"\nxyz"))
+		fd6, _ := lib.NewFileData(testFileName, nil, "", "")
+		testError(t, fd6.DecryptContents(password), msg)
+	}
+	resetTestFile(testFileName, content1)
+}
+
+func TestKeyMatches(t *testing.T) {
+	resetTestFile(testFileName, content1)
+	fd1, _ := lib.NewFileData(testFileName, nil, "", "")
+	if fd1.KeyMatches(password) {
+		t.Error("Un-encrypted data should not match any key")
+	}
+	fd1.StoreContentEncrypted(password, storeCallMeBack)
+	if !fd1.KeyMatches(password) {
+		t.Error("Key should match the one used to store")
+	}
+	if fd1.KeyMatches([]byte("mysecretpasswor")) {
+		t.Error("Key should not match a different key")
+	}
+	fd2, _ := lib.NewFileData(testFileName, nil, "", "")
+	fd2.DecryptContents(password)
+	if !fd2.KeyMatches(password) {
+		t.Error("Key should match the one used to decrypt")
+	}
+	resetTestFile(testFileName, content1)
+}
+
+func TestLockUnlock(t *testing.T) {
+	resetTestFile(testFileName, content1)
+	fd1, _ := lib.NewFileData(testFileName, nil, "", "")
+	testError(t, fd1.Lock([]byte("model")), "has no key")
+	key := append([]byte{}, password...)
+	fd1.StoreContentEncrypted(key, storeCallMeBack)
+	err := fd1.Lock([]byte("model"))
+	testErrorNil(t, err, "Lock")
+	if string(key) != string(password) {
+		t.Error("Lock should clear its own copy of the key not the caller's")
+	}
+	if !fd1.IsLocked() {
+		t.Error("Data should be locked")
+	}
+	if fd1.HasEncData() || len(fd1.GetContent()) > 0 {
+		t.Error("Key and content should be cleared when locked")
+	}
+	testError(t, fd1.Lock([]byte("model")), "already locked")
+	testError(t, fd1.StoreContentAsIs(storeCallMeBack), "locked")
+	_, err = fd1.Unlock([]byte("mysecretpasswor"))
+	testError(t, err, "password is incorrect")
+	if !fd1.IsLocked() {
+		t.Error("Data should remain locked after a bad password")
+	}
+	model, err := fd1.Unlock(password)
+	testErrorNil(t, err, "Unlock")
+	if string(model) != "model" {
+		t.Errorf("Model should be returned by Unlock. Got '%s'", model)
+	}
+	if fd1.IsLocked() || !fd1.KeyMatches(password) || string(fd1.GetContent()) != string(content1) {
+		t.Error("Key and content should be restored by Unlock")
+	}
+	_, err = fd1.Unlock(password)
+	testError(t, err, "not locked")
+	resetTestFile(testFileName, content1)
+}
+
+func TestEncLegacyUpgrade(t *testing.T) {
+	legacy, err := ioutil.ReadFile(legacyFileName)
+	if err != nil {
+		t.Fatalf("Failed to read legacy file. %s", err)
+	}
+	resetTestFile(testFileName, legacy)
+	fd1, _ := lib.NewFileData(testFileName, nil, "", "")
+	if !fd1.RequiresDecryption() {
+		t.Error("Legacy file should require decryption")
+	}
+	err = fd1.DecryptContents(password)
+	if err != nil {
+		t.Fatalf("Failed to decrypt legacy file. %s", err)
+	}
+	if string(fd1.GetContent()) != string(content1) {
+		t.Error("Legacy file did not decrypt correctly!")
+	}
+	if fd1.GetEncVersion() != 0 {
+		t.Errorf("Legacy file should be version 0 not %d", fd1.GetEncVersion())
+	}
+	fd1.StoreContentAsIs(storeCallMeBack)
+	if fd1.GetEncVersion() != 1 {
+		t.Errorf("Saved legacy file should be upgraded to version 1 not %d", fd1.GetEncVersion())
+	}
+	fd2, _ := lib.NewFileData(testFileName, nil, "", "")
+	if fd2.GetEncVersion() != 1 {
+		t.Errorf("Reloaded file should be version 1 not %d", fd2.GetEncVersion())
+	}
+	err = fd2.DecryptContents(password)
+	if err != nil {
+		t.Fatalf("Failed to decrypt upgraded file. %s", err)
+	}
+	if string(fd2.GetContent()) != string(content1) {
+		t.Error("Upgraded file did not decrypt correctly!")
+	}
+	resetTestFile(testFileName, content1)
+}
+
 func TestJsonRec(t *testing.T) {
 	resetTestFile(testFileName, content3)
 	fd3, err3 := lib.NewFileData(testFileName, nil, "", "")
@@ -306,6 +452,47 @@ func TestStoreContent(t *testing.T) {
 
 }
 
+func TestStoreVerified(t *testing.T) {
+	vault := []byte(`{"groups":{"UserA":{"pwHints":{"Hint":{"notes":"n"}}}},"timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`)
+	testErrorNil(t, lib.VerifyJsonData(vault), "VerifyJsonData")
+	testError(t, lib.VerifyJsonData(content1), "root 'groups' element is missing")
+
+	resetTestFile(testFileName, content1)
+	fd1, _ := lib.NewFileData(testFileName, nil, "", "")
+	fd1.SetVerifier(lib.VerifyJsonData)
+	fd1.SetContent(content2)
+	storeCalledBack = false
+	testError(t, fd1.StoreContentEncrypted(password, storeCallMeBack), "saved data could not be read")
+	if storeCalledBack || fd1.HasEncData() {
+		t.Error("A failed store should not call back or change the key")
+	}
+	dat, _ := ioutil.ReadFile(testFileName)
+	if string(dat) != string(content1) {
+		t.Errorf("A failed store should not change the file:'%s'", dat)
+	}
+	list, _ := ioutil.ReadDir(".")
+	for _, f := range list {
+		if strings.HasSuffix(f.Name(), ".tmp") {
+			t.Errorf("Temp file '%s' should be removed", f.Name())
+		}
+	}
+
+	fd1.SetContent(vault)
+	testErrorNil(t, fd1.StoreContentEncrypted(password, storeCallMeBack), "StoreContentEncrypted")
+	fd2, _ := lib.NewFileData(testFileName, nil, "", "")
+	testErrorNil(t, fd2.DecryptContents(password), "DecryptContents")
+	if string(fd2.GetContent()) != string(vault) {
+		t.Error("Verified store did not save the content")
+	}
+	fd2.SetVerifier(lib.VerifyJsonData)
+	testErrorNil(t, fd2.StoreContentUnEncrypted(storeCallMeBack), "StoreContentUnEncrypted")
+	dat, _ = ioutil.ReadFile(testFileName)
+	if string(dat) != string(vault) {
+		t.Error("Verified un-encrypted store did not save the content")
+	}
+	resetTestFile(testFileName, content1)
+}
+
 func resetTestFile(fileName string, content []byte) {
 	err := ioutil.WriteFile(fileName, content, 0644)
 	if err != nil {
@@ -0,0 +1,68 @@
+package libtest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+func TestHistory(t *testing.T) {
+	defer lib.SetHistoryMax(10)
+	jd := dataLoad(t, "TestDataTypesGold.json")
+	p := parser.NewBarPath("UserA|pwHints|MyApp|pre")
+	if _, ok := jd.LastChanged(p); ok {
+		t.Error("Should be no history before a change")
+	}
+	lib.SetHistoryMax(3)
+	for _, v := range []string{"v1", "v2", "v3", "v4"} {
+		testErrorNil(t, jd.SetLeafValue(p, v), "SetLeafValue")
+	}
+	testErrorNil(t, jd.SetLeafValue(p, "v4"), "SetLeafValue unchanged")
+	h := jd.GetHistory(p)
+	if len(h) != 3 {
+		t.Fatalf("History should be capped at 3 entries not %d", len(h))
+	}
+	if h[0].Value != "v1" || h[2].Value != "v3" {
+		t.Errorf("History should contain the previous values v1..v3 oldest first. %s", h)
+	}
+	lc, ok := jd.LastChanged(p)
+	if !ok || time.Since(lc) > time.Minute {
+		t.Errorf("Last changed should be now. %s", lc)
+	}
+	if !strings.Contains(jd.ToJson(), "\"history\"") {
+		t.Error("History should be saved with the data")
+	}
+	// History must survive a save and load
+	jd2, err := lib.NewJsonData([]byte(jd.ToJson()), updateMap)
+	testErrorNil(t, err, "NewJsonData")
+	if len(jd2.GetHistory(p)) != 3 {
+		t.Error("History should be re-loaded")
+	}
+	if len(jd2.GetNavIndex("")) != len(jd.GetNavIndex("")) {
+		t.Error("History should not be in the navigation index")
+	}
+
+	testErrorNil(t, jd.Rename(parser.NewBarPath("UserA|pwHints|MyApp"), "MyApp2"), "Rename")
+	if len(jd.GetHistory(p)) != 0 || len(jd.GetHistory(parser.NewBarPath("UserA|pwHints|MyApp2|pre"))) != 3 {
+		t.Error("History should move with a renamed hint")
+	}
+	testErrorNil(t, jd.Rename(parser.NewBarPath("UserA"), "UserX"), "Rename user")
+	p = parser.NewBarPath("UserX|pwHints|MyApp2|pre")
+	if len(jd.GetHistory(p)) != 3 {
+		t.Error("History should move with a renamed user")
+	}
+	testErrorNil(t, jd.Remove(p, 1), "Remove")
+	if len(jd.GetHistory(p)) != 0 || strings.Contains(jd.ToJson(), "v1") {
+		t.Error("History should be removed with the item")
+	}
+
+	lib.SetHistoryMax(0)
+	p = parser.NewBarPath("UserX|pwHints|MyApp2|post")
+	testErrorNil(t, jd.SetLeafValue(p, "new"), "SetLeafValue no history")
+	if len(jd.GetHistory(p)) != 0 {
+		t.Error("No history should be kept when the max is 0")
+	}
+}
@@ -0,0 +1,95 @@
+package libtest
+
+import (
+	"testing"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+func txNode(date, ref, typ string, val float64) *parser.JsonObject {
+	tn := parser.NewJsonObject("")
+	tn.Add(parser.NewJsonString(lib.IdTxDate, date))
+	tn.Add(parser.NewJsonString(lib.IdTxRef, ref))
+	tn.Add(parser.NewJsonString(lib.IdTxType, typ))
+	tn.Add(parser.NewJsonNumber(lib.IdTxVal, val))
+	return tn
+}
+
+func TestPreviewImport(t *testing.T) {
+	txl := parser.NewJsonList(lib.IdTxTransactions)
+	txl.Add(txNode("2022-03-01", "Initial", "iv", 100))
+	txl.Add(txNode("2022-03-09", "TESCO STORE 3144", "db", 27.56))
+	txl.Add(txNode("2022-03-10", "BT GROUP PLC", "db", 36.56))
+	withFitId := txNode("2022-03-11", "Pension", "cr", 10)
+	withFitId.Add(parser.NewJsonString(lib.IdTxFitId, "F1"))
+	txl.Add(withFitId)
+
+	fitIdIn := txNode("2022-03-12", "Pension changed", "cr", 11)
+	fitIdIn.Add(parser.NewJsonString(lib.IdTxFitId, "F1"))
+	nodes := []*parser.JsonObject{
+		txNode("2022-03-09", "TESCO STORE 3144", "db", 27.56),        // Duplicate
+		txNode("2022-03-10 09:15:00", "B.T. Group plc", "db", 36.56), // Probable (same day, similar ref)
+		txNode("2022-03-10", "BT GROUP PLC", "cr", 36.56),            // New (different type)
+		txNode("2022-03-10", "GAS BOARD", "db", 36.56),               // New (different ref)
+		txNode("2022-03-13", "NEW ROW", "db", 1),                     // New
+		txNode("2022-03-13", "NEW ROW", "db", 1),                     // Duplicate in the file
+		txNode("2022-03-01", "Opening", "iv", 200),                   // Duplicate. Account has an iv
+		fitIdIn, // Duplicate FITID
+	}
+	c := lib.PreviewImport(txl, nodes)
+	expected := []lib.ImportMatchEnum{lib.IMPORT_DUPLICATE, lib.IMPORT_PROBABLE, lib.IMPORT_NEW, lib.IMPORT_NEW, lib.IMPORT_NEW, lib.IMPORT_DUPLICATE, lib.IMPORT_DUPLICATE, lib.IMPORT_DUPLICATE}
+	for i, e := range expected {
+		if c[i].Match != e {
+			t.Errorf("Row %d should be %s. %s", i, lib.ImportMatchNames[e], c[i])
+		}
+		if c[i].Accept != (e == lib.IMPORT_NEW) {
+			t.Errorf("Row %d only new rows should be accepted. %s", i, c[i])
+		}
+	}
+	if c[1].MatchedWith.Ref() != "BT GROUP PLC" || c[5].MatchedWith.Ref() != "NEW ROW" || c[7].MatchedWith.Ref() != "Pension" {
+		t.Error("MatchedWith is wrong")
+	}
+	if len(txl.GetValues()) != 4 {
+		t.Error("Preview should not change the transactions")
+	}
+
+	c[1].Accept = true
+	count, err := lib.ApplyImport(txl, c)
+	testErrorNil(t, err, "ApplyImport")
+	if count != 4 || len(txl.GetValues()) != 8 {
+		t.Errorf("Should import 4 not %d", count)
+	}
+	for _, v := range c {
+		v.Accept = false
+	}
+	count, err = lib.ApplyImport(txl, c)
+	testErrorNil(t, err, "ApplyImport none accepted")
+	if count != 0 || len(txl.GetValues()) != 8 {
+		t.Errorf("Should import nothing not %d", count)
+	}
+	if n := lib.CountSkippedDuplicates(c); n != 5 {
+		t.Errorf("Should skip 5 duplicates not %d", n)
+	}
+}
+
+func TestRefSimilarity(t *testing.T) {
+	for _, s := range []struct {
+		a, b    string
+		similar bool
+	}{
+		{"TESCO STORE 3144", "tesco store 3144", true},
+		{"TESCO STORE 3144", "TESCO STORES 3144", true},
+		{"BT GROUP PLC", "B.T. Group plc", true},
+		{"AMAZON", "AMAZON MKTPLACE UK", true},
+		{"BT GROUP PLC", "GAS BOARD", false},
+		{"ABC", "XYZ", false},
+		{"", "", true},
+		{"", "X", false},
+	} {
+		r := lib.RefSimilarity(s.a, s.b)
+		if (r >= 0.6) != s.similar {
+			t.Errorf("'%s' and '%s' similar should be %t. %f", s.a, s.b, s.similar, r)
+		}
+	}
+}
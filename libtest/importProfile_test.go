@@ -0,0 +1,135 @@
+package libtest
+
+import (
+	"testing"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+func TestImportProfileAmount(t *testing.T) {
+	p := lib.NewImportProfile("test")
+	testAmount(t, p, "27.56", 27.56)
+	testAmount(t, p, " -27.56 ", -27.56)
+	testAmount(t, p, "27.56-", -27.56)
+	testAmount(t, p, "(27.56)", -27.56)
+	testAmount(t, p, "+3", 3)
+	testAmountErr(t, p, "1e3")
+	testAmountErr(t, p, "£12.00")
+	testAmountErr(t, p, "")
+	testAmountErr(t, p, "1,234.50")
+	p.Thousands = ","
+	testAmount(t, p, "1,234.50", 1234.5)
+	p.Decimal = ","
+	p.Thousands = "."
+	testAmount(t, p, "1.234,50", 1234.5)
+	testAmount(t, p, "-0,01", -0.01)
+	p.Thousands = ""
+	testAmount(t, p, "1234,5", 1234.5)
+}
+
+func TestImportProfileValidate(t *testing.T) {
+	p := lib.NewImportProfile("test")
+	testErrorNil(t, p.Validate(), "Default profile should be valid")
+	p.Thousands = "."
+	testError(t, p.Validate(), "must be different")
+	p = lib.NewImportProfile("test")
+	p.Columns = []string{"date", "ref"}
+	testError(t, p.Validate(), "requires an 'amount' column")
+	p.Columns = []string{"ref", "amount"}
+	testError(t, p.Validate(), "does not have a 'date' column")
+	p = lib.NewImportProfile("test")
+	p.DateFormat = " "
+	testError(t, p.Validate(), "does not define a date format")
+}
+
+func TestImportProfileRowRefAndAmount(t *testing.T) {
+	p := lib.NewImportProfile("test")
+	if p.RowRef(map[string]string{"ref": "TESCO", "type": "DEB"}) != "TESCO [DEB]" {
+		t.Error("Default ref should be 'ref [type]'")
+	}
+	if p.RowRef(map[string]string{"ref": "TESCO", "type": " "}) != "TESCO" {
+		t.Error("Empty type should be removed from the ref")
+	}
+	p.Columns = []string{"date", "ref", "memo", "amount"}
+	p.RefFormat = "{ref} ({memo}) {undefined}"
+	if r := p.RowRef(map[string]string{"ref": "BT", "memo": "Phone"}); r != "BT (Phone)" {
+		t.Errorf("Ref should combine columns. '%s'", r)
+	}
+	tx, v, err := p.RowAmount(map[string]string{"amount": "-12.50"})
+	if err != nil || tx != lib.TX_TYPE_DEB || v.String() != "12.50" {
+		t.Errorf("Negative amount should be a debit of 12.50. %s %s %v", tx, v, err)
+	}
+	p.InvertAmount = true
+	tx, v, _ = p.RowAmount(map[string]string{"amount": "-12.50"})
+	if tx != lib.TX_TYPE_CRE || v.String() != "12.50" {
+		t.Errorf("Inverted negative amount should be a credit. %s %s", tx, v)
+	}
+	p = lib.NewImportProfile("test")
+	tx, v, _ = p.RowAmount(map[string]string{"db": "3.00", "cr": ""})
+	if tx != lib.TX_TYPE_DEB || v.String() != "3.00" {
+		t.Errorf("db column should be a debit. %s %s", tx, v)
+	}
+	tx, _, _ = p.RowAmount(map[string]string{})
+	if tx != lib.TX_TYPE_ERR {
+		t.Errorf("No amount should be an error type. %s", tx)
+	}
+}
+
+func TestImportCsvDataWithProfile(t *testing.T) {
+	txl := parser.NewJsonList(lib.IdTxTransactions)
+	count, err := lib.ImportCsvData(txl, "testdata.csvt", lib.NewImportProfile("default"))
+	testErrorNil(t, err, "ImportCsvData default")
+	if count != 11 {
+		t.Errorf("Should import 11 transactions not %d", count)
+	}
+	count, err = lib.ImportCsvData(txl, "testdata.csvt", lib.NewImportProfile("default"))
+	testErrorNil(t, err, "ImportCsvData all duplicates")
+	if count != 0 {
+		t.Errorf("Duplicates should not be imported. Imported %d", count)
+	}
+
+	p := lib.NewImportProfile("default")
+	p.DateFormat = "2006-01-02"
+	_, err = lib.ImportCsvData(parser.NewJsonList(lib.IdTxTransactions), "testdata.csvt", p)
+	testError(t, err, "does not match the format '2006-01-02'")
+
+	p.Columns = []string{"date", "ref", "memo", "amount"}
+	p.RefFormat = "{ref} ({memo})"
+	txl = parser.NewJsonList(lib.IdTxTransactions)
+	count, err = lib.ImportCsvData(txl, "testdata_amount.csvt", p)
+	testErrorNil(t, err, "ImportCsvData amount")
+	if count != 4 {
+		t.Errorf("Should import 4 transactions not %d", count)
+	}
+	td := lib.NewTranactionDataFromNode(txl.GetValues()[0])
+	if td.DateTime() != "2022-03-09" || td.Ref() != "TESCO STORE 3144 (Groceries)" || td.TxType() != lib.TX_TYPE_DEB || td.Value().String() != "27.56" {
+		t.Errorf("First transaction is wrong. %s %s", td, td.TxType())
+	}
+	td = lib.NewTranactionDataFromNode(txl.GetValues()[1])
+	if td.Ref() != "BT GROUP PLC" {
+		t.Errorf("Empty memo should be removed. '%s'", td.Ref())
+	}
+	td = lib.NewTranactionDataFromNode(txl.GetValues()[3])
+	if td.TxType() != lib.TX_TYPE_CRE || td.Value().String() != "1473.41" {
+		t.Errorf("Last transaction should be a credit. %s", td)
+	}
+}
+
+func testAmount(t *testing.T, p *lib.ImportProfile, s string, expected float64) {
+	v, err := p.ParseAmount(s)
+	if err != nil {
+		t.Errorf("Amount '%s' should parse. %s", s, err.Error())
+		return
+	}
+	if v != lib.MoneyFromFloat(expected) {
+		t.Errorf("Amount '%s' should be %f not %s", s, expected, v)
+	}
+}
+
+func testAmountErr(t *testing.T, p *lib.ImportProfile, s string) {
+	_, err := p.ParseAmount(s)
+	if err == nil {
+		t.Errorf("Amount '%s' should not parse", s)
+	}
+}
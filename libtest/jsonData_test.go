@@ -62,6 +62,27 @@ func TestJsonDataRemove(t *testing.T) {
 		t.Errorf("Should have thrown an err")
 	}
 }
+func TestJsonDataSetLeafValue(t *testing.T) {
+	jd := dataLoad(t, "TestDataTypesGold.json")
+	p := parser.NewBarPath("UserA|pwHints|MyApp|userId")
+	err := jd.SetLeafValue(p, "newuser")
+	if err != nil {
+		t.Errorf("Should not have thrown an err. %s", err.Error())
+	}
+	n, _ := jd.FindNodeForUserDataPath(p)
+	if n.String() != "newuser" {
+		t.Errorf("Value should be 'newuser' not '%s'", n.String())
+	}
+	err = jd.SetLeafValue(parser.NewBarPath("UserA|pwHints|MyApp|notThere"), "x")
+	if err == nil {
+		t.Errorf("Should have thrown an err for missing item")
+	}
+	err = jd.SetLeafValue(parser.NewBarPath("UserA|pwHints|MyApp"), "x")
+	if err == nil {
+		t.Errorf("Should have thrown an err for container item")
+	}
+}
+
 func TestJsonDataLoad(t *testing.T) {
 	jd := dataLoad(t, "TestDataTypesGold.json")
 	testNavIndex(t, jd, "", "[Stuart UserA UserB]")
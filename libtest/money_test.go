@@ -0,0 +1,97 @@
+package libtest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+func TestParseMoney(t *testing.T) {
+	for s, expected := range map[string]string{
+		"12":         "12.00",
+		" -12.5 ":    "-12.50",
+		"+0.05":      "0.05",
+		".5":         "0.50",
+		"7.":         "7.00",
+		"27.5600":    "27.56",
+		"-0.01":      "-0.01",
+		"1473.41":    "1473.41",
+		"9999999.99": "9999999.99",
+	} {
+		m, err := lib.ParseMoney(s)
+		if err != nil {
+			t.Errorf("'%s' should parse. %s", s, err.Error())
+			continue
+		}
+		if m.String() != expected {
+			t.Errorf("'%s' should be '%s' not '%s'", s, expected, m)
+		}
+	}
+	for s, expected := range map[string]string{
+		"1e3":              "is not a valid amount",
+		"1,000":            "is not a valid amount",
+		"12.345":           "more than 2 decimal places",
+		"":                 "is not a number",
+		"-":                "is not a number",
+		".":                "is not a number",
+		"1.2.3":            "is not a valid amount",
+		"NaN":              "is not a valid amount",
+		"--1":              "is not a valid amount",
+		"12345678901234.5": "is too large",
+	} {
+		_, err := lib.ParseMoney(s)
+		testError(t, err, expected)
+	}
+}
+
+func TestMoneyJsonRoundTrip(t *testing.T) {
+	for _, s := range []string{"0.01", "0.1", "0.29", "1.15", "27.56", "1473.41", "-83.00", "12345678.91", "9999999999999.99"} {
+		m, err := lib.ParseMoney(s)
+		testErrorNil(t, err, s)
+		jn := parser.NewJsonNumber(lib.IdTxVal, m.Float64())
+		j, err := parser.Parse([]byte(fmt.Sprintf("{%s}", jn.JsonValue())))
+		testErrorNil(t, err, "Parse")
+		back := lib.MoneyFromFloat(j.(parser.NodeC).GetNodeWithName(lib.IdTxVal).(*parser.JsonNumber).GetValue())
+		if back != m {
+			t.Errorf("'%s' was written as '%s' and read back as '%s'", s, jn.JsonValue(), back)
+		}
+	}
+}
+
+func TestBalanceIsExact(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString(`{"groups":{"UserA":{"assets":{"Current":{"transactions":[{"date":"2022-01-01","ref":"Initial","type":"iv","val":0.3}`)
+	for i := 0; i < 1000; i++ {
+		sb.WriteString(fmt.Sprintf(`,{"date":"2022-01-02 10:%02d:%02d","ref":"R%d","type":"cr","val":0.1}`, i/60, i%60, i))
+		sb.WriteString(fmt.Sprintf(`,{"date":"2022-01-03 10:%02d:%02d","ref":"D%d","type":"db","val":0.07}`, i/60, i%60, i))
+	}
+	sb.WriteString(`]}}}}, "timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`)
+	jd, err := lib.NewJsonData([]byte(sb.String()), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	lib.InitUserAssetsCache(jd)
+	acc, err := lib.FindUserAccount("UserA", "Current")
+	testErrorNil(t, err, "FindUserAccount")
+
+	// float64: 0.3 + 1000 * 0.1 - 1000 * 0.07 is not 30.3
+	f := 0.3
+	for i := 0; i < 1000; i++ {
+		f = f + 0.1 - 0.07
+	}
+	if f == 30.3 {
+		t.Fatal("float64 should not be exact for this test to be valid")
+	}
+	if acc.ClosingValue.String() != "30.30" || acc.ClosingValue != lib.Money(3030) {
+		t.Errorf("Closing value should be exactly 30.30 not %s (%d)", acc.ClosingValue, acc.ClosingValue)
+	}
+	if len(acc.Transactions) != 2001 || acc.Transactions[1000].LineValue() != lib.Money(-6970) {
+		t.Errorf("Line value after the debits should be exactly -69.70 not %s", acc.Transactions[1000].LineValue())
+	}
+	r := lib.NewCategoryReport("UserA", []*lib.AccountData{acc}, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC))
+	if len(r.Currencies) != 1 || r.Currencies[0].Credit.String() != "100.00" || r.Currencies[0].Debit.String() != "70.00" {
+		t.Errorf("Report totals should be exact:\n%s", r)
+	}
+}
@@ -0,0 +1,132 @@
+package libtest
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+func TestParseOfxSgml(t *testing.T) {
+	txs, err := lib.ParseOfxFile("testdata.ofx")
+	testErrorNil(t, err, "ParseOfxFile")
+	if len(txs) != 3 {
+		t.Fatalf("Should parse 3 transactions not %d", len(txs))
+	}
+	if txs[0].FitId != "202203090001" || txs[0].Amount.String() != "-27.56" || txs[0].Ref() != "TESCO STORE 3144" || lib.FormatDateTime(txs[0].Date) != "2022-03-09" {
+		t.Errorf("Transaction 1 is wrong. %s", txs[0])
+	}
+	if txs[1].Ref() != "BT GROUP PLC [REF 9911]" {
+		t.Errorf("Memo should be added to the ref. '%s'", txs[1].Ref())
+	}
+	if txs[2].Name != "M&S PENSION" || txs[2].Amount.String() != "1473.41" || lib.FormatDateTime(txs[2].Date) != "2022-03-11 12:00:00" {
+		t.Errorf("Transaction 3 is wrong. %s", txs[2])
+	}
+}
+
+func TestParseOfxWindows1252(t *testing.T) {
+	txs, err := lib.ParseOfxFile("testdata1252.ofx")
+	testErrorNil(t, err, "ParseOfxFile")
+	if len(txs) != 2 {
+		t.Fatalf("Should parse 2 transactions not %d", len(txs))
+	}
+	if txs[0].Name != "CAFÉ DÉJÀ VU" || txs[0].TrnType != "POS" || !strings.HasSuffix(txs[0].Memo, "garçon €5") {
+		t.Errorf("Transaction 1 is wrong. %s", txs[0])
+	}
+	if txs[1].Name != "SOCIÉTÉ GÉNÉRALE" || txs[1].TrnType != "DIRECTDEBIT" || txs[1].FitId != "202203100001" || txs[1].Amount.String() != "-36.56" {
+		t.Errorf("Transaction 2 is wrong. %s", txs[1])
+	}
+}
+
+func TestParseOfxXml(t *testing.T) {
+	txs, err := lib.ParseOfxFile("testdata.qfx")
+	testErrorNil(t, err, "ParseOfxFile")
+	if len(txs) != 2 {
+		t.Fatalf("Should parse 2 transactions not %d", len(txs))
+	}
+	if txs[0].Name != "AMAZON <UK>" || txs[0].Amount.String() != "-12.00" || txs[0].TrnType != "DEBIT" || lib.FormatDateTime(txs[0].Date) != "2022-03-12 09:30:00" {
+		t.Errorf("Transaction 1 is wrong. %s", txs[0])
+	}
+	if txs[1].FitId != "" || txs[1].Ref() != "PAYMENT [THANK YOU]" {
+		t.Errorf("Transaction 2 is wrong. %s", txs[1])
+	}
+}
+
+func TestParseOfxErrors(t *testing.T) {
+	_, err := lib.ParseOfx("<STMTTRN><DTPOSTED>20220101<TRNAMT>1.00</STMTTRN>")
+	testError(t, err, "is not an OFX file")
+	_, err = lib.ParseOfx("<OFX><STMTTRN><DTPOSTED>20220101<TRNAMT>1.00")
+	testError(t, err, "transaction 1 is not closed")
+	_, err = lib.ParseOfx("<OFX><STMTTRN><DTPOSTED>2022-01-01<TRNAMT>1.00</STMTTRN>")
+	testError(t, err, "date '2022-01-01' is not a valid OFX date")
+	_, err = lib.ParseOfx("<OFX><STMTTRN><DTPOSTED>20220101<TRNAMT>1e3</STMTTRN>")
+	testError(t, err, "amount '1e3' is not a valid amount")
+	_, err = lib.ParseOfx("<OFX><STMTTRN><DTPOSTED>20220101</STMTTRN>")
+	testError(t, err, "does not have a TRNAMT")
+	_, err = lib.ParseOfxFile("notfound.ofx")
+	testError(t, err, "notfound.ofx")
+	if !lib.IsOfxFile("a/b.QFX") || lib.IsOfxFile("a/b.csv") {
+		t.Error("IsOfxFile is wrong")
+	}
+}
+
+func TestImportOfx(t *testing.T) {
+	txl := parser.NewJsonList(lib.IdTxTransactions)
+	count, err := lib.ImportOfxData(txl, "testdata.ofx")
+	testErrorNil(t, err, "ImportOfxData")
+	if count != 3 {
+		t.Errorf("Should import 3 not %d", count)
+	}
+	td := lib.NewTranactionDataFromNode(txl.GetValues()[0])
+	if td.AbsValue().String() != "27.56" || td.TxType() != lib.TX_TYPE_DEB || td.Ref() != "TESCO STORE 3144" {
+		t.Errorf("Debit transaction is wrong. %s", td.Ref())
+	}
+	td = lib.NewTranactionDataFromNode(txl.GetValues()[2])
+	if td.AbsValue().String() != "1473.41" || td.TxType() != lib.TX_TYPE_CRE {
+		t.Errorf("Credit transaction is wrong. %s", td.Ref())
+	}
+
+	// The same FITID is not imported again even if the ref has been edited
+	txl.GetValues()[0].(parser.NodeC).GetNodeWithName(lib.IdTxRef).(*parser.JsonString).SetValue("Shopping")
+	count, err = lib.ImportOfxData(txl, "testdata.ofx")
+	testErrorNil(t, err, "ImportOfxData all duplicates")
+	if count != 0 {
+		t.Errorf("Duplicates should not be imported. Imported %d", count)
+	}
+	if len(txl.GetValues()) != 3 {
+		t.Errorf("Duplicates should not be added. %d", len(txl.GetValues()))
+	}
+
+	// Without a FITID identical transactions are not imported again
+	count, err = lib.ImportOfxData(txl, "testdata.qfx")
+	testErrorNil(t, err, "ImportOfxData qfx")
+	if count != 2 {
+		t.Errorf("Should import 2 not %d", count)
+	}
+	count, err = lib.ImportOfxData(txl, "testdata.qfx")
+	testErrorNil(t, err, "ImportOfxData qfx all duplicates")
+	if count != 0 {
+		t.Errorf("Duplicates should not be imported. Imported %d", count)
+	}
+}
+
+func TestImportOfxSameDayDifferentFitId(t *testing.T) {
+	tx := func(fitId string) string {
+		return "<STMTTRN><TRNTYPE>POS<DTPOSTED>20220309<TRNAMT>-3.20<FITID>" + fitId + "<NAME>COFFEE SHOP</STMTTRN>\n"
+	}
+	fileName := filepath.Join(t.TempDir(), "twice.ofx")
+	resetTestFile(fileName, []byte("<OFX><BANKTRANLIST>\n"+tx("A")+tx("B")+"</BANKTRANLIST></OFX>\n"))
+	txl := parser.NewJsonList(lib.IdTxTransactions)
+	count, err := lib.ImportOfxData(txl, fileName)
+	testErrorNil(t, err, "ImportOfxData")
+	if count != 2 || len(txl.GetValues()) != 2 {
+		t.Errorf("Identical transactions with different FITIDs should both be imported. Imported %d", count)
+	}
+	count, err = lib.ImportOfxData(txl, fileName)
+	testErrorNil(t, err, "ImportOfxData again")
+	if count != 0 {
+		t.Errorf("The same FITIDs should not be imported again. Imported %d", count)
+	}
+}
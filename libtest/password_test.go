@@ -0,0 +1,93 @@
+package libtest
+
+import (
+	"strings"
+	"testing"
+
+	"stuartdd.com/lib"
+)
+
+func TestPasswordEntropy(t *testing.T) {
+	if lib.PasswordEntropy("") != 0 {
+		t.Error("Empty password should have 0 entropy")
+	}
+	if lib.PasswordEntropy("abcdefgh") >= lib.PasswordEntropy("abcdEFGH") {
+		t.Error("Mixed case should have more entropy than lower case")
+	}
+	if lib.PasswordEntropy("abcdEFGH") >= lib.PasswordEntropy("abcdEF1!") {
+		t.Error("Digits and symbols should add entropy")
+	}
+	if lib.PasswordEntropy("aaaaaaaa") >= lib.PasswordEntropy("ahdkeoqp") {
+		t.Error("Repeated characters should have less entropy")
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	testStrength(t, "", "Very Weak")
+	testStrength(t, "abc", "Very Weak")
+	testStrength(t, "abcdefg", "Weak")
+	testStrength(t, "Tr0ub4d", "Reasonable")
+	testStrength(t, "Tr0ub4dor&3", "Strong")
+	testStrength(t, "q7#Lm2!vX9@pR4$wZ8^kT1&nB6*yH3(dF5)sJ0", "Very Strong")
+}
+
+func testStrength(t *testing.T, pw, desc string) {
+	s := lib.PasswordStrengthDesc(pw)
+	if !strings.HasPrefix(s, desc+" (") {
+		t.Errorf("Password '%s' strength '%s' should be '%s'", pw, s, desc)
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	o := lib.NewPasswordGenOptions()
+	o.Length = 40
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		pw, err := lib.GeneratePassword(o)
+		testErrorNil(t, err, "GeneratePassword")
+		if len(pw) != 40 {
+			t.Errorf("Password '%s' should be 40 characters", pw)
+		}
+		if strings.ContainsAny(pw, "Il1O0o") {
+			t.Errorf("Password '%s' should not contain ambiguous characters", pw)
+		}
+		if !strings.ContainsAny(pw, "abcdefghijkmnpqrstuvwxyz") || !strings.ContainsAny(pw, "ABCDEFGHJKLMNPQRSTUVWXYZ") || !strings.ContainsAny(pw, "23456789") || !strings.ContainsAny(pw, "!#$%&*+-.=?@^_~") {
+			t.Errorf("Password '%s' should contain every character class", pw)
+		}
+		if seen[pw] {
+			t.Errorf("Password '%s' was generated twice", pw)
+		}
+		seen[pw] = true
+	}
+	o = &lib.PasswordGenOptions{Length: 4, Digits: true}
+	pw, err := lib.GeneratePassword(o)
+	testErrorNil(t, err, "GeneratePassword digits")
+	if strings.Trim(pw, "0123456789") != "" {
+		t.Errorf("Password '%s' should only contain digits", pw)
+	}
+	o.Digits = false
+	_, err = lib.GeneratePassword(o)
+	testError(t, err, "character class")
+	o.Digits = true
+	o.Length = 3
+	_, err = lib.GeneratePassword(o)
+	testError(t, err, "length must be between")
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	o := &lib.PasswordGenOptions{Passphrase: true, Words: 6, Separator: "."}
+	pw, err := lib.GeneratePassword(o)
+	testErrorNil(t, err, "GeneratePassword passphrase")
+	words := strings.Split(pw, ".")
+	if len(words) != 6 {
+		t.Errorf("Passphrase '%s' should have 6 words", pw)
+	}
+	for _, w := range words {
+		if len(w) < 3 || strings.ToLower(w) != w {
+			t.Errorf("Passphrase '%s' word '%s' is not from the word list", pw, w)
+		}
+	}
+	o.Words = 0
+	_, err = lib.GeneratePassword(o)
+	testError(t, err, "number of words")
+}
@@ -0,0 +1,129 @@
+package libtest
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+const qifAccountJson = `{"groups":{"UserA":{"assets":{"Current":{"transactions":[
+	{"date":"2022-03-01","ref":"Initial","type":"iv","val":1000},
+	{"date":"2022-03-10 12:30:00","ref":"BT GROUP PLC","type":"db","val":36.56},
+	{"date":"2022-03-09","ref":"TESCO STORE\n3144","type":"db","val":27.56},
+	{"date":"2022-03-11","ref":"BT PENSION A/C","type":"cr","val":1473.41,"category":"Pension"}
+]}}}}, "timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`
+
+func TestParseQif(t *testing.T) {
+	txs, err := lib.ParseQifFile("testdata.qif", lib.QifDateOrderDMY)
+	testErrorNil(t, err, "ParseQifFile")
+	if len(txs) != 4 {
+		t.Fatalf("Should parse 4 transactions not %d", len(txs))
+	}
+	if !txs[0].Opening || txs[0].Amount.String() != "1000.00" || lib.FormatDateTime(txs[0].Date) != "2022-03-01" {
+		t.Errorf("Opening balance is wrong. %s", txs[0])
+	}
+	if txs[1].Opening || txs[1].Amount.String() != "-27.56" || lib.FormatDateTime(txs[1].Date) != "2022-03-09" {
+		t.Errorf("Transaction 2 is wrong. %s", txs[1])
+	}
+	if txs[2].Ref() != "BT GROUP PLC [REF 9911]" {
+		t.Errorf("Memo should be added to the ref. '%s'", txs[2].Ref())
+	}
+	if txs[3].Amount.String() != "1473.41" || lib.FormatDateTime(txs[3].Date) != "2022-03-11" || txs[3].Category != "Pension" {
+		t.Errorf("Transaction 4 is wrong. %s", txs[3])
+	}
+	txs, err = lib.ParseQifFile("testdata.qif", lib.QifDateOrderMDY)
+	testErrorNil(t, err, "ParseQifFile mdy")
+	if lib.FormatDateTime(txs[1].Date) != "2022-09-03" {
+		t.Errorf("Date should be month first. %s", txs[1])
+	}
+}
+
+func TestParseQifErrors(t *testing.T) {
+	_, err := lib.ParseQif(strings.NewReader("!Type:Invst\nD01/01/2022\nT1\n^\n"), "")
+	testError(t, err, "does not contain bank transactions")
+	_, err = lib.ParseQif(strings.NewReader("!Type:Bank\nD01/01/2022\n^\n"), "")
+	testError(t, err, "line 3: transaction 1 does not have an amount (T)")
+	_, err = lib.ParseQif(strings.NewReader("!Type:Bank\nT1.00\n^\n"), "")
+	testError(t, err, "transaction 1 does not have a date (D)")
+	_, err = lib.ParseQif(strings.NewReader("!Type:Bank\nD31/02/2022\nT1\n^\n"), "")
+	testError(t, err, "date '31/02/2022' is not a valid QIF date")
+	_, err = lib.ParseQif(strings.NewReader("!Type:Bank\nD01/01/2022\nT1e3\n^\n"), "")
+	testError(t, err, "line 3: amount '1e3' is not a valid amount")
+	_, err = lib.ParseQif(strings.NewReader("!Type:Bank\nD01/01/2022\nT1\n"), "")
+	testError(t, err, "is not ended with '^'")
+	_, err = lib.ParseQifDate("01/01/2022", "ymd")
+	testError(t, err, "QIF date order 'ymd' is not valid")
+	dt, _ := lib.ParseQifDate("1/5/98", lib.QifDateOrderDMY)
+	if lib.FormatDateTime(dt) != "1998-05-01" {
+		t.Errorf("Two digit year should be 1998. %s", lib.FormatDateTime(dt))
+	}
+}
+
+func TestImportQif(t *testing.T) {
+	txl := parser.NewJsonList(lib.IdTxTransactions)
+	count, err := lib.ImportQifData(txl, "testdata.qif", lib.QifDateOrderDMY)
+	testErrorNil(t, err, "ImportQifData")
+	if count != 4 {
+		t.Errorf("Should import 4 not %d", count)
+	}
+	iv := lib.NewTranactionDataFromNode(txl.GetValues()[0])
+	if iv.TxType() != lib.TX_TYPE_IV || iv.Value().String() != "1000.00" {
+		t.Errorf("Opening balance should be the initial value. %s", iv)
+	}
+	td := lib.NewTranactionDataFromNode(txl.GetValues()[1])
+	if td.TxType() != lib.TX_TYPE_DEB || td.AbsValue().String() != "27.56" || td.Ref() != "TESCO STORE 3144" {
+		t.Errorf("Debit is wrong. %s", td)
+	}
+	td = lib.NewTranactionDataFromNode(txl.GetValues()[3])
+	if td.TxType() != lib.TX_TYPE_CRE || td.Value().String() != "1473.41" {
+		t.Errorf("Credit is wrong. %s", td)
+	}
+	count, err = lib.ImportQifData(txl, "testdata.qif", lib.QifDateOrderDMY)
+	testErrorNil(t, err, "ImportQifData all duplicates")
+	if count != 0 {
+		t.Errorf("Duplicates should not be imported. Imported %d", count)
+	}
+}
+
+func TestImportQifNegativeOpening(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "ccard.qif")
+	resetTestFile(fileName, []byte("!Type:CCard\nD01/03/2022\nT-250.00\nPOpening Balance\nL[Card]\n^\nD09/03/2022\nT-27.56\nPTESCO STORE 3144\n^\n"))
+	txl := parser.NewJsonList(lib.IdTxTransactions)
+	_, err := lib.ImportQifData(txl, fileName, lib.QifDateOrderDMY)
+	testError(t, err, "opening balance -250.00 is negative")
+	if len(txl.GetValues()) != 0 {
+		t.Error("Nothing should be imported")
+	}
+}
+
+func TestExportQif(t *testing.T) {
+	jd, err := lib.NewJsonData([]byte(qifAccountJson), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	lib.InitUserAssetsCache(jd)
+	acc, err := lib.FindUserAccount("UserA", "Current")
+	testErrorNil(t, err, "FindUserAccount")
+	var buf bytes.Buffer
+	testErrorNil(t, lib.ExportQif(&buf, acc, lib.QifDateOrderDMY), "ExportQif")
+	expected := "!Type:Bank\n" +
+		"D01/03/2022\nT1000.00\nCX\nPOpening Balance\nL[Current]\n^\n" +
+		"D09/03/2022\nT-27.56\nPTESCO STORE 3144\n^\n" +
+		"D10/03/2022\nT-36.56\nPBT GROUP PLC\n^\n" +
+		"D11/03/2022\nT1473.41\nPBT PENSION A/C\n^\n"
+	if buf.String() != expected {
+		t.Errorf("Export is wrong:\n%s", buf.String())
+	}
+
+	// The export can be imported in to an empty account
+	txs, err := lib.ParseQif(strings.NewReader(buf.String()), lib.QifDateOrderDMY)
+	testErrorNil(t, err, "ParseQif export")
+	if len(txs) != 4 || !txs[0].Opening || txs[0].Amount.String() != "1000.00" {
+		t.Errorf("Export should parse with an opening balance. %d", len(txs))
+	}
+	_, err = lib.FindUserAccount("UserA", "Missing")
+	testError(t, err, "account 'Missing' not found")
+	testError(t, lib.ExportQif(&buf, acc, "ymd"), "QIF date order 'ymd' is not valid")
+}
@@ -0,0 +1,191 @@
+package libtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+const recurringAccountsJson = `{"groups":{"UserA":{"assets":{
+	"Current":{"transactions":[
+		{"date":"2020-01-01","ref":"Initial","type":"iv","val":1000},
+		{"date":"2022-02-28","ref":"Rent","type":"db","val":500}
+	],"recurring":[
+		{"ref":"Rent","val":500,"type":"db","category":"Housing","schedule":"monthly","day":31,"start":"2022-01-31"},
+		{"ref":"Pay","val":100,"type":"cr","schedule":"weekly","start":"2022-02-01","last":"2022-02-08"},
+		{"ref":"Insurance","val":120,"type":"db","schedule":"yearly","start":"2020-02-29"},
+		{"ref":"Coffee","val":3,"type":"db","schedule":"daily","start":"2022-01-01"}
+	]},
+	"Savings":{"transactions":[
+		{"date":"2020-01-01","ref":"Initial","type":"iv","val":10}
+	]}}}},
+	"timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`
+
+var recurringNow = time.Date(2022, 3, 15, 18, 30, 0, 0, time.UTC)
+
+func testDueDates(t *testing.T, r *lib.RecurringTransaction, expected ...string) {
+	due := r.Due(recurringNow)
+	if len(due) != len(expected) {
+		t.Errorf("%s: should have %d due not %d %v", r.Ref, len(expected), len(due), due)
+		return
+	}
+	for i, dt := range due {
+		if lib.FormatDateTime(dt) != expected[i] {
+			t.Errorf("%s: due %d should be %s not %s", r.Ref, i, expected[i], lib.FormatDateTime(dt))
+		}
+	}
+}
+
+func TestRecurringDue(t *testing.T) {
+	start := time.Date(2021, 11, 30, 0, 0, 0, 0, time.UTC)
+	r, err := lib.NewRecurringTransaction("Rent", 50000, lib.TX_TYPE_DEB, "", lib.RECUR_MONTHLY, 31, start)
+	testErrorNil(t, err, "NewRecurringTransaction")
+	testDueDates(t, r, "2021-11-30", "2021-12-31", "2022-01-31", "2022-02-28")
+	r.Last = time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC)
+	testDueDates(t, r, "2022-02-28")
+
+	r, err = lib.NewRecurringTransaction("Rent", 50000, lib.TX_TYPE_DEB, "", lib.RECUR_MONTHLY, 15, start)
+	testErrorNil(t, err, "NewRecurringTransaction")
+	testDueDates(t, r, "2021-12-15", "2022-01-15", "2022-02-15", "2022-03-15")
+
+	r, err = lib.NewRecurringTransaction("Pay", 10000, lib.TX_TYPE_CRE, "", lib.RECUR_WEEKLY, 0, time.Date(2022, 2, 22, 9, 0, 0, 0, time.UTC))
+	testErrorNil(t, err, "NewRecurringTransaction")
+	testDueDates(t, r, "2022-02-22", "2022-03-01", "2022-03-08", "2022-03-15")
+
+	r, err = lib.NewRecurringTransaction("Insurance", 12000, lib.TX_TYPE_DEB, "", lib.RECUR_YEARLY, 0, time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC))
+	testErrorNil(t, err, "NewRecurringTransaction")
+	testDueDates(t, r, "2020-02-29", "2021-02-28", "2022-02-28")
+
+	r, err = lib.NewRecurringTransaction("Later", 12000, lib.TX_TYPE_DEB, "", lib.RECUR_WEEKLY, 0, time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC))
+	testErrorNil(t, err, "NewRecurringTransaction")
+	testDueDates(t, r)
+
+	_, err = lib.NewRecurringTransaction("", 100, lib.TX_TYPE_DEB, "", lib.RECUR_WEEKLY, 0, start)
+	testError(t, err, "must have a ref")
+	_, err = lib.NewRecurringTransaction("X", 0, lib.TX_TYPE_DEB, "", lib.RECUR_WEEKLY, 0, start)
+	testError(t, err, "must be greater than 0")
+	_, err = lib.NewRecurringTransaction("X", 100, lib.TX_TYPE_IV, "", lib.RECUR_WEEKLY, 0, start)
+	testError(t, err, "type must be")
+	_, err = lib.NewRecurringTransaction("X", 100, lib.TX_TYPE_DEB, "", lib.RECUR_MONTHLY, 32, start)
+	testError(t, err, "day must be 1 to 31")
+	_, err = lib.NewRecurringTransaction("X", 100, lib.TX_TYPE_DEB, "", "daily", 0, start)
+	testError(t, err, "schedule 'daily' must be one of")
+}
+
+func TestRecurringFindAndApply(t *testing.T) {
+	jd, err := lib.NewJsonData([]byte(recurringAccountsJson), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	lib.InitUserAssetsCache(jd)
+	acc, err := lib.FindUserAccount("UserA", "Current")
+	testErrorNil(t, err, "FindUserAccount")
+	if len(acc.Recurring) != 4 || acc.Recurring[0].Ref != "Rent" || acc.Recurring[3].Err == nil {
+		t.Fatalf("Account should have 4 recurring templates. The last is not valid %v", acc.Recurring)
+	}
+
+	due, errs := jd.FindDueTransactions(recurringNow)
+	if len(errs) != 1 {
+		t.Fatalf("The Coffee template should be an error %v", errs)
+	}
+	testError(t, errs[0], "schedule 'daily' must be one of")
+	if len(due) != 10 {
+		t.Fatalf("Should be 10 due not %d", len(due))
+	}
+	exists := 0
+	for _, d := range due {
+		if d.Exists {
+			exists++
+			if d.Template.Ref != "Rent" || lib.FormatDateTime(d.Date) != "2022-02-28" {
+				t.Errorf("Only Rent on 2022-02-28 should exist. %s", d)
+			}
+		}
+	}
+	if exists != 1 {
+		t.Errorf("One due transaction should exist not %d", exists)
+	}
+
+	count, err := jd.ApplyDueTransactions(recurringNow, parser.NewBarPath("UserA"))
+	testErrorNil(t, err, "ApplyDueTransactions")
+	if count != 9 {
+		t.Errorf("Should add 9 not %d", count)
+	}
+	lib.InitUserAssetsCache(jd)
+	acc, _ = lib.FindUserAccount("UserA", "Current")
+	if len(acc.Transactions) != 11 {
+		t.Errorf("Account should have 11 transactions not %d", len(acc.Transactions))
+	}
+	if acc.ClosingValue.String() != "140.00" {
+		t.Errorf("Closing value should be 140.00 not %s", acc.ClosingValue)
+	}
+	for i, last := range []string{"2022-02-28", "2022-03-15", "2022-02-28"} {
+		if lib.FormatDateTime(acc.Recurring[i].Last) != last {
+			t.Errorf("%s last should be %s not %s", acc.Recurring[i].Ref, last, lib.FormatDateTime(acc.Recurring[i].Last))
+		}
+	}
+	housing := 0
+	for _, tx := range acc.Transactions {
+		if tx.Category() == "Housing" {
+			housing++
+		}
+	}
+	if housing != 1 {
+		t.Errorf("The added Rent should have the template category")
+	}
+
+	due, _ = jd.FindDueTransactions(recurringNow)
+	if len(due) != 0 {
+		t.Errorf("Nothing should be due after apply %v", due)
+	}
+	count, err = jd.ApplyDueTransactions(recurringNow, parser.NewBarPath("UserA"))
+	testErrorNil(t, err, "ApplyDueTransactions")
+	if count != 0 {
+		t.Errorf("Nothing should be added twice")
+	}
+
+	testErrorNil(t, jd.Undo(), "Undo")
+	lib.InitUserAssetsCache(jd)
+	acc, _ = lib.FindUserAccount("UserA", "Current")
+	if len(acc.Transactions) != 2 || !acc.Recurring[0].Last.IsZero() {
+		t.Errorf("Undo should remove the added transactions and the last dates")
+	}
+}
+
+func TestRecurringAddRemove(t *testing.T) {
+	jd, err := lib.NewJsonData([]byte(recurringAccountsJson), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	path := parser.NewBarPath("UserA").StringAppend(lib.IdAssets).StringAppend("Savings")
+	r, err := lib.NewRecurringTransaction("Save", 2550, lib.TX_TYPE_CRE, "Savings", lib.RECUR_MONTHLY, 1, time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC))
+	testErrorNil(t, err, "NewRecurringTransaction")
+	testErrorNil(t, jd.AddRecurring(path, r), "AddRecurring")
+	testError(t, jd.AddRecurring(parser.NewBarPath("UserA").StringAppend(lib.IdAssets).StringAppend("Missing"), r), "cannot be found")
+
+	lib.InitUserAssetsCache(jd)
+	acc, _ := lib.FindUserAccount("UserA", "Savings")
+	if len(acc.Recurring) != 1 || acc.Recurring[0].String() != "Save cr 25.50. Monthly on day 1 from 2022-03-01" {
+		t.Fatalf("Template was not added %v", acc.Recurring)
+	}
+	due, _ := jd.FindDueTransactions(recurringNow)
+	found := false
+	for _, d := range due {
+		if d.Account == "Savings" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("The added template should be due")
+	}
+
+	testError(t, jd.RemoveRecurring(path, 1), "does not have recurring transaction 1")
+	testErrorNil(t, jd.RemoveRecurring(path, 0), "RemoveRecurring")
+	n, _ := parser.Find(jd.GetUserRoot(), path)
+	if n.(parser.NodeC).GetNodeWithName(lib.IdTxRecurring) != nil {
+		t.Error("The empty recurring list should be removed")
+	}
+	testErrorNil(t, jd.Undo(), "Undo")
+	lib.InitUserAssetsCache(jd)
+	acc, _ = lib.FindUserAccount("UserA", "Savings")
+	if len(acc.Recurring) != 1 {
+		t.Error("Undo should restore the template")
+	}
+}
@@ -0,0 +1,345 @@
+package libtest
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+const (
+	remoteFileName = "remote.json"
+	remoteContent1 = `{"groups":{"UserA":{"pwHints":{"Hint":{"notes":"n1"}}}},"timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`
+	remoteContent2 = `{"groups":{"UserA":{"pwHints":{"Hint":{"notes":"n2"}}}},"timeStamp":"Fri Jul 30 21:25:11 BST 2021"}`
+	remoteContent3 = `{"groups":{"UserA":{"pwHints":{"Hint":{"notes":"n3"}}}},"timeStamp":"Fri Jul 30 21:25:12 BST 2021"}`
+)
+
+//
+// A remote store that holds one file. Each POST creates a new revision.
+// If useETag is false the server behaves like a server without revisions.
+//
+type testRemoteServer struct {
+	mu       sync.Mutex
+	content  []byte
+	revision int
+	useETag  bool
+}
+
+func (s *testRemoteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path != "/"+remoteFileName {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	etag := fmt.Sprintf("\"%d\"", s.revision)
+	switch r.Method {
+	case http.MethodGet:
+		if s.useETag {
+			w.Header().Set("ETag", etag)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(s.content)
+	case http.MethodPost:
+		if s.useETag {
+			if im := r.Header.Get("If-Match"); im != "" && im != etag {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		s.content = body
+		s.revision++
+		if s.useETag {
+			w.Header().Set("ETag", fmt.Sprintf("\"%d\"", s.revision))
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestRemote(t *testing.T, content string, useETag bool) (*testRemoteServer, *httptest.Server) {
+	rs := &testRemoteServer{content: []byte(content), useETag: useETag}
+	return rs, httptest.NewServer(rs)
+}
+
+func TestRemoteConflict(t *testing.T) {
+	rs, server := newTestRemote(t, remoteContent1, true)
+	defer server.Close()
+	fdA, err := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testErrorNil(t, err, "NewFileData A")
+	fdB, err := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testErrorNil(t, err, "NewFileData B")
+	if fdA.GetRevision() != "\"0\"" || !fdA.IsRemote() {
+		t.Fatalf("Revision should be loaded from the ETag not '%s'", fdA.GetRevision())
+	}
+
+	fdA.SetContent([]byte(remoteContent2))
+	testErrorNil(t, fdA.StoreContentUnEncrypted(storeCallMeBack), "Store A")
+	if fdA.GetRevision() != "\"1\"" {
+		t.Errorf("Revision should be updated by the store not '%s'", fdA.GetRevision())
+	}
+
+	fdB.SetContent([]byte(remoteContent3))
+	storeCalledBack = false
+	err = fdB.StoreContentUnEncrypted(storeCallMeBack)
+	if !errors.Is(err, lib.ErrRemoteChanged) {
+		t.Fatalf("Store B should fail with ErrRemoteChanged not %v", err)
+	}
+	if storeCalledBack || string(rs.content) != remoteContent2 {
+		t.Errorf("Store B should not change the remote data:'%s'", rs.content)
+	}
+
+	remote, err := fdB.GetRemoteContent()
+	testErrorNil(t, err, "GetRemoteContent")
+	if string(remote) != remoteContent2 || fdB.GetRevision() != "\"1\"" {
+		t.Errorf("Remote content should be A's data at revision 1. '%s' '%s'", remote, fdB.GetRevision())
+	}
+	testErrorNil(t, fdB.StoreContentUnEncrypted(storeCallMeBack), "Store B after GetRemoteContent")
+	if string(rs.content) != remoteContent3 {
+		t.Errorf("Store B should now replace the remote data:'%s'", rs.content)
+	}
+
+	fdA.SetContent([]byte(remoteContent1))
+	testError(t, fdA.StoreContentUnEncrypted(storeCallMeBack), "remote data has changed")
+	fdA.IgnoreRemoteChanges()
+	testErrorNil(t, fdA.StoreContentUnEncrypted(storeCallMeBack), "Store A overwrite")
+	if string(rs.content) != remoteContent1 || fdA.GetRevision() != "\"3\"" {
+		t.Errorf("Overwrite should replace the remote data:'%s' '%s'", rs.content, fdA.GetRevision())
+	}
+}
+
+func TestRemoteConflictEncrypted(t *testing.T) {
+	_, server := newTestRemote(t, remoteContent1, true)
+	defer server.Close()
+	fdA, _ := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testErrorNil(t, fdA.StoreContentEncrypted(password, storeCallMeBack), "Store A encrypted")
+	fdB, _ := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testErrorNil(t, fdB.DecryptContents(password), "DecryptContents")
+
+	fdA.SetContent([]byte(remoteContent2))
+	testErrorNil(t, fdA.StoreContentAsIs(storeCallMeBack), "Store A")
+	fdB.SetContent([]byte(remoteContent3))
+	testError(t, fdB.StoreContentAsIs(storeCallMeBack), "remote data has changed")
+	remote, err := fdB.GetRemoteContent()
+	testErrorNil(t, err, "GetRemoteContent")
+	if string(remote) != remoteContent2 {
+		t.Errorf("Remote content should be decrypted:'%s'", remote)
+	}
+}
+
+func TestRemoteWithoutRevision(t *testing.T) {
+	rs, server := newTestRemote(t, remoteContent1, false)
+	defer server.Close()
+	fdA, _ := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	fdB, _ := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	if fdA.GetRevision() != "" {
+		t.Errorf("Revision should be empty without an ETag not '%s'", fdA.GetRevision())
+	}
+	fdA.SetContent([]byte(remoteContent2))
+	testErrorNil(t, fdA.StoreContentUnEncrypted(storeCallMeBack), "Store A")
+	fdB.SetContent([]byte(remoteContent3))
+	testErrorNil(t, fdB.StoreContentUnEncrypted(storeCallMeBack), "Store B")
+	if string(rs.content) != remoteContent3 {
+		t.Errorf("Without revisions the last store should win:'%s'", rs.content)
+	}
+	_, err := lib.NewFileData("missing.json", nil, server.URL, server.URL)
+	testError(t, err, "Return Code: 404")
+}
+
+func TestMergeFrom(t *testing.T) {
+	local := `{"groups":{"UserA":{
+		"pwHints":{"Hint":{"notes":"local"}},
+		"assets":{"Current":{"transactions":[{"date":"2022-01-01","ref":"Initial","type":"iv","val":10}]}}}},
+		"timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`
+	remote := `{"groups":{
+		"UserA":{
+			"pwHints":{"Hint":{"notes":"remote","post":"p"},"Hint2":{"notes":"n"}},
+			"assets":{"Current":{"transactions":[
+				{"date":"2022-01-01","ref":"Initial","type":"iv","val":10},
+				{"date":"2022-01-02","ref":"Shop","type":"db","val":5}]}}},
+		"UserB":{"pwHints":{"HintB":{"notes":"b"}}}},
+		"exchangeRates":{"EUR":1.17},
+		"timeStamp":"Fri Jul 30 21:25:11 BST 2021"}`
+	jd, err := lib.NewJsonData([]byte(local), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	count, err := jd.MergeFrom([]byte(remote), parser.NewBarPath("UserA"))
+	testErrorNil(t, err, "MergeFrom")
+	if count != 5 {
+		t.Errorf("Should merge 5 items (post, Hint2, Shop, UserB, exchangeRates) not %d", count)
+	}
+	j := jd.ToJson()
+	for _, s := range []string{`"notes": "local"`, `"post": "p"`, `"Hint2"`, `"Shop"`, `"UserB"`, `"EUR"`} {
+		if !strings.Contains(j, s) {
+			t.Errorf("Merged data should contain %s", s)
+		}
+	}
+	if strings.Contains(j, `"remote"`) {
+		t.Error("Local values should be kept")
+	}
+	count, err = jd.MergeFrom([]byte(remote), parser.NewBarPath("UserA"))
+	testErrorNil(t, err, "MergeFrom again")
+	if count != 0 {
+		t.Errorf("Merging the same data again should add nothing not %d", count)
+	}
+	testErrorNil(t, jd.Undo(), "Undo")
+	if strings.Contains(jd.ToJson(), "UserB") {
+		t.Error("Undo should remove the merged items")
+	}
+	_, err = jd.MergeFrom([]byte(`{"x":1}`), parser.NewBarPath("UserA"))
+	testError(t, err, "could not be read")
+}
+
+func TestMergeFromEditedTransaction(t *testing.T) {
+	local := `{"groups":{"UserA":{
+		"assets":{"Current":{"transactions":[{"date":"2022-01-01","ref":"Shop","type":"db","val":5,"category":"Food"}]}}}},
+		"timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`
+	remote := `{"groups":{"UserA":{
+		"assets":{"Current":{"transactions":[
+			{"date":"2022-01-01","ref":"Shop","type":"db","val":5,"category":"Home","notes":"remote"},
+			{"date":"2022-01-02","ref":"Shop","type":"db","val":5}]}}}},
+		"timeStamp":"Fri Jul 30 21:25:11 BST 2021"}`
+	jd, err := lib.NewJsonData([]byte(local), func(desc string, p *parser.Path, err error) {})
+	testErrorNil(t, err, "NewJsonData")
+	count, err := jd.MergeFrom([]byte(remote), parser.NewBarPath("UserA"))
+	testErrorNil(t, err, "MergeFrom")
+	if count != 1 {
+		t.Errorf("Only the new transaction should be merged not %d", count)
+	}
+	j := jd.ToJson()
+	if strings.Count(j, `"date"`) != 2 || !strings.Contains(j, `"Food"`) || strings.Contains(j, `"Home"`) {
+		t.Errorf("The transaction edited in both copies should not be added again:\n%s", j)
+	}
+}
+
+func TestRemoteClientRetryAndAuth(t *testing.T) {
+	defer lib.SetRemoteClient(nil)
+	var mu sync.Mutex
+	calls := 0
+	auth := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		auth = append(auth, r.Header.Get("Authorization"))
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(remoteContent1))
+	}))
+	defer server.Close()
+
+	rc, err := lib.NewRemoteClient(&lib.RemoteConfig{Retries: 1, Backoff: time.Millisecond, Token: "tok"})
+	testErrorNil(t, err, "NewRemoteClient")
+	lib.SetRemoteClient(rc)
+	_, err = lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testError(t, err, "Return Code: 503")
+	if calls != 2 || auth[0] != "Bearer tok" {
+		t.Errorf("Should try twice with a bearer token. %d %v", calls, auth)
+	}
+
+	calls = 0
+	rc, _ = lib.NewRemoteClient(&lib.RemoteConfig{Retries: 2, Backoff: time.Millisecond, User: "u", Password: "p"})
+	lib.SetRemoteClient(rc)
+	fd, err := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testErrorNil(t, err, "NewFileData with retries")
+	if calls != 3 || string(fd.GetContent()) != remoteContent1 || !strings.HasPrefix(auth[len(auth)-1], "Basic ") {
+		t.Errorf("Should succeed on the third attempt with basic auth. %d %v", calls, auth)
+	}
+}
+
+func TestRemoteClientPostRetry(t *testing.T) {
+	defer lib.SetRemoteClient(nil)
+	var mu sync.Mutex
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPost {
+			posts++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(remoteContent1))
+	}))
+	rc, _ := lib.NewRemoteClient(&lib.RemoteConfig{Retries: 2, Backoff: time.Millisecond})
+	lib.SetRemoteClient(rc)
+	fd, err := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testErrorNil(t, err, "NewFileData")
+	err = fd.StoreContentUnEncrypted(storeCallMeBack)
+	testError(t, err, "503")
+	if posts != 1 {
+		t.Errorf("A POST that was sent should not be repeated. Sent %d times", posts)
+	}
+
+	// The connection cannot be made so the data was not sent. Retry.
+	// A new client so an idle connection to the closed server is not re-used.
+	server.Close()
+	rc, _ = lib.NewRemoteClient(&lib.RemoteConfig{Retries: 2, Backoff: time.Millisecond})
+	lib.SetRemoteClient(rc)
+	err = fd.StoreContentUnEncrypted(storeCallMeBack)
+	testError(t, err, "failed after 3 attempt(s)")
+}
+
+func TestRemoteClientTimeout(t *testing.T) {
+	defer lib.SetRemoteClient(nil)
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	rc, _ := lib.NewRemoteClient(&lib.RemoteConfig{Timeout: 50 * time.Millisecond})
+	lib.SetRemoteClient(rc)
+	start := time.Now()
+	_, err := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testError(t, err, "failed after 1 attempt(s)")
+	if time.Since(start) > 5*time.Second {
+		t.Error("Request should time out")
+	}
+}
+
+func TestRemoteClientTLS(t *testing.T) {
+	defer lib.SetRemoteClient(nil)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(remoteContent1))
+	}))
+	defer server.Close()
+	_, err := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testError(t, err, "certificate")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	resetTestFile(caFile, pemData)
+	rc, err := lib.NewRemoteClient(&lib.RemoteConfig{CaFile: caFile})
+	testErrorNil(t, err, "NewRemoteClient")
+	lib.SetRemoteClient(rc)
+	fd, err := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testErrorNil(t, err, "NewFileData with CA file")
+	if string(fd.GetContent()) != remoteContent1 {
+		t.Errorf("Content was not loaded over TLS:'%s'", fd.GetContent())
+	}
+
+	resetTestFile(caFile, []byte("not a cert"))
+	_, err = lib.NewRemoteClient(&lib.RemoteConfig{CaFile: caFile})
+	testError(t, err, "does not contain any PEM certificates")
+	_, err = lib.NewRemoteClient(&lib.RemoteConfig{CertFile: caFile})
+	testError(t, err, "requires both a certificate file and a key file")
+	_, err = lib.NewRemoteClient(&lib.RemoteConfig{Retries: -1})
+	testError(t, err, "cannot be less than 0")
+}
@@ -0,0 +1,173 @@
+package libtest
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"stuartdd.com/lib"
+)
+
+func newTestStorageServer(t *testing.T, token string, historyMax int) (string, *httptest.Server, *[]string) {
+	dir := t.TempDir()
+	logged := make([]string, 0)
+	ss, err := lib.NewStorageServer(dir, token, historyMax, func(l string) { logged = append(logged, l) })
+	if err != nil {
+		t.Fatalf("NewStorageServer failed. %s", err)
+	}
+	return dir, httptest.NewServer(ss), &logged
+}
+
+func testRequest(t *testing.T, method, url, token, body string) (int, string, http.Header) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed. %s", method, url, err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b), resp.Header
+}
+
+func TestStorageServerProtocol(t *testing.T) {
+	dir, server, logged := newTestStorageServer(t, "", 10)
+	defer server.Close()
+	resetTestFile(filepath.Join(dir, remoteFileName), []byte(remoteContent1))
+
+	fdA, err := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testErrorNil(t, err, "NewFileData A")
+	fdB, err := lib.NewFileData(remoteFileName, nil, server.URL, server.URL)
+	testErrorNil(t, err, "NewFileData B")
+	if string(fdA.GetContent()) != remoteContent1 || fdA.GetRevision() != lib.StorageRevision([]byte(remoteContent1)) {
+		t.Fatalf("Content and revision should be served:'%s' '%s'", fdA.GetContent(), fdA.GetRevision())
+	}
+
+	fdA.SetContent([]byte(remoteContent2))
+	testErrorNil(t, fdA.StoreContentUnEncrypted(storeCallMeBack), "Store A")
+	dat, _ := ioutil.ReadFile(filepath.Join(dir, remoteFileName))
+	if string(dat) != remoteContent2 {
+		t.Errorf("File should be replaced:'%s'", dat)
+	}
+	fdB.SetContent([]byte(remoteContent3))
+	err = fdB.StoreContentUnEncrypted(storeCallMeBack)
+	if !errors.Is(err, lib.ErrRemoteChanged) {
+		t.Errorf("Store B should be rejected not %v", err)
+	}
+
+	status, _, _ := testRequest(t, http.MethodGet, server.URL+"/missing.json", "", "")
+	if status != http.StatusNotFound {
+		t.Errorf("Missing file should be 404 not %d", status)
+	}
+	for _, p := range []string{"/.history", "/..%2Fx", "/a/b/c/d"} {
+		status, _, _ = testRequest(t, http.MethodGet, server.URL+p, "", "")
+		if status != http.StatusNotFound {
+			t.Errorf("%s should be 404 not %d", p, status)
+		}
+	}
+	status, _, _ = testRequest(t, http.MethodDelete, server.URL+"/"+remoteFileName, "", "")
+	if status != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE should be 405 not %d", status)
+	}
+	status, _, h := testRequest(t, http.MethodPost, server.URL+"/new.json", "", remoteContent1)
+	if status != http.StatusCreated || h.Get("ETag") != lib.StorageRevision([]byte(remoteContent1)) {
+		t.Errorf("POST should create a new file %d '%s'", status, h.Get("ETag"))
+	}
+	if len(*logged) == 0 || !strings.Contains((*logged)[0], "GET /"+remoteFileName+" 200") {
+		t.Errorf("Requests should be logged %v", *logged)
+	}
+}
+
+func TestStorageServerAuth(t *testing.T) {
+	dir, server, _ := newTestStorageServer(t, "secret", 10)
+	defer server.Close()
+	resetTestFile(filepath.Join(dir, remoteFileName), []byte(remoteContent1))
+	for _, token := range []string{"", "wrong"} {
+		status, _, h := testRequest(t, http.MethodGet, server.URL+"/"+remoteFileName, token, "")
+		if status != http.StatusUnauthorized || h.Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("Token '%s' should be 401 not %d", token, status)
+		}
+		status, _, _ = testRequest(t, http.MethodPost, server.URL+"/"+remoteFileName, token, remoteContent2)
+		if status != http.StatusUnauthorized {
+			t.Errorf("Token '%s' POST should be 401 not %d", token, status)
+		}
+	}
+	status, body, _ := testRequest(t, http.MethodGet, server.URL+"/"+remoteFileName, "secret", "")
+	if status != http.StatusOK || body != remoteContent1 {
+		t.Errorf("Valid token should be 200 not %d", status)
+	}
+}
+
+func TestStorageServerHistorySameSecond(t *testing.T) {
+	dir, server, _ := newTestStorageServer(t, "", 10)
+	defer server.Close()
+	url := server.URL + "/" + remoteFileName
+	for _, c := range []string{remoteContent1, remoteContent2, remoteContent3, remoteContent1} {
+		testRequest(t, http.MethodPost, url, "", c)
+	}
+	files, _ := os.ReadDir(filepath.Join(dir, ".history", remoteFileName))
+	if len(files) != 3 {
+		t.Errorf("Every previous revision should be kept %v", files)
+	}
+}
+
+func TestStorageServerHistory(t *testing.T) {
+	dir, server, logged := newTestStorageServer(t, "", 2)
+	defer server.Close()
+	url := server.URL + "/" + remoteFileName
+	testRequest(t, http.MethodPost, url, "", remoteContent1)
+	list := func() []string {
+		_, body, _ := testRequest(t, http.MethodGet, url+"/history", "", "")
+		l := make([]string, 0)
+		json.Unmarshal([]byte(body), &l)
+		return l
+	}
+	status, _, _ := testRequest(t, http.MethodGet, url+"/history", "", "")
+	if status != http.StatusNotFound {
+		t.Errorf("A new file should have no history. Status should be 404 not %d", status)
+	}
+	status, _, _ = testRequest(t, http.MethodGet, server.URL+"/missing.json/history", "", "")
+	if status != http.StatusNotFound || lib.FileExists(filepath.Join(dir, ".history", "missing.json")) {
+		t.Errorf("History of a missing file should be 404 not %d and should not create a directory", status)
+	}
+	testRequest(t, http.MethodPost, url, "", remoteContent2)
+	l := list()
+	if len(l) != 1 || !strings.HasPrefix(l[0], remoteFileName+".") {
+		t.Fatalf("History should have the previous revision %v", l)
+	}
+	status, body, _ := testRequest(t, http.MethodGet, url+"/history/"+l[0], "", "")
+	if status != http.StatusOK || body != remoteContent1 {
+		t.Errorf("History should return the previous revision %d '%s'", status, body)
+	}
+	status, _, _ = testRequest(t, http.MethodGet, url+"/history/other.json.x.bak", "", "")
+	if status != http.StatusNotFound {
+		t.Errorf("History of another file should be 404 not %d", status)
+	}
+
+	// Retention. Use names in the past so they sort before the next revision
+	hdir := filepath.Join(dir, ".history", remoteFileName)
+	for _, n := range []string{"2000-01-01_000000", "2000-01-02_000000", "2000-01-03_000000"} {
+		resetTestFile(filepath.Join(hdir, remoteFileName+"."+n+".bak"), []byte("old"))
+	}
+	testRequest(t, http.MethodPost, url, "", remoteContent3)
+	files, _ := os.ReadDir(hdir)
+	if len(files) != 2 || strings.HasPrefix(files[0].Name(), remoteFileName+".2000") || strings.HasPrefix(files[1].Name(), remoteFileName+".2000") {
+		t.Errorf("History should keep the 2 newest revisions %v", files)
+	}
+	if !strings.Contains(strings.Join(*logged, "\n"), "Remove file "+remoteFileName+".2000-01-03_000000.bak") {
+		t.Errorf("Removed revisions should be logged %v", *logged)
+	}
+
+	_, err := lib.NewStorageServer(filepath.Join(dir, "missing"), "", 2, nil)
+	testError(t, err, "does not exist")
+	_, err = lib.NewStorageServer(dir, "", 0, nil)
+	testError(t, err, "cannot be less than 1")
+}
@@ -0,0 +1,88 @@
+package libtest
+
+import (
+	"encoding/base32"
+	"fmt"
+	"testing"
+	"time"
+
+	"stuartdd.com/lib"
+)
+
+//
+// Test vectors from RFC 6238 Appendix B. Each algorithm uses a different length ASCII seed.
+//
+var (
+	totpSeeds = map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	totpTimes   = []int64{59, 1111111109, 1111111111, 1234567890, 2000000000, 20000000000}
+	totpVectors = map[string][]string{
+		"SHA1":   {"94287082", "07081804", "14050471", "89005924", "69279037", "65353130"},
+		"SHA256": {"46119246", "68084774", "67062674", "91819424", "90698825", "77737706"},
+		"SHA512": {"90693936", "25091201", "99943326", "93441116", "38618901", "47863826"},
+	}
+)
+
+func TestTotpRfcVectors(t *testing.T) {
+	for alg, seed := range totpSeeds {
+		secret := base32.StdEncoding.EncodeToString([]byte(seed))
+		k, err := lib.ParseTotp(fmt.Sprintf("otpauth://totp/RFC:6238?secret=%s&algorithm=%s&digits=8", secret, alg))
+		testErrorNil(t, err, "ParseTotp "+alg)
+		for i, tm := range totpTimes {
+			code := k.CodeAt(time.Unix(tm, 0))
+			if code != totpVectors[alg][i] {
+				t.Errorf("%s at %d: code '%s' should be '%s'", alg, tm, code, totpVectors[alg][i])
+			}
+		}
+	}
+}
+
+func TestTotpParse(t *testing.T) {
+	k, err := lib.ParseTotp("otpauth://totp/Example:alice@google.com?secret=JBSWY3DPEHPK3PXP&issuer=Example")
+	testErrorNil(t, err, "ParseTotp uri")
+	if k.Issuer != "Example" || k.Account != "alice@google.com" || k.Digits != 6 || k.Period != 30 || k.Algorithm != "SHA1" {
+		t.Errorf("Uri parsed incorrectly %+v", k)
+	}
+	if k.String() != "Example:alice@google.com" {
+		t.Errorf("String '%s' is incorrect", k.String())
+	}
+	// Bare secret. Lower case, spaces and no padding
+	k2, err := lib.ParseTotp(" jbsw y3dp ehpk 3pxp ")
+	testErrorNil(t, err, "ParseTotp secret")
+	now := time.Unix(1234567890, 0)
+	if k2.CodeAt(now) != k.CodeAt(now) || len(k2.CodeAt(now)) != 6 {
+		t.Errorf("Secret and uri codes should be the same 6 digits")
+	}
+	if k2.RemainingAt(time.Unix(1234567890, 0)) != 30 {
+		t.Errorf("Remaining should be 30 at a period boundary. Got %d", k2.RemainingAt(now))
+	}
+	if k2.RemainingAt(time.Unix(1234567899, 0)) != 21 {
+		t.Errorf("Remaining should be 21. Got %d", k2.RemainingAt(time.Unix(1234567899, 0)))
+	}
+
+	_, err = lib.ParseTotp("")
+	testError(t, err, "secret is empty")
+	_, err = lib.ParseTotp("not!base32")
+	testError(t, err, "not valid base32")
+	_, err = lib.ParseTotp("otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP")
+	testError(t, err, "'hotp' is not supported")
+	_, err = lib.ParseTotp("otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&algorithm=MD5")
+	testError(t, err, "'MD5' is not supported")
+	_, err = lib.ParseTotp("otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=4")
+	testError(t, err, "must be 6, 7 or 8")
+	_, err = lib.ParseTotp("otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&period=0")
+	testError(t, err, "period '0' is invalid")
+}
+
+func TestTotpAnnotation(t *testing.T) {
+	at, name := lib.GetNodeAnnotationTypeAndName("github!otp")
+	if at != lib.NODE_TYPE_OT || name != "github" {
+		t.Errorf("Annotation should be NODE_TYPE_OT 'github'. Got %d '%s'", at, name)
+	}
+	if lib.GetNodeAnnotationNameWithPrefix(lib.NODE_TYPE_OT, "github") != "github!otp" {
+		t.Error("Name with prefix should be 'github!otp'")
+	}
+}
@@ -0,0 +1,157 @@
+package libtest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+func TestUndoRedo(t *testing.T) {
+	jd := dataLoad(t, "TestDataTypesGold.json")
+	if jd.CanUndo() || jd.CanRedo() {
+		t.Fatal("Nothing should be undoable after load")
+	}
+	if jd.Undo() == nil || jd.Redo() == nil {
+		t.Fatal("Undo and Redo should fail when the stack is empty")
+	}
+	root := jd.GetDataRoot()
+	snap := func() parser.NodeI {
+		return parser.Clone(root, root.GetName(), true)
+	}
+	orig := snap()
+
+	testErrorNil(t, jd.Remove(parser.NewBarPath("UserA|pwHints|MyApp"), 1), "Remove")
+	afterRemove := snap()
+	testErrorNil(t, jd.Rename(parser.NewBarPath("UserB"), "UserC"), "Rename")
+	testErrorNil(t, jd.AddUser("UserD"), "AddUser")
+	testErrorNil(t, jd.CloneHint(parser.NewBarPath("UserC|pwHints|GMail B"), "GMail C", true), "CloneHint")
+	final := snap()
+	if jd.UndoDesc() != "Clone Item 'GMail C'" {
+		t.Errorf("Undo desc should be the clone. '%s'", jd.UndoDesc())
+	}
+
+	var updated string
+	var updatedPath *parser.Path
+	dat, err := os.ReadFile("TestDataTypesGold.json")
+	testErrorNil(t, err, "ReadFile")
+	jd2, err := lib.NewJsonData(dat, func(desc string, p *parser.Path, err error) {
+		updated = desc
+		updatedPath = p
+	})
+	testErrorNil(t, err, "NewJsonData")
+	testErrorNil(t, jd2.Remove(parser.NewBarPath("UserA|pwHints|MyApp"), 1), "Remove")
+	testErrorNil(t, jd2.Undo(), "Undo jd2")
+	if updated != "Undo Remove 'MyApp'" || updatedPath.String() != "UserA|pwHints|MyApp" {
+		t.Errorf("dataMapUpdated should be called after Undo with the restored item. '%s' '%s'", updated, updatedPath)
+	}
+	testErrorNil(t, jd2.Redo(), "Redo jd2")
+	if updated != "Redo Remove 'MyApp'" || updatedPath.String() != "UserA|pwHints" {
+		t.Errorf("dataMapUpdated should be called after Redo. '%s' '%s'", updated, updatedPath)
+	}
+
+	for i := 0; i < 3; i++ {
+		testErrorNil(t, jd.Undo(), "Undo")
+	}
+	if !root.Equal(afterRemove) {
+		t.Error("Three Undo should return to the state after the Remove")
+	}
+	testErrorNil(t, jd.Undo(), "Undo Remove")
+	if !root.Equal(orig) {
+		t.Error("Undo should return to the original data")
+	}
+	if jd.GetDataRoot() != root {
+		t.Error("Undo should restore the data in the same root")
+	}
+	if _, err := jd.FindNodeForUserDataPath(parser.NewBarPath("UserA|pwHints|MyApp")); err != nil {
+		t.Error("Removed item should be restored")
+	}
+	if jd.CanUndo() {
+		t.Error("Nothing more to undo")
+	}
+	for jd.CanRedo() {
+		testErrorNil(t, jd.Redo(), "Redo")
+	}
+	if !root.Equal(final) {
+		t.Error("Redo all should return to the final data")
+	}
+
+	// A new operation clears the redo stack
+	testErrorNil(t, jd.Undo(), "Undo")
+	testErrorNil(t, jd.AddHint(parser.NewBarPath("UserD"), "NewHint"), "AddHint")
+	if jd.CanRedo() {
+		t.Error("A new operation should clear redo")
+	}
+
+	// Changes made outside JsonData are recorded by RecordUndo. Unchanged data is not recorded.
+	p := parser.NewBarPath("UserD|pwHints|NewHint|notes")
+	testErrorNil(t, jd.RecordUndo("Edit", p, func() error {
+		n, _ := jd.FindNodeForUserDataPath(p)
+		n.(*parser.JsonString).SetValue("edited")
+		return nil
+	}), "RecordUndo")
+	testErrorNil(t, jd.RecordUndo("Nothing", p, func() error { return nil }), "RecordUndo no change")
+	if jd.UndoDesc() != "Edit" {
+		t.Errorf("Undo desc should be the edit. '%s'", jd.UndoDesc())
+	}
+	testErrorNil(t, jd.Undo(), "Undo edit")
+	n, _ := jd.FindNodeForUserDataPath(p)
+	if n.String() != "" {
+		t.Error("Undo should revert the edit")
+	}
+
+	// The size of the stack is limited
+	defer lib.SetUndoMax(50)
+	lib.SetUndoMax(2)
+	for _, v := range []string{"a", "b", "c"} {
+		testErrorNil(t, jd.SetLeafValue(p, v), "SetLeafValue")
+	}
+	testErrorNil(t, jd.Undo(), "Undo 1")
+	testErrorNil(t, jd.Undo(), "Undo 2")
+	if jd.CanUndo() {
+		t.Error("Only 2 operations should be undoable")
+	}
+	n, _ = jd.FindNodeForUserDataPath(p)
+	if n.String() != "a" {
+		t.Errorf("Value should be 'a' not '%s'", n.String())
+	}
+}
+
+func TestUndoRemovedItemChanges(t *testing.T) {
+	jd := dataLoad(t, "TestDataTypesGold.json")
+	p := parser.NewBarPath("UserA|pwHints|MyApp|notes")
+	testErrorNil(t, jd.SetLeafValue(p, "changed"), "SetLeafValue")
+	testErrorNil(t, jd.Rename(parser.NewBarPath("UserA|pwHints|MyApp"), "MyApp2"), "Rename")
+	testErrorNil(t, jd.Remove(parser.NewBarPath("UserA|pwHints|MyApp2"), 1), "Remove")
+	for jd.CanUndo() {
+		testErrorNil(t, jd.Undo(), "Undo")
+	}
+	n, err := jd.FindNodeForUserDataPath(p)
+	testErrorNil(t, err, "Find restored item")
+	if n.String() != "a note to User A" {
+		t.Errorf("The restored item should have its original value not '%s'", n.String())
+	}
+	if len(jd.GetHistory(p)) != 0 {
+		t.Error("The history of the change should be undone")
+	}
+	for jd.CanRedo() {
+		testErrorNil(t, jd.Redo(), "Redo")
+	}
+	if _, err := jd.FindNodeForUserDataPath(parser.NewBarPath("UserA|pwHints|MyApp2")); err == nil {
+		t.Error("Redo should remove the renamed item again")
+	}
+
+	jd.ClearUndo()
+	if jd.CanUndo() || jd.CanRedo() {
+		t.Error("ClearUndo should forget everything")
+	}
+
+	// Nothing is recorded when undo is disabled
+	defer lib.SetUndoMax(50)
+	lib.SetUndoMax(0)
+	testErrorNil(t, jd.AddUser("UserX"), "AddUser")
+	if jd.CanUndo() {
+		t.Error("Nothing should be recorded when undo is disabled")
+	}
+}
@@ -0,0 +1,196 @@
+package libtest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd2/JsonParser4go/parser"
+	"stuartdd.com/lib"
+)
+
+func TestWatcherCheck(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, remoteFileName)
+	resetTestFile(fileName, []byte(remoteContent1))
+	changed := make([]string, 0)
+	w := lib.NewFileWatcher(0, func(p string) { changed = append(changed, p) })
+	w.Watch(fileName, "")
+	if len(w.GetPaths()) != 1 || len(w.Check()) != 0 {
+		t.Fatalf("Nothing should have changed %v %v", w.GetPaths(), changed)
+	}
+
+	// Touch without a change to the content is not reported
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(fileName, later, later)
+	if len(w.Check()) != 0 {
+		t.Error("A new time without new content should not be reported")
+	}
+
+	resetTestFile(fileName, []byte(remoteContent1+" "))
+	if len(w.Check()) != 1 || len(changed) != 1 || changed[0] != fileName {
+		t.Errorf("The change should be reported once %v", changed)
+	}
+	if len(w.Check()) != 0 {
+		t.Error("A change should only be reported once")
+	}
+	os.Remove(fileName)
+	if len(w.Check()) != 1 {
+		t.Error("Removing the file should be reported")
+	}
+
+	// The changes have been seen. Accept them so they can be replaced.
+	w.Accept(fileName)
+	err := w.Update(fileName, func() error {
+		resetTestFile(fileName, []byte(remoteContent2))
+		return nil
+	})
+	testErrorNil(t, err, "Update")
+	if len(w.Check()) != 0 {
+		t.Error("Changes made in Update should not be reported")
+	}
+}
+
+func TestWatcherUpdateChanged(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, remoteFileName)
+	resetTestFile(fileName, []byte(remoteContent1))
+	changed := make([]string, 0)
+	w := lib.NewFileWatcher(0, func(p string) { changed = append(changed, p) })
+	w.Watch(fileName)
+
+	resetTestFile(fileName, []byte(remoteContent3))
+	called := false
+	update := func() error {
+		called = true
+		resetTestFile(fileName, []byte(remoteContent2))
+		return nil
+	}
+	err := w.Update(fileName, update)
+	if !errors.Is(err, lib.ErrFileChanged) {
+		t.Errorf("Update of a changed file should return ErrFileChanged not %v", err)
+	}
+	if called || len(changed) != 1 || changed[0] != fileName {
+		t.Errorf("The change should be reported and the file not updated %t %v", called, changed)
+	}
+	if !errors.Is(w.Update(fileName, update), lib.ErrFileChanged) || called {
+		t.Error("The file should not be updated until the change is accepted")
+	}
+	if dat, _ := ioutil.ReadFile(fileName); string(dat) != remoteContent3 {
+		t.Errorf("The changed file should be kept '%s'", dat)
+	}
+
+	w.Accept(fileName)
+	testErrorNil(t, w.Update(fileName, update), "Update after Accept")
+	if !called || len(w.Check()) != 0 || len(changed) != 1 {
+		t.Errorf("The accepted change should be replaced and not reported again %t %v", called, changed)
+	}
+}
+
+func TestWatcherFileData(t *testing.T) {
+	dir := t.TempDir()
+	bdir := filepath.Join(dir, "backup")
+	os.Mkdir(bdir, 0755)
+	fileName := filepath.Join(dir, remoteFileName)
+	resetTestFile(fileName, []byte(remoteContent1))
+	bfd := lib.NewBackupFileDef(bdir, string(os.PathSeparator), "BU-", "%d-%h%m%s", ".bak", 10)
+	testErrorNil(t, bfd.Init("backup"), "Init backup")
+	fd, err := lib.NewFileData(fileName, bfd, "", "")
+	testErrorNil(t, err, "NewFileData")
+	if p := fd.GetWatchPaths(); len(p) != 2 || p[0] != fileName || p[1] != bdir {
+		t.Fatalf("Should watch the file and the backup directory %v", p)
+	}
+	if fd.GetLatestBackup() != "" {
+		t.Errorf("There should be no backup '%s'", fd.GetLatestBackup())
+	}
+
+	changed := make([]string, 0)
+	w := lib.NewFileWatcher(0, func(p string) { changed = append(changed, p) })
+	fd.SetWatcher(w)
+	w.Watch(fd.GetWatchPaths()...)
+	fd.SetContent([]byte(remoteContent2))
+	testErrorNil(t, fd.StoreContentUnEncrypted(storeCallMeBack), "Store")
+	if len(w.Check()) != 0 || len(changed) != 0 {
+		t.Errorf("Our own save should not be reported %v", changed)
+	}
+	if !strings.HasPrefix(filepath.Base(fd.GetLatestBackup()), "BU-") {
+		t.Errorf("Latest backup should be found '%s'", fd.GetLatestBackup())
+	}
+
+	// Changed by another program then saved by us. The change is reported and the save is refused.
+	resetTestFile(fileName, []byte(remoteContent3+" "))
+	resetTestFile(filepath.Join(bdir, "other.txt"), []byte("x"))
+	fd.SetContent([]byte(remoteContent1))
+	err = fd.StoreContentUnEncrypted(storeCallMeBack)
+	if !errors.Is(err, lib.ErrFileChanged) {
+		t.Errorf("Store after change should return ErrFileChanged not %v", err)
+	}
+	if len(changed) != 2 || changed[0] != fileName || changed[1] != bdir {
+		t.Errorf("The file and the backup directory should be reported %v", changed)
+	}
+	w.Accept(fileName)
+	testErrorNil(t, fd.StoreContentUnEncrypted(storeCallMeBack), "Store after Accept")
+	if len(w.Check()) != 0 || len(changed) != 2 {
+		t.Errorf("Our own save after Accept should not be reported %v", changed)
+	}
+
+	// The backup directory change has not been accepted. A save of the file does not accept it.
+	called := false
+	err = w.Update(bdir, func() error { called = true; return nil })
+	if !errors.Is(err, lib.ErrFileChanged) || called {
+		t.Errorf("The backup directory change should still be pending after a save of the file %v", err)
+	}
+	w.Accept(bdir)
+	testErrorNil(t, w.Update(bdir, func() error { called = true; return nil }), "Update after Accept of the backup directory")
+
+	// The stored content is decrypted with the key
+	testErrorNil(t, fd.StoreContentEncrypted(password, storeCallMeBack), "Store encrypted")
+	dat, err := fd.ReadStoredContent(fileName)
+	testErrorNil(t, err, "ReadStoredContent")
+	if string(dat) != remoteContent1 {
+		t.Errorf("Stored content should be decrypted '%s'", dat)
+	}
+}
+
+func TestCompareWith(t *testing.T) {
+	here := `{"groups":{"UserA":{
+		"pwHints":{"Hint":{"notes":"here","pre":"p"}},
+		"assets":{"Current":{"transactions":[{"date":"2022-01-01","ref":"Initial","type":"iv","val":10}]}}}},
+		"timeStamp":"Fri Jul 30 21:25:10 BST 2021"}`
+	other := `{"groups":{
+		"UserA":{
+			"pwHints":{"Hint":{"notes":"other","post":"p"}},
+			"assets":{"Current":{"transactions":[
+				{"date":"2022-01-01","ref":"Initial","type":"iv","val":10},
+				{"date":"2022-01-02","ref":"Shop","type":"db","val":5}]}}},
+		"UserB":{"pwHints":{"HintB":{"notes":"b"}}}},
+		"timeStamp":"Fri Jul 30 21:25:11 BST 2021"}`
+	jd, err := lib.NewJsonData([]byte(here), func(string, *parser.Path, error) {})
+	testErrorNil(t, err, "NewJsonData")
+	results, err := jd.CompareWith([]byte(other))
+	testErrorNil(t, err, "CompareWith")
+	list := make([]string, 0)
+	for _, r := range results {
+		list = append(list, r.String())
+	}
+	expected := []string{
+		"Changed: UserA|assets|Current|transactions (0 entries only here, 1 only in other)",
+		"Changed: UserA|pwHints|Hint|notes",
+		"Only in other: UserA|pwHints|Hint|post",
+		"Only here: UserA|pwHints|Hint|pre",
+		"Only in other: UserB",
+	}
+	if strings.Join(list, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Differences are wrong:\n%s", strings.Join(list, "\n"))
+	}
+	results, _ = jd.CompareWith([]byte(here))
+	if len(results) != 0 {
+		t.Errorf("The same data should have no differences %v", results)
+	}
+	_, err = jd.CompareWith([]byte(`{"x":1}`))
+	testError(t, err, "could not be read")
+}
@@ -18,12 +18,16 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -31,6 +35,7 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/data/binding"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
@@ -51,6 +56,8 @@ const (
 	MAIN_THREAD_RELOAD_TREE
 	MAIN_THREAD_RESELECT
 	MAIN_THREAD_RE_MENU
+	MAIN_THREAD_LOCK
+	MAIN_THREAD_FILE_CHANGED
 
 	ADD_TYPE_USER = iota
 	ADD_TYPE_HINT
@@ -70,12 +77,16 @@ const (
 
 	fallbackPreferencesFile = "config.json"
 	fallbackDataFile        = "data.json"
+	recurringListMax        = 20 // Due recurring transactions listed in the confirm dialog
+	compareListMax          = 30 // Differences listed in the compare dialog
 	uLine                   = "------------------------------------------------------------------------------------"
 )
 
 var (
 	window                   fyne.Window
 	searchWindow             *gui.SearchDataWindow
+	auditWindow              *gui.AuditWindow
+	importPreviewWindow      *gui.ImportPreviewWindow
 	logData                  *gui.LogData
 	fileData                 *lib.FileData
 	jsonData                 *lib.JsonData
@@ -85,6 +96,8 @@ var (
 	fullScreenShortcutButton *gui.MyButton
 	editModeShortcutButton   *gui.MyButton
 	statusDisplay            *gui.StatusDisplay
+	changeBanner             *gui.ChangeBanner
+	fileWatcher              *lib.FileWatcher
 	splitContainer           *container.Split // So we can save the divider position to preferences.
 	splitContainerOffset     float64          = -1
 	splitContainerOffsetPref float64          = -1
@@ -97,8 +110,15 @@ var (
 	hasDataChanges     = false
 	releaseTheBeast    = make(chan int, 1)
 	dataIsNotLoadedYet = true
+	checkRecurring     = false                 // Set on load. Due recurring transactions are checked when the tree is displayed
+	isLocked           = int32(0)              // 1 while locked. Use dataIsLocked. Read by the idle thread
+	lockQueuedAt       = int64(0)              // UnixNano when the idle thread queued MAIN_THREAD_LOCK. 0 if not queued
+	lastActivity       = time.Now().UnixNano() // Use noteActivity and lastActivityTime. Read by the idle thread
+	changedPaths       = make([]string, 0)     // Reported by the file watcher thread. Use takeChangedPaths
+	clipboardCopyCount = int64(0)              // Incremented for every copy. Read by the clear thread
+	changedPathsMu     = sync.Mutex{}
 
-	importFileFilter = []string{".csv", ".csvt"}
+	importFileFilter = []string{".csv", ".csvt", ".ofx", ".qfx", ".qif"}
 
 	nameDataPrefName          = parser.NewDotPath("data")
 	clipboardPrefName         = parser.NewDotPath("clipboard")
@@ -110,11 +130,16 @@ var (
 	errorDialogTimePrefName   = parser.NewDotPath("dialog.errorTimeOutMS")
 	getUrlPrefName            = parser.NewDotPath("file.getDataUrl")
 	postUrlPrefName           = parser.NewDotPath("file.postDataUrl")
+	remotePrefName            = parser.NewDotPath("file.remote")
+	watchSecondsPrefName      = parser.NewDotPath("file.watchSeconds")
 	importPathPrefName        = parser.NewDotPath("import.path")
 	importFilterPrefName      = parser.NewDotPath("import.filter")
 	importCsvSkipHPrefName    = parser.NewDotPath("import.csvSkipHeader")
 	importCsvDateFmtPrefName  = parser.NewDotPath("import.csvDateFormat")
 	importCsvColNamesPrefName = parser.NewDotPath("import.csvColumns")
+	importQifOrderPrefName    = parser.NewDotPath("import.qifDateOrder")
+	exportPathPrefName        = parser.NewDotPath("export.path")
+	categoryRulesPrefName     = parser.NewDotPath("import.categoryRules")
 	themeVarPrefName          = parser.NewDotPath("theme")
 	logFileNamePrefName       = parser.NewDotPath("log.fileName")
 	logActivePrefName         = parser.NewDotPath("log.active")
@@ -125,6 +150,24 @@ var (
 	screenSplitPrefName       = parser.NewDotPath("screen.split")
 	searchLastGoodPrefName    = parser.NewDotPath("search.lastGoodList")
 	searchCasePrefName        = parser.NewDotPath("search.case")
+	serveDirPrefName          = parser.NewDotPath("serve.dir")
+	serveAddrPrefName         = parser.NewDotPath("serve.addr")
+	serveTokenPrefName        = parser.NewDotPath("serve.token")
+	serveCertFilePrefName     = parser.NewDotPath("serve.certFile")
+	serveKeyFilePrefName      = parser.NewDotPath("serve.keyFile")
+	serveHistoryMaxPrefName   = parser.NewDotPath("serve.historyMax")
+	serveLogFileNamePrefName  = parser.NewDotPath("serve.logFileName")
+	serveLogActivePrefName    = parser.NewDotPath("serve.logActive")
+	lockAfterPrefName         = parser.NewDotPath("security.lockAfterSeconds")
+	clipboardClearPrefName    = parser.NewDotPath("security.clipboardClearSeconds")
+	auditIgnorePrefName       = parser.NewDotPath("audit.ignore")
+	auditMinBitsPrefName      = parser.NewDotPath("audit.minBits")
+	auditMaxAgePrefName       = parser.NewDotPath("audit.maxAgeDays")
+	historyMaxPrefName        = parser.NewDotPath("history.max")
+	undoMaxPrefName           = parser.NewDotPath("undo.max")
+
+	undoShortcut = &desktop.CustomShortcut{KeyName: fyne.KeyZ, Modifier: desktop.ControlModifier}
+	redoShortcut = &desktop.CustomShortcut{KeyName: fyne.KeyY, Modifier: desktop.ControlModifier}
 )
 
 func abortWithUsage(message string) {
@@ -140,6 +183,12 @@ func abortWithUsage(message string) {
 	fmt.Println("  For example:")
 	fmt.Printf("     %s <configfile> create\n", os.Args[0])
 	fmt.Printf("  This will create the file defined in the <configfile> '%s' value.\n", dataFilePrefName.String())
+	fmt.Println("  To change the password of the data file use the 'passwd' option.")
+	fmt.Printf("     %s <configfile> passwd\n", os.Args[0])
+	fmt.Println("  To serve a directory of data files for remote access (file.getDataUrl and file.postDataUrl) use the 'serve' option.")
+	fmt.Printf("     %s <configfile> serve\n", os.Args[0])
+	fmt.Println("  The 'serve' values in the <configfile> define the directory, address, token, TLS files and history.")
+	dataCommandUsage()
 	fmt.Println(uLine)
 	os.Exit(1)
 }
@@ -158,6 +207,28 @@ func initBackupPref() (*lib.BackupFileDef, error) {
 	return bup, nil
 }
 
+/**
+Configure the HTTP client used for remote data from the file.remote preferences.
+*/
+func initRemoteClient() error {
+	rc, err := lib.NewRemoteClient(&lib.RemoteConfig{
+		Timeout:  time.Duration(preferences.GetInt64WithFallback(remotePrefName.StringAppend("timeoutSeconds"), 30)) * time.Second,
+		Retries:  int(preferences.GetInt64WithFallback(remotePrefName.StringAppend("retries"), 2)),
+		Backoff:  time.Duration(preferences.GetInt64WithFallback(remotePrefName.StringAppend("backoffMS"), 500)) * time.Millisecond,
+		Token:    preferences.GetStringWithFallback(remotePrefName.StringAppend("token"), ""),
+		User:     preferences.GetStringWithFallback(remotePrefName.StringAppend("user"), ""),
+		Password: preferences.GetStringWithFallback(remotePrefName.StringAppend("password"), ""),
+		CaFile:   preferences.GetStringWithFallback(remotePrefName.StringAppend("caFile"), ""),
+		CertFile: preferences.GetStringWithFallback(remotePrefName.StringAppend("certFile"), ""),
+		KeyFile:  preferences.GetStringWithFallback(remotePrefName.StringAppend("keyFile"), ""),
+	})
+	if err != nil {
+		return err
+	}
+	lib.SetRemoteClient(rc)
+	return nil
+}
+
 func main() {
 	var prefFile string
 	if len(os.Args) < 2 {
@@ -170,11 +241,17 @@ func main() {
 		abortWithUsage(fmt.Sprintf("Failed to load configuration file '%s'", prefFile))
 	}
 	preferences = p
+	lib.SetHistoryMax(int(p.GetInt64WithFallback(historyMaxPrefName, 10)))
+	lib.SetUndoMax(int(p.GetInt64WithFallback(undoMaxPrefName, 50)))
 
 	backupFileDef, err := initBackupPref()
 	if err != nil {
 		abortWithUsage(fmt.Sprintf("Failed to load configuration file '%s'.\nError:%s", prefFile, err.Error()))
 	}
+	err = initRemoteClient()
+	if err != nil {
+		abortWithUsage(fmt.Sprintf("Failed to load configuration file '%s'.\nError:%s", prefFile, err.Error()))
+	}
 	primaryFileName := p.GetStringWithFallback(dataFilePrefName, fallbackDataFile)
 	getDataUrl := p.GetStringWithFallback(getUrlPrefName, "")
 	postDataUrl := p.GetStringWithFallback(postUrlPrefName, "")
@@ -183,6 +260,18 @@ func main() {
 	//
 	if len(os.Args) > 2 {
 		createFile := oneOrTheOther(postDataUrl == "", primaryFileName, postDataUrl+"/"+primaryFileName)
+		cmdArgs, err := parsePasswordFdArg(os.Args[3:])
+		if err != nil {
+			abortWithUsage(err.Error())
+		}
+		if isDataCommand(os.Args[2]) {
+			err := dataCommand(os.Args[2], cmdArgs, primaryFileName, backupFileDef, getDataUrl, postDataUrl)
+			if err != nil {
+				fmt.Printf("----> Action aborted. %s\n", err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
 		switch os.Args[2] {
 		case "recover":
 			l, _ := backupFileDef.ListFiles()
@@ -232,7 +321,7 @@ func main() {
 			data := lib.CreateEmptyJsonData()
 			var err error
 			if postDataUrl != "" {
-				_, err = parser.PostJsonBytes(fmt.Sprintf("%s/%s", postDataUrl, primaryFileName), data)
+				err = lib.PostRemote(fmt.Sprintf("%s/%s", postDataUrl, primaryFileName), data)
 			} else {
 				err = ioutil.WriteFile(primaryFileName, data, 0644)
 			}
@@ -242,6 +331,20 @@ func main() {
 				fmt.Printf("-> File %s has been created\n", createFile)
 			}
 			os.Exit(0)
+		case "serve":
+			err := serveCommand(p)
+			if err != nil {
+				fmt.Printf("----> Server stopped. %s\n", err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "passwd":
+			err := passwdCommand(primaryFileName, backupFileDef, getDataUrl, postDataUrl)
+			if err != nil {
+				fmt.Printf("----> Action aborted. %s\n", err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
 		default:
 			fmt.Println(uLine)
 			fmt.Printf("-> The line you wanted was %s %s create\n", os.Args[0], os.Args[1])
@@ -274,10 +377,35 @@ func main() {
 	}))
 	window.SetIcon(theme2.IconEnctest)
 	window.SetCloseIntercept(shouldClose)
+	if dc, ok := window.Canvas().(desktop.Canvas); ok {
+		dc.SetOnKeyDown(func(ke *fyne.KeyEvent) {
+			noteActivity()
+		})
+		dc.SetOnKeyUp(func(ke *fyne.KeyEvent) {
+			noteActivity()
+		})
+	}
+	window.Canvas().SetOnTypedRune(func(r rune) {
+		noteActivity()
+	})
+	gui.SetOnActivity(noteActivity)
+	gui.SetOnCopy(clearClipboardLater)
+	window.Canvas().AddShortcut(undoShortcut, func(s fyne.Shortcut) {
+		undoRedo(false)
+	})
+	window.Canvas().AddShortcut(redoShortcut, func(s fyne.Shortcut) {
+		undoRedo(true)
+	})
+	window.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyZ, Modifier: desktop.ControlModifier | desktop.ShiftModifier}, func(s fyne.Shortcut) {
+		undoRedo(true)
+	})
 
 	window.SetMaster()
 
 	statusDisplay = gui.NewStatusDisplay("Select an item from the list above", "Last Updated: Unknown", "Hint")
+	statusDisplay.SetOnActivity(noteActivity)
+	changeBanner = gui.NewChangeBanner()
+	fileWatcher = lib.NewFileWatcher(time.Duration(preferences.GetInt64WithFallback(watchSecondsPrefName, 5))*time.Second, fileChangedExternally)
 	wp := gui.GetWelcomePage(*preferences, log)
 	title := container.NewHBox()
 	title.Objects = []fyne.CanvasObject{wp.CntlFunc(window, *wp, nil, preferences, statusDisplay, log)}
@@ -293,6 +421,7 @@ func main() {
 		This updates the contentRHS which is the RHS page for editing data
 	*/
 	setPageRHSFunc := func(detailPage gui.DetailPage) {
+		noteActivity()
 		currentSelPath = detailPage.SelectedPath
 		currentUserName = detailPage.User
 		if searchWindow != nil {
@@ -325,6 +454,11 @@ func main() {
 
 			taskForTheBeast := <-releaseTheBeast
 
+			// While locked there is no data so only a LOAD is allowed. Unlock will RELOAD_TREE.
+			if dataIsLocked() && taskForTheBeast != MAIN_THREAD_LOAD {
+				log(fmt.Sprintf("Data is locked. Task %d ignored", taskForTheBeast))
+				continue
+			}
 			switch taskForTheBeast {
 			case MAIN_THREAD_LOAD:
 				dataIsNotLoadedYet = true
@@ -333,10 +467,25 @@ func main() {
 					timedNotification(5000, "Log file error", logData.GetErr().Error())
 				}
 				// Load the file and decrypt it if required
+				// The watcher is stopped until the data is loaded. Changes made while loading are reported when it is started.
+				fileWatcher.Stop()
+				changeBanner.Hide()
+				takeChangedPaths()
 				fd, err := lib.NewFileData(primaryFileName, backupFileDef, getDataUrl, postDataUrl)
-				if err != nil {
-					abortWithUsage(fmt.Sprintf("Failed to load data file %s. Error: %s", primaryFileName, err.Error()))
+				for err != nil {
+					// Retry, load the latest backup or exit.
+					log(fmt.Sprintf("Load Error:'%s'. %s", primaryFileName, err.Error()))
+					backup := loadFailedDialog(primaryFileName, err, backupFileDef)
+					if backup != "" {
+						log(fmt.Sprintf("Load Backup:'%s'", backup))
+						backupFileDef.SetTempSource(backup)
+					}
+					fd, err = lib.NewFileData(primaryFileName, backupFileDef, getDataUrl, postDataUrl)
+					backupFileDef.SetTempSource("")
 				}
+				fd.SetVerifier(lib.VerifyJsonData)
+				fd.SetWatcher(fileWatcher)
+				fileWatcher.Watch(fd.GetWatchPaths()...)
 				if getDataUrl != "" {
 					log(fmt.Sprintf("Remote File:'%s/%s'", getDataUrl, primaryFileName))
 				} else {
@@ -369,8 +518,11 @@ func main() {
 				fileData = fd
 				jsonData = dr
 				dataIsNotLoadedYet = false
+				fileWatcher.Start()
+				noteActivity()
 				statusDisplay.SetUpdated(jsonData.GetTimeStampString())
 				log(fmt.Sprintf("Data Parsed OK: File:'%s' DateTime:'%s'", primaryFileName, jsonData.GetTimeStampString()))
+				checkRecurring = true
 				// Follow on action to rebuild the Tree and re-display it
 				futureReleaseTheBeast(0, MAIN_THREAD_RELOAD_TREE)
 			case MAIN_THREAD_RELOAD_TREE:
@@ -388,7 +540,11 @@ func main() {
 				}
 				splitContainer = container.NewHSplit(container.NewBorder(makeSearchLHS(setPageRHSFunc), nil, nil, nil, navTreeLHS), layoutRHS)
 				splitContainer.SetOffset(splitContainerOffset)
-				window.SetContent(container.NewBorder(buttonBar, statusDisplay.StatusContainer, nil, nil, splitContainer))
+				window.SetContent(container.NewBorder(container.NewVBox(buttonBar, changeBanner.Container), statusDisplay.StatusContainer, nil, nil, splitContainer))
+				if checkRecurring {
+					checkRecurring = false
+					checkRecurringTransactions()
+				}
 				futureReleaseTheBeast(0, MAIN_THREAD_RE_MENU)
 			case MAIN_THREAD_RESELECT:
 				log(fmt.Sprintf("Re-display RHS. Sel:'%s'", currentSelPath))
@@ -399,11 +555,18 @@ func main() {
 				log("Refresh menu and buttons")
 				updateButtonBar()
 				window.SetMainMenu(makeMenus())
+			case MAIN_THREAD_LOCK:
+				lockData()
+			case MAIN_THREAD_FILE_CHANGED:
+				for _, path := range takeChangedPaths() {
+					showFileChanged(path)
+				}
 			}
 		}
 	}()
 
 	futureReleaseTheBeast(500, MAIN_THREAD_LOAD)
+	go lockWhenIdle(preferences.GetInt64WithFallback(lockAfterPrefName, 0))
 	preferences.AddChangeListener(dataPreferencesChanged, "data.")
 	setFullScreen(preferences.GetBoolWithFallback(screenFullPrefName, false), false)
 	log("ShowAndRun")
@@ -544,7 +707,7 @@ func makeButtonBar() *fyne.Container {
 		bb.Add(widget.NewLabel("Copy:"))
 		for n, v := range clipboardMap {
 			bb.Add(gui.NewMyIconButton(n, theme.ContentCopyIcon(), func(a, b string) {
-				window.Clipboard().SetContent(b)
+				gui.CopyToClipboard(window, b)
 				timedNotification(preferences.GetInt64WithFallback(copyDialogTimePrefName, 1500), "Copied to clipboard", b)
 			}, n, v, statusDisplay, fmt.Sprintf("Copy '%s' to clipboard", v)))
 		}
@@ -596,6 +759,9 @@ func makeMenus() *fyne.MainMenu {
 		fyne.NewMenuItem(oneOrTheOther(preferences.GetBoolWithFallback(screenFullPrefName, false), "View Windowed", "View Full Screen"), flipFullScreen),
 		fyne.NewMenuItem(oneOrTheOther(gui.EditMode, "Present Data", "Edit Data"), flipEditMode),
 		themeMenuItem,
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Password Audit", passwordAudit),
+		fyne.NewMenuItem("Category Report", categoryReport),
 	)
 
 	m := make([]*fyne.MenuItem, 0)
@@ -635,6 +801,7 @@ func makeMenus() *fyne.MainMenu {
 	})
 
 	saveAsItem := fyne.NewMenuItem("Undefined", func() {})
+	fileMenu := fyne.NewMenu("File", saveItem)
 	if fileData != nil {
 		if fileData.IsEncrypted() {
 			saveAsItem = fyne.NewMenuItem("Save Un-Encrypted", func() {
@@ -645,11 +812,37 @@ func makeMenus() *fyne.MainMenu {
 				commitAndSaveData(SAVE_ENCRYPTED, false)
 			})
 		}
+		fileMenu.Items = append(fileMenu.Items, saveAsItem)
+		if fileData.HasEncData() {
+			fileMenu.Items = append(fileMenu.Items, fyne.NewMenuItem("Change Password", changePassword))
+		}
 	}
+	fileMenu.Items = append(fileMenu.Items, fyne.NewMenuItemSeparator())
+
+	undoItem := fyne.NewMenuItem("Undo", func() {
+		undoRedo(false)
+	})
+	undoItem.Shortcut = undoShortcut
+	redoItem := fyne.NewMenuItem("Redo", func() {
+		undoRedo(true)
+	})
+	redoItem.Shortcut = redoShortcut
+	if jsonData != nil && jsonData.CanUndo() {
+		undoItem.Label = fmt.Sprintf("Undo %s", jsonData.UndoDesc())
+	} else {
+		undoItem.Disabled = true
+	}
+	if jsonData != nil && jsonData.CanRedo() {
+		redoItem.Label = fmt.Sprintf("Redo %s", jsonData.RedoDesc())
+	} else {
+		redoItem.Disabled = true
+	}
+	editMenu := fyne.NewMenu("Edit", undoItem, redoItem)
 
 	mainMenu := fyne.NewMainMenu(
 		// a quit item will be appended to our first menu
-		fyne.NewMenu("File", saveItem, saveAsItem, fyne.NewMenuItemSeparator()),
+		fileMenu,
+		editMenu,
 		newItem,
 		viewItem,
 		helpMenu,
@@ -762,6 +955,7 @@ func dataMapUpdated(desc string, dataPath *parser.Path, err error) {
 This is called when a button is pressed of the RH page
 */
 func controlActionFunction(action string, dataPath *parser.Path, extra string) {
+	noteActivity()
 	log(fmt.Sprintf("Action:%s. Path:'%s'. Extra:'%s'", action, dataPath, extra))
 	switch action {
 	case gui.ACTION_REMOVE_CLEAN:
@@ -784,6 +978,14 @@ func controlActionFunction(action string, dataPath *parser.Path, extra string) {
 		updateTransactionValue(dataPath, extra)
 	case gui.ACTION_IMPORT_TRANSACTION:
 		importTransactions(dataPath, extra)
+	case gui.ACTION_EXPORT_TRANSACTION:
+		exportTransactions(dataPath, extra)
+	case gui.ACTION_EXCHANGE_RATES:
+		exchangeRates(dataPath)
+	case gui.ACTION_ADD_RECURRING:
+		addRecurring(dataPath, extra)
+	case gui.ACTION_REMOVE_RECURRING:
+		removeRecurring(dataPath, extra)
 	case gui.ACTION_ADD_TRANSACTION:
 		addTransactionValue(dataPath, extra)
 	case gui.ACTION_CLONE_FULL:
@@ -846,12 +1048,14 @@ func addTransactionValue(dataPath *parser.Path, extra string) {
 		func() {}, // On Cancel
 		func(m *gui.InputData) { // On OK
 			dt, _ := lib.ParseDateString(m.GetString(lib.IdTxDate, lib.FormatDateTime(time.Now())))
+			amount, _ := lib.ParseMoney(m.GetString(lib.IdTxVal, "0.1"))
 			err := jsonData.AddTransaction(
 				dataPath,
 				dt,
 				m.GetString(lib.IdTxRef, "ref"),
-				m.GetFloat(lib.IdTxVal, 0.1),
-				lib.TransactionTypeEnum(m.GetString(lib.IdTxType, string(lib.TX_TYPE_DEB))))
+				amount,
+				lib.TransactionTypeEnum(m.GetString(lib.IdTxType, string(lib.TX_TYPE_DEB))),
+				m.GetString(lib.IdTxCategory, ""))
 			if err != nil {
 				timedError(err.Error())
 			}
@@ -875,13 +1079,13 @@ func addTransactionValue(dataPath *parser.Path, extra string) {
 	})
 
 	d.Add(lib.IdTxVal, "Amount", "0.1", func(s string) (string, error) {
-		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
-		if v <= 0.0 {
-			return "", fmt.Errorf("cannot be 0.0 or less")
-		}
+		v, err := lib.ParseMoney(s)
 		if err != nil {
 			return "", fmt.Errorf("is not a valid amount")
 		}
+		if v <= 0 {
+			return "", fmt.Errorf("cannot be 0.0 or less")
+		}
 		return "", nil
 	})
 
@@ -889,61 +1093,184 @@ func addTransactionValue(dataPath *parser.Path, extra string) {
 		return "", nil
 	})
 
+	d.Add(lib.IdTxCategory, "Category", "", func(s string) (string, error) {
+		return "", nil
+	})
+
 	d.Show(window)
 	go d.Validate()
 }
 
-func importCSVTransactions(dataPath *parser.Path, fileName string) (int, error) {
-	skipHeader := preferences.GetBoolWithFallback(importCsvSkipHPrefName, true)
-	dateFmt := preferences.GetStringWithFallback(importCsvDateFmtPrefName, lib.TIME_FORMAT_CSV)
-	dataMapList := preferences.GetStringListWithFallback(importCsvColNamesPrefName, nil)
-	if dataMapList == nil {
-		preferences.PutStringList(importCsvColNamesPrefName, lib.IMPORT_CSV_COLUM_NAMES, false)
-		dataMapList = preferences.GetStringListWithFallback(importCsvColNamesPrefName, nil)
+/**
+Get a named import profile from the preferences.
+Undefined values are taken from the original import preferences (csvDateFormat, csvSkipHeader, csvColumns).
+*/
+func getImportProfile(name string) *lib.ImportProfile {
+	fb := lib.NewImportProfile(name)
+	fb.SkipHeader = preferences.GetBoolWithFallback(importCsvSkipHPrefName, fb.SkipHeader)
+	fb.DateFormat = preferences.GetStringWithFallback(importCsvDateFmtPrefName, fb.DateFormat)
+	fb.Columns = preferences.GetStringListWithFallback(importCsvColNamesPrefName, fb.Columns)
+	return gui.GetImportProfile(preferences, name, fb)
+}
+
+/**
+Find the transactions node for an account.
+*/
+func findAccountTransactions(dataPath *parser.Path) (parser.NodeC, error) {
+	n, err := jsonData.FindNodeForUserDataPath(dataPath)
+	if err != nil {
+		return nil, err
 	}
-	n, _ := jsonData.FindNodeForUserDataPath(dataPath)
 	if n.IsContainer() {
 		t := n.(parser.NodeC).GetNodeWithName(lib.IdTxTransactions)
-		if t == nil {
-			return 0, fmt.Errorf("data error: %s node missing for %s.\nPlease add an account transactions node", lib.IdTxTransactions, n.GetName())
+		if t == nil || !t.IsContainer() {
+			return nil, fmt.Errorf("data error: %s node missing for %s.\nPlease add an account transactions node", lib.IdTxTransactions, n.GetName())
 		}
-		return lib.ImportCsvData(t.(parser.NodeC), fileName, skipHeader, dateFmt, dataMapList)
+		return t.(parser.NodeC), nil
 	}
-	return 0, fmt.Errorf("data error: %s node is not a container node", n.GetName())
+	return nil, fmt.Errorf("data error: %s node is not a container node", n.GetName())
+}
 
+/**
+Read the transactions in a file and show them in the import preview window.
+Transactions are categorised using the category rules in the preferences.
+Duplicates and probable duplicates are marked. Nothing is changed until Import is pressed.
+The import can be undone.
+*/
+func previewImport(dataPath *parser.Path, name, fileType string, read func() ([]*parser.JsonObject, error)) {
+	t, err := findAccountTransactions(dataPath)
+	if err != nil {
+		timedError(err.Error())
+		return
+	}
+	rules, err := lib.NewCategoryRules(preferences.GetStringListWithFallback(categoryRulesPrefName, []string{}))
+	if err != nil {
+		timedError(fmt.Sprintf("Invalid preference '%s'\nError: %s", categoryRulesPrefName, err))
+		return
+	}
+	nodes, err := read()
+	if err != nil {
+		timedError(fmt.Sprintf("Failed to import %s file %s\nError: %s", fileType, name, err))
+		return
+	}
+	rules.Apply(nodes)
+	if importPreviewWindow != nil {
+		importPreviewWindow.Close()
+	}
+	importPreviewWindow = gui.NewImportPreviewWindow(func(candidates []*lib.ImportCandidate) {
+		count := 0
+		err := jsonData.RecordUndo(fmt.Sprintf("Import '%s'", name), dataPath, func() error {
+			t, err := findAccountTransactions(dataPath) // Data may have changed (undo) since the preview
+			if err != nil {
+				return err
+			}
+			count, err = lib.ApplyImport(t, candidates)
+			return err
+		})
+		importTransactionsDone(dataPath, name, fileType, count, lib.CountSkippedDuplicates(candidates), err)
+	})
+	importPreviewWindow.Show(800, 500, fmt.Sprintf("Import %s file '%s' into '%s'", fileType, name, dataPath.StringLast()), lib.PreviewImport(t, nodes))
 }
 
+/**
+Select the file to import.
+OFX, QFX and QIF files define their own format so are previewed directly.
+For CSV files select the import profile for the account.
+The profile is remembered for the account and saved in the preferences so it can be edited.
+*/
 func importTransactions(dataPath *parser.Path, extra string) {
-	t := lib.GetNameFromNameMap(lib.IdTxTransactions, "Transaction")
 	_, err := parser.Find(jsonData.GetUserRoot(), dataPath)
 	if err != nil {
 		timedError(fmt.Sprintf("canot find %s", dataPath))
 		return
 	}
+	selectImportFile(func(fileName, name string) {
+		if lib.IsOfxFile(fileName) {
+			previewImport(dataPath, name, "OFX", func() ([]*parser.JsonObject, error) {
+				return lib.OfxTransactionNodes(fileName)
+			})
+			return
+		}
+		if lib.IsQifFile(fileName) {
+			previewImport(dataPath, name, "QIF", func() ([]*parser.JsonObject, error) {
+				return lib.QifTransactionNodes(fileName, preferences.GetStringWithFallback(importQifOrderPrefName, lib.QifDateOrderDMY))
+			})
+			return
+		}
+		user := dataPath.StringFirst()
+		account := dataPath.StringLast()
+		gui.NewModalImportProfileDialog(window, fmt.Sprintf("Import '%s' into '%s'", name, account), gui.GetImportProfileNames(preferences), gui.GetAccountImportProfileName(preferences, user, account), func(pName string) string {
+			return getImportProfile(pName).String()
+		}, func(ok bool, pName string) {
+			if ok {
+				profile := getImportProfile(pName)
+				err := profile.Validate()
+				if err != nil {
+					timedError(err.Error())
+					return
+				}
+				gui.PutImportProfile(preferences, profile)
+				gui.PutAccountImportProfileName(preferences, user, account, pName)
+				previewImport(dataPath, name, "CSV", func() ([]*parser.JsonObject, error) {
+					return lib.CsvTransactionNodes(fileName, profile)
+				})
+			}
+		})
+	})
+}
+
+func importTransactionsDone(dataPath *parser.Path, name, fileType string, count, duplicates int, err error) {
+	if err != nil {
+		timedError(fmt.Sprintf("Failed to import %s file %s\nError: %s", fileType, name, err))
+		return
+	}
+	s := fmt.Sprintf("%d %s(s) imported from file %s", count, lib.GetNameFromNameMap(lib.IdTxTransactions, "Transaction"), name)
+	if duplicates > 0 {
+		s = fmt.Sprintf("%s (%d duplicates skipped)", s, duplicates)
+	}
+	if count == 0 {
+		timedNotification(5000, "Import", s)
+		return
+	}
+	dataMapUpdated(s, dataPath, nil)
+	timedNotification(5000, "Successful import", s)
+}
+
+/**
+The file filter from the preferences. OFX, QFX and QIF are added if the preference was defined before they were supported.
+*/
+func importFilter() []string {
+	filter := preferences.GetStringListWithFallback(importFilterPrefName, importFileFilter)
+	for _, ext := range append(lib.OfxFileExtensions, lib.QifFileExtension) {
+		found := false
+		for _, f := range filter {
+			if strings.EqualFold(f, ext) {
+				found = true
+			}
+		}
+		if !found {
+			filter = append(filter, ext)
+		}
+	}
+	return filter
+}
+
+func selectImportFile(selected func(fileName, name string)) {
 	fod := dialog.NewFileOpen(func(uc fyne.URIReadCloser, err error) {
 		if err == nil {
 			if uc == nil {
 				timedNotification(2000, "Warning", "No file selected")
 			} else {
+				uc.Close()
 				p := uc.URI().Path()
 				p = p[0 : len(p)-len(uc.URI().Name())]
-				n := uc.URI().Name()
 				if len(p) >= 2 {
 					preferences.PutString(importPathPrefName, p)
 				}
-				if err == nil {
-					count, err := importCSVTransactions(dataPath, uc.URI().Path())
-					if err != nil {
-						timedError(fmt.Sprintf("Failed to import CSV file %s\nError: %s", n, err))
-					} else {
-						s := fmt.Sprintf("%d %s(s) imported from file %s", count, t, n)
-						dataMapUpdated(s, dataPath, nil)
-						timedNotification(5000, "Successful import", s)
-					}
-				} else {
-					timedError(fmt.Sprintf("Failed to read file %s\nError: %s", uc.URI().Path(), err))
-				}
+				selected(uc.URI().Path(), uc.URI().Name())
 			}
+		} else {
+			timedError(fmt.Sprintf("Failed to read file\nError: %s", err))
 		}
 	}, window)
 	uri, err := storage.ListerForURI(storage.NewFileURI(preferences.GetStringWithFallback(importPathPrefName, "/")))
@@ -951,11 +1278,62 @@ func importTransactions(dataPath *parser.Path, extra string) {
 		uri, _ = storage.ListerForURI(storage.NewFileURI("/"))
 	}
 	fod.SetLocation(uri)
-	fod.SetFilter(storage.NewExtensionFileFilter(preferences.GetStringListWithFallback(importFilterPrefName, importFileFilter)))
+	fod.SetFilter(storage.NewExtensionFileFilter(importFilter()))
 	fod.Resize(fyne.NewSize(window.Canvas().Size().Width*0.8, window.Canvas().Size().Height*0.8))
 	fod.Show()
 }
 
+/**
+Export an account as a CSV, JSON or QIF file. The format is taken from the file name.
+CSV and JSON contain the transactions displayed (the current filter is applied) with the running balance.
+QIF contains all of the transactions and the opening balance.
+*/
+func exportTransactions(dataPath *parser.Path, extra string) {
+	user := dataPath.StringFirst()
+	acc, err := lib.FindUserAccount(user, extra)
+	if err != nil {
+		timedError(err.Error())
+		return
+	}
+	fsd := dialog.NewFileSave(func(uc fyne.URIWriteCloser, err error) {
+		if err != nil {
+			timedError(fmt.Sprintf("Failed to create file\nError: %s", err))
+			return
+		}
+		if uc == nil {
+			timedNotification(2000, "Warning", "No file selected")
+			return
+		}
+		defer uc.Close()
+		p := uc.URI().Path()
+		p = p[0 : len(p)-len(uc.URI().Name())]
+		if len(p) >= 2 {
+			preferences.PutString(exportPathPrefName, p)
+		}
+		format, err := lib.ExportFormatForFile(uc.URI().Name())
+		if err == nil {
+			if format == lib.ExportFormatQif {
+				err = lib.ExportQif(uc, acc, preferences.GetStringWithFallback(importQifOrderPrefName, lib.QifDateOrderDMY))
+			} else {
+				err = lib.ExportTransactions(uc, acc, format, lib.GetUserAccountFilter(user, acc.AccountName))
+			}
+		}
+		if err != nil {
+			timedError(fmt.Sprintf("Failed to export file %s\nError: %s", uc.URI().Name(), err))
+			return
+		}
+		timedNotification(5000, "Successful export", fmt.Sprintf("Account '%s' exported to file %s", acc.AccountName, uc.URI().Name()))
+	}, window)
+	uri, err := storage.ListerForURI(storage.NewFileURI(preferences.GetStringWithFallback(exportPathPrefName, preferences.GetStringWithFallback(importPathPrefName, "/"))))
+	if err == nil {
+		fsd.SetLocation(uri)
+	}
+	fsd.SetFileName(acc.AccountName + lib.ExportFileExtensions[0])
+	fsd.SetFilter(storage.NewExtensionFileFilter(lib.ExportFileExtensions))
+	fsd.Resize(fyne.NewSize(window.Canvas().Size().Width*0.8, window.Canvas().Size().Height*0.8))
+	fsd.Show()
+}
+
 func updateTransactionValue(dataPath *parser.Path, extra string) {
 	t := lib.GetNameFromNameMap(lib.IdTxTransactions, "Transactions")
 	data, err := parser.Find(jsonData.GetUserRoot(), dataPath)
@@ -976,19 +1354,24 @@ func updateTransactionValue(dataPath *parser.Path, extra string) {
 		func(m *gui.InputData) { // On OK
 			count := 0
 			vs := m.Get(lib.IdTxVal).Value
-			v, _ := strconv.ParseFloat(strings.TrimSpace(vs), 64)
-			if v < 0.00001 && deleteIfZero {
+			v, _ := lib.ParseMoney(vs)
+			if v == 0 && deleteIfZero {
 				dil := dialog.NewConfirm(fmt.Sprintf("REMOVE: %s", t), fmt.Sprintf("%s\n\nAre you sure?", txd.Description()), func(b bool) {
 					if b {
-						data.(parser.NodeC).Remove(txNode)
+						jsonData.RecordUndo(fmt.Sprintf("Remove '%s'", txd.Description()), dataPath.PathParent(), func() error {
+							return data.(parser.NodeC).Remove(txNode)
+						})
 						dataMapUpdated("Transaction removed", dataPath.PathParent(), nil)
 					}
 				}, window)
 				dil.Show()
 			} else {
-				for _, v := range m.Data() {
-					count = count + lib.UpdateNodeFromTranactionData(txNode, v.Id, v.Value)
-				}
+				jsonData.RecordUndo(fmt.Sprintf("Update '%s'", txd.Description()), dataPath.PathParent(), func() error {
+					for _, v := range m.Data() {
+						count = count + lib.UpdateNodeFromTranactionData(txNode, v.Id, v.Value)
+					}
+					return nil
+				})
 				if count > 0 {
 					dataMapUpdated("Transaction updated", dataPath.PathParent(), nil)
 				}
@@ -1003,15 +1386,15 @@ func updateTransactionValue(dataPath *parser.Path, extra string) {
 		}
 	})
 
-	d.Add(lib.IdTxVal, "Amount", txd.Val(), func(s string) (string, error) {
-		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	d.Add(lib.IdTxVal, "Amount", txd.Value().String(), func(s string) (string, error) {
+		v, err := lib.ParseMoney(s)
 		if err != nil {
 			return "", fmt.Errorf("is not a valid amount")
 		}
-		if v < 0.00001 && deleteIfZero {
+		if v == 0 && deleteIfZero {
 			return "Zero value will REMOVE this transaction", nil
 		}
-		if v < 0.0 {
+		if v < 0 {
 			return "", fmt.Errorf("cannot be less than 0.0")
 		}
 		return "", nil
@@ -1021,6 +1404,9 @@ func updateTransactionValue(dataPath *parser.Path, extra string) {
 		d.AddOptions(lib.IdTxType, lib.TX_TYPE_LIST_LABLES, string(txd.TxType()), lib.TX_TYPE_LIST_OPTIONS, func(s string) (string, error) {
 			return "", nil
 		})
+		d.Add(lib.IdTxCategory, "Category", txd.Category(), func(s string) (string, error) {
+			return "", nil
+		})
 	}
 
 	d.Show(window)
@@ -1167,6 +1553,42 @@ func linkAction(uid *parser.Path, urlStr string) {
 	}
 }
 
+/**
+Undo (or Redo) the last change to the data.
+Pending edits are committed first so Undo reverts them before any earlier change.
+dataMapUpdated is called by jsonData to re-load the tree and select the changed item.
+*/
+func undoRedo(redo bool) {
+	noteActivity()
+	if dataIsLocked() || jsonData == nil {
+		return
+	}
+	commitEdits()
+	gui.EditEntryListCache.Clear()
+	var err error
+	if redo {
+		err = jsonData.Redo()
+	} else {
+		err = jsonData.Undo()
+	}
+	if err != nil {
+		timedNotification(preferences.GetInt64WithFallback(copyDialogTimePrefName, 1500), oneOrTheOther(redo, "Redo", "Undo"), err.Error())
+	}
+}
+
+/**
+Commit the pending edits to the model so they can be undone in one step.
+Returns the number of items changed.
+*/
+func commitEdits() int {
+	count := 0
+	jsonData.RecordUndo("Edit Items", currentSelPath, func() error {
+		count = gui.EditEntryListCache.Commit(jsonData.GetDataRoot())
+		return nil
+	})
+	return count
+}
+
 func flipEditMode() {
 	gui.EditMode = !gui.EditMode
 	futureReleaseTheBeast(100, MAIN_THREAD_RE_MENU)
@@ -1279,6 +1701,320 @@ func searchStringTrailFromTo(trail *parser.Trail, ind ...int) string {
 	return strings.Trim(st.String(), ".")
 }
 
+/**
+Audit the hint values for duplicates, weak and stale values.
+The results are displayed in a separate window. Each row selects the hint in the tree.
+Un-committed edits are not audited.
+*/
+func passwordAudit() {
+	o := lib.NewAuditOptions()
+	o.Ignore = preferences.GetStringListWithFallback(auditIgnorePrefName, lib.DefaultAuditIgnore)
+	o.MinBits = preferences.GetFloat64WithFallback(auditMinBitsPrefName, o.MinBits)
+	o.MaxAge = time.Duration(preferences.GetInt64WithFallback(auditMaxAgePrefName, 365)) * 24 * time.Hour
+	o.ChangedAt = jsonData.LastChanged
+	if ts, ok := jsonData.GetTimeStamp(); ok {
+		o.Fallback = ts
+	}
+	results := jsonData.Audit(o)
+	log(fmt.Sprintf("Password Audit: %d issues", len(results)))
+	if auditWindow != nil {
+		auditWindow.Close()
+	}
+	auditWindow = gui.NewAuditWindow(selectTreeElement)
+	auditWindow.Show(600, 500, results)
+}
+
+/**
+Report totals by category for the selected account or, if an account is not selected, all of the user's accounts.
+The period defaults to the start of the year until today.
+*/
+func categoryReport() {
+	user := currentSelPath.StringFirst()
+	if user == "" {
+		logInformationDialog("Category Report", "A User needs to be selected")
+		return
+	}
+	account := ""
+	if currentSelPath.StringAt(UID_POS_TYPE) == lib.IdAssets {
+		account = currentSelPath.StringAt(UID_POS_PWHINT)
+	}
+	title := fmt.Sprintf("User '%s'", user)
+	accounts, err := lib.FindAllUserAccounts(user)
+	if account != "" {
+		title = fmt.Sprintf("Account '%s'", account)
+		var acc *lib.AccountData
+		acc, err = lib.FindUserAccount(user, account)
+		accounts = []*lib.AccountData{acc}
+	}
+	if err != nil {
+		logInformationDialog("Category Report", err.Error())
+		return
+	}
+	validDate := func(s string) (string, error) {
+		_, err := time.Parse(lib.DATE_FORMAT_TXN, strings.TrimSpace(s))
+		if err != nil {
+			return "", fmt.Errorf("format:%s", lib.DATE_FORMAT_TXN)
+		}
+		return "", nil
+	}
+	now := time.Now()
+	d := gui.NewInputDataWindow(
+		fmt.Sprintf("Category Report for %s", title),
+		"Enter the period and press OK",
+		func() {}, // On Cancel
+		func(m *gui.InputData) { // On OK
+			from, err := time.Parse(lib.DATE_FORMAT_TXN, strings.TrimSpace(m.GetString("from", "")))
+			if err != nil {
+				logInformationDialog("Category Report", fmt.Sprintf("Invalid 'From' date. Format:%s", lib.DATE_FORMAT_TXN))
+				return
+			}
+			to, err := time.Parse(lib.DATE_FORMAT_TXN, strings.TrimSpace(m.GetString("to", "")))
+			if err != nil {
+				logInformationDialog("Category Report", fmt.Sprintf("Invalid 'To' date. Format:%s", lib.DATE_FORMAT_TXN))
+				return
+			}
+			gui.NewModalCategoryReportDialog(window, lib.NewCategoryReport(title, accounts, from, to))
+		})
+	d.Add("from", "From", time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC).Format(lib.DATE_FORMAT_TXN), validDate)
+	d.Add("to", "To", now.Format(lib.DATE_FORMAT_TXN), validDate)
+	d.Show(window)
+	go d.Validate()
+}
+
+/**
+Edit the base currency (a preference) and the exchange rates (in the data).
+A rate is shown for each currency in the table, each currency used by the user's accounts and the base currency.
+An empty rate removes the currency from the table. A new currency can be added as CODE=rate.
+*/
+func exchangeRates(dataPath *parser.Path) {
+	rates := lib.GetExchangeRates(jsonData.GetDataRoot())
+	base := gui.GetBaseCurrency(preferences)
+	currencies := []string{base}
+	accounts, err := lib.FindAllUserAccounts(dataPath.StringFirst())
+	if err == nil {
+		currencies = append(currencies, lib.AccountCurrencies(accounts)...)
+	}
+	for c := range rates {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies[1:])
+	validRate := func(s string) (string, error) {
+		if strings.TrimSpace(s) == "" {
+			return "Not converted", nil
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || v <= 0 {
+			return "", fmt.Errorf("must be a number greater than 0")
+		}
+		return "", nil
+	}
+	d := gui.NewInputDataWindow(
+		"Exchange Rates",
+		fmt.Sprintf("Units of each currency for 1 %s", base),
+		func() {}, // On Cancel
+		func(m *gui.InputData) { // On OK
+			newBase := lib.NormaliseCurrency(m.GetString("base", base))
+			updated := make(lib.ExchangeRates)
+			for _, c := range currencies {
+				if v := m.GetFloat(c, 0); v > 0 {
+					updated[c] = v
+				}
+			}
+			if add := m.GetString("add", ""); strings.TrimSpace(add) != "" {
+				parts := strings.SplitN(add, "=", 2)
+				v, _ := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+				updated[lib.NormaliseCurrency(parts[0])] = v
+			}
+			if _, ok := updated[newBase]; !ok && len(updated) > 0 {
+				updated[newBase] = 1
+			}
+			if newBase != base {
+				gui.SetBaseCurrency(preferences, newBase)
+				futureReleaseTheBeast(100, MAIN_THREAD_RESELECT)
+			}
+			if !updated.Equal(rates) {
+				err := jsonData.SetExchangeRates(updated, dataPath)
+				if err != nil {
+					logInformationDialog("Exchange Rates", err.Error())
+				}
+			}
+		})
+	d.Add("base", "Base Currency", base, func(s string) (string, error) {
+		return "", lib.ValidateCurrency(s)
+	})
+	added := make(map[string]bool)
+	for _, c := range currencies {
+		if added[c] {
+			continue
+		}
+		added[c] = true
+		v := ""
+		if r, ok := rates[c]; ok {
+			v = strconv.FormatFloat(r, 'f', -1, 64)
+		}
+		d.Add(c, c, v, validRate)
+	}
+	d.Add("add", "Add (CODE=rate)", "", func(s string) (string, error) {
+		if strings.TrimSpace(s) == "" {
+			return "", nil
+		}
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("must be CODE=rate. E.g. CHF=1.12")
+		}
+		if err := lib.ValidateCurrency(parts[0]); err != nil {
+			return "", fmt.Errorf("code must be 3 letters")
+		}
+		if strings.TrimSpace(parts[1]) == "" {
+			return "", fmt.Errorf("must be CODE=rate. E.g. CHF=1.12")
+		}
+		return validRate(parts[1])
+	})
+	d.Show(window)
+	go d.Validate()
+}
+
+/**
+Find the recurring transactions that are due since they were last added.
+List them and add them to the accounts if confirmed.
+Called once after the data is loaded.
+*/
+func checkRecurringTransactions() {
+	now := time.Now()
+	due, errs := jsonData.FindDueTransactions(now)
+	for _, e := range errs {
+		log(fmt.Sprintf("Recurring transaction error: %s", e.Error()))
+	}
+	if len(due) == 0 {
+		return
+	}
+	var sb strings.Builder
+	for i, d := range due {
+		if i >= recurringListMax {
+			sb.WriteString(fmt.Sprintf("... and %d more\n", len(due)-i))
+			break
+		}
+		sb.WriteString(d.String())
+		sb.WriteString("\n")
+	}
+	for _, e := range errs {
+		sb.WriteString(fmt.Sprintf("\nNot added: %s", e.Error()))
+	}
+	log(fmt.Sprintf("Recurring transactions due: %d", len(due)))
+	dialog.NewConfirm("Recurring Transactions", fmt.Sprintf("The following are due:\n\n%s\nAdd them now?", sb.String()), func(ok bool) {
+		if ok {
+			count, err := jsonData.ApplyDueTransactions(now, currentSelPath)
+			if err != nil {
+				logInformationDialog("Recurring Transactions", err.Error())
+				return
+			}
+			log(fmt.Sprintf("Recurring transactions added: %d", count))
+		}
+	}, window).Show()
+}
+
+/**
+Add a recurring transaction template to an account. dataPath is the account (user|assets|account).
+*/
+func addRecurring(dataPath *parser.Path, extra string) {
+	d := gui.NewInputDataWindow(
+		fmt.Sprintf("Add recurring transaction to account '%s'", extra),
+		"Update the data and press OK",
+		func() {}, // On Cancel
+		func(m *gui.InputData) { // On OK
+			amount, _ := lib.ParseMoney(m.GetString(lib.IdTxVal, "0"))
+			start, _ := lib.ParseDateString(m.GetString(lib.IdRecurStart, lib.CurrentDateString()))
+			day, _ := strconv.Atoi(strings.TrimSpace(m.GetString(lib.IdRecurDay, "1")))
+			r, err := lib.NewRecurringTransaction(
+				m.GetString(lib.IdTxRef, "ref"),
+				amount,
+				lib.TransactionTypeEnum(m.GetString(lib.IdTxType, string(lib.TX_TYPE_DEB))),
+				m.GetString(lib.IdTxCategory, ""),
+				m.GetString(lib.IdRecurSchedule, lib.RECUR_MONTHLY),
+				day,
+				start)
+			if err == nil {
+				err = jsonData.AddRecurring(dataPath, r)
+			}
+			if err != nil {
+				logInformationDialog("Add recurring transaction", err.Error())
+			}
+		})
+
+	d.Add(lib.IdTxRef, "Reference", "ref", func(s string) (string, error) {
+		if strings.TrimSpace(s) == "" {
+			return "", fmt.Errorf("cannot be empty")
+		}
+		return "", nil
+	})
+
+	d.Add(lib.IdTxVal, "Amount", "0.1", func(s string) (string, error) {
+		v, err := lib.ParseMoney(s)
+		if err != nil {
+			return "", fmt.Errorf("is not a valid amount")
+		}
+		if v <= 0 {
+			return "", fmt.Errorf("cannot be 0.0 or less")
+		}
+		return "", nil
+	})
+
+	d.AddOptions(lib.IdTxType, lib.TX_TYPE_LIST_LABLES, string(lib.TX_TYPE_DEB), lib.TX_TYPE_LIST_OPTIONS, func(s string) (string, error) {
+		return "", nil
+	})
+
+	d.Add(lib.IdTxCategory, "Category", "", func(s string) (string, error) {
+		return "", nil
+	})
+
+	d.AddOptions(lib.IdRecurSchedule, lib.RECUR_SCHEDULE_LABELS, lib.RECUR_MONTHLY, lib.RECUR_SCHEDULE_OPTIONS, func(s string) (string, error) {
+		return "", nil
+	})
+
+	d.Add(lib.IdRecurDay, "Day of month", "1", func(s string) (string, error) {
+		v, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || v < 1 || v > 31 {
+			return "", fmt.Errorf("must be 1 to 31")
+		}
+		return "Monthly only", nil
+	})
+
+	d.Add(lib.IdRecurStart, "Start date", lib.CurrentDateString(), func(s string) (string, error) {
+		_, e := lib.ParseDateString(s)
+		if e != nil {
+			return "", fmt.Errorf("format:%s", lib.DATE_FORMAT_TXN)
+		}
+		return "", nil
+	})
+
+	d.Show(window)
+	go d.Validate()
+}
+
+/**
+Remove a recurring transaction template from an account. extra is the index of the template.
+*/
+func removeRecurring(dataPath *parser.Path, extra string) {
+	index, err := strconv.Atoi(extra)
+	if err != nil {
+		logInformationDialog("Remove recurring transaction", fmt.Sprintf("invalid index '%s'", extra))
+		return
+	}
+	desc := extra
+	acc, err := lib.FindUserAccount(dataPath.StringFirst(), dataPath.StringLast())
+	if err == nil && index >= 0 && index < len(acc.Recurring) {
+		desc = acc.Recurring[index].String()
+	}
+	dialog.NewConfirm("Remove recurring transaction", fmt.Sprintf("'%s'\nAre you sure?", desc), func(ok bool) {
+		if ok {
+			err := jsonData.RemoveRecurring(dataPath, index)
+			if err != nil {
+				logInformationDialog("Remove recurring transaction", err.Error())
+			}
+		}
+	}, window).Show()
+}
+
 func searchStringNodeName(node parser.NodeI) string {
 	if node == nil {
 		return "nil"
@@ -1287,6 +2023,47 @@ func searchStringNodeName(node parser.NodeI) string {
 	return n
 }
 
+/**
+Loading the data file failed. The user can retry, load the latest backup file or exit.
+Returns the backup file name to load or "" to retry. This method must not end until a button is pressed.
+*/
+func loadFailedDialog(fileName string, loadErr error, backupFileDef *lib.BackupFileDef) string {
+	done := make(chan string, 1)
+	latest := ""
+	if backupFileDef.IsRequired() {
+		l, _ := backupFileDef.ListFiles()
+		if len(l) > 0 {
+			latest = l[len(l)-1]
+		}
+	}
+	choice := "exit"
+	var d dialog.Dialog
+	buttons := container.NewHBox(widget.NewButton("Retry", func() {
+		choice = ""
+		d.Hide()
+	}))
+	if latest != "" {
+		buttons.Add(widget.NewButton("Use Backup", func() {
+			choice = latest
+			d.Hide()
+		}))
+	}
+	message := fmt.Sprintf("Failed to load data file '%s'.\n%s\n\nRetry: Try to load the file again", fileName, loadErr.Error())
+	if latest != "" {
+		message = fmt.Sprintf("%s\nUse Backup: Load the latest backup '%s'.\n    Saving will replace the data file", message, latest)
+	}
+	d = dialog.NewCustom("Load Data Error", "Exit", container.NewVBox(widget.NewLabel(message), container.NewCenter(buttons)), window)
+	d.SetOnClosed(func() {
+		done <- choice
+	})
+	d.Show()
+	c := <-done
+	if c == "exit" {
+		abortWithUsage(fmt.Sprintf("Failed to load data file %s. Error: %s", fileName, loadErr.Error()))
+	}
+	return c
+}
+
 /**
 Get the password to decrypt the loaded data contained in FileData
 */
@@ -1323,6 +2100,181 @@ func getPasswordAndDecrypt(fd *lib.FileData, message string, fail func(string))
 	}
 }
 
+/**
+Change the password used to encrypt the data file.
+The current password is checked against the password used to decrypt the data.
+The data is re-encrypted as it was last loaded or saved. Un-saved changes remain un-saved.
+*/
+func changePassword() {
+	gui.NewModalChangePasswordDialog(window, fmt.Sprintf("Change the password for '%s'", fileData.GetFileName()), true, func(ok bool, current, newPw string) {
+		if ok {
+			if !fileData.KeyMatches([]byte(current)) {
+				logInformationDialog("Change Password Error:", "Error Message:\n\n-- The current password is incorrect --\n\nPassword was not changed!\nPress OK to continue")
+				return
+			}
+			err := fileData.StoreContentEncrypted([]byte(newPw), func() {
+				timedNotification(preferences.GetInt64WithFallback(saveDialogTimePrefName, 3000), "Password changed", fileData.GetFileName())
+				futureReleaseTheBeast(500, MAIN_THREAD_RE_MENU)
+			})
+			if err != nil {
+				logInformationDialog("Change Password Error:", fmt.Sprintf("Error Message:\n-- %s --\nPassword may not be changed!\nPress OK to continue", err.Error()))
+			}
+		}
+	})
+}
+
+func noteActivity() {
+	atomic.StoreInt64(&lastActivity, time.Now().UnixNano())
+}
+
+func lastActivityTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&lastActivity))
+}
+
+func dataIsLocked() bool {
+	return atomic.LoadInt32(&isLocked) == 1
+}
+
+func setDataLocked(locked bool) {
+	if locked {
+		atomic.StoreInt32(&isLocked, 1)
+	} else {
+		atomic.StoreInt32(&isLocked, 0)
+	}
+}
+
+/**
+Clear the clipboard security.clipboardClearSeconds after a value is copied to it. 0 (the default) means never.
+The clipboard is only cleared if it still contains the value and nothing else has been copied since.
+Called by gui.CopyToClipboard (see SetOnCopy) so every copy is counted.
+*/
+func clearClipboardLater(value string) {
+	count := atomic.AddInt64(&clipboardCopyCount, 1)
+	seconds := preferences.GetInt64WithFallback(clipboardClearPrefName, 0)
+	if seconds <= 0 || value == "" {
+		return
+	}
+	go func() {
+		time.Sleep(time.Duration(seconds) * time.Second)
+		if count == atomic.LoadInt64(&clipboardCopyCount) && window.Clipboard().Content() == value {
+			window.Clipboard().SetContent("")
+			log(fmt.Sprintf("Clipboard cleared after %d seconds", seconds))
+		}
+	}()
+}
+
+/**
+Background thread. Lock the data when there has been no user activity for 'seconds'.
+0 or less (the default) means never lock. Only encrypted data is locked.
+*/
+func lockWhenIdle(seconds int64) {
+	if seconds <= 0 {
+		return
+	}
+	for {
+		time.Sleep(1000 * time.Millisecond)
+		if !dataIsLocked() && time.Since(lastActivityTime()) > time.Duration(seconds)*time.Second {
+			// Queue the lock once. lockData clears lockQueuedAt. Sent from a new thread so this thread never blocks.
+			if atomic.CompareAndSwapInt64(&lockQueuedAt, 0, time.Now().UnixNano()) {
+				log(fmt.Sprintf("Lock requested after %d seconds of inactivity", seconds))
+				go func() {
+					releaseTheBeast <- MAIN_THREAD_LOCK
+				}()
+			}
+		}
+	}
+}
+
+/**
+Dont call directly. Use:
+	futureReleaseTheBeast(0, MAIN_THREAD_LOCK)
+
+Pending edits are committed to the model. They remain un-saved (hasDataChanges).
+The model and the file content are then encrypted in memory and the key is forgotten.
+All content, popups and the search window are removed until the password is re-entered.
+*/
+func lockData() {
+	// Queued by the idle thread but there has been activity since (or the data cannot be locked). Start the idle time again.
+	queuedAt := atomic.SwapInt64(&lockQueuedAt, 0)
+	if queuedAt != 0 && lastActivityTime().UnixNano() > queuedAt {
+		return
+	}
+	if dataIsLocked() || jsonData == nil || !fileData.HasEncData() {
+		if queuedAt != 0 {
+			noteActivity()
+		}
+		return
+	}
+	if commitEdits() > 0 {
+		hasDataChanges = true
+	}
+	err := fileData.Lock([]byte(jsonData.ToJson()))
+	if err != nil {
+		log(fmt.Sprintf("Lock Error:'%s'", err.Error()))
+		return
+	}
+	gui.EditEntryListCache.Clear()
+	gui.StopOtpUpdates()
+	jsonData.ClearUndo() // The undo commands hold (decrypted) values
+	jsonData = nil
+	setDataLocked(true)
+	if searchWindow != nil {
+		searchWindow.Close()
+	}
+	if auditWindow != nil {
+		auditWindow.Close()
+	}
+	if importPreviewWindow != nil {
+		importPreviewWindow.Close()
+	}
+	overlays := append([]fyne.CanvasObject{}, window.Canvas().Overlays().List()...)
+	for _, o := range overlays {
+		o.Hide()
+		window.Canvas().Overlays().Remove(o)
+	}
+	window.Canvas().SetOnTypedKey(nil)
+	window.SetMainMenu(fyne.NewMainMenu(fyne.NewMenu("File")))
+	showLockScreen("")
+	log(fmt.Sprintf("Locked:'%s'", fileData.GetFileName()))
+}
+
+func showLockScreen(message string) {
+	if message == "" {
+		message = fmt.Sprintf("Data File: '%s' is locked", fileData.GetFileName())
+	}
+	window.SetTitle(fmt.Sprintf("Data File: [%s]. Locked", fileData.GetFileName()))
+	unlock := widget.NewButtonWithIcon("Unlock", theme.LoginIcon(), unlockData)
+	window.SetContent(container.NewCenter(container.NewVBox(widget.NewLabel(message), container.NewCenter(unlock))))
+}
+
+/**
+Ask for the password and unlock the data. The model (including un-saved changes) is restored
+and the tree is re-built. If the password is wrong the data remains locked.
+*/
+func unlockData() {
+	gui.NewModalPasswordDialog(window, "Enter the password to UNLOCK the file", "", func(ok bool, value string, nt lib.NodeAnnotationEnum) {
+		if !ok {
+			return
+		}
+		model, err := fileData.Unlock([]byte(value))
+		if err != nil {
+			log(fmt.Sprintf("Unlock Error:'%s'", err.Error()))
+			showLockScreen("Error: " + err.Error() + ". Please try again")
+			return
+		}
+		dr, err := lib.NewJsonData(model, dataMapUpdated)
+		if err != nil {
+			abortWithUsage(fmt.Sprintf("ERROR: Cannot process data in file '%s'.\n%s", fileData.GetFileName(), err))
+		}
+		jsonData = dr
+		atomic.StoreInt64(&lockQueuedAt, 0)
+		setDataLocked(false)
+		noteActivity()
+		log(fmt.Sprintf("Unlocked:'%s'", fileData.GetFileName()))
+		futureReleaseTheBeast(0, MAIN_THREAD_RELOAD_TREE)
+	})
+}
+
 func callbackAfterSave() {
 	timedNotification(preferences.GetInt64WithFallback(saveDialogTimePrefName, 3000), "Saved", fileData.GetFileName())
 	futureReleaseTheBeast(500, MAIN_THREAD_RE_MENU)
@@ -1341,7 +2293,7 @@ func commitAndSaveData(enc int, mustBeChanged bool) {
 		logInformationDialog("File Save", "There were no items to save!\n\nPress OK to continue")
 	} else {
 		if enc == SAVE_ENCRYPTED {
-			gui.NewModalPasswordDialog(window, "Enter the password to DECRYPT the file", "", func(ok bool, value string, nt lib.NodeAnnotationEnum) {
+			gui.NewModalChangePasswordDialog(window, "Enter the password to ENCRYPT the file", false, func(ok bool, current, value string) {
 				if ok {
 					if value != "" {
 						_, err := commitChangedItems()
@@ -1350,7 +2302,11 @@ func commitAndSaveData(enc int, mustBeChanged bool) {
 							return
 						}
 						err = fileData.StoreContentEncrypted([]byte(value), callbackAfterSave)
-						if err != nil {
+						if errors.Is(err, lib.ErrRemoteChanged) {
+							remoteChangedDialog(enc)
+						} else if errors.Is(err, lib.ErrFileChanged) {
+							fileChangedDialog(enc)
+						} else if err != nil {
 							logInformationDialog("Save Encrypted File Error:", fmt.Sprintf("Error Message:\n-- %s --\nFile may not be saved!\nPress OK to continue", err.Error()))
 						} else {
 							hasDataChanges = false
@@ -1371,7 +2327,11 @@ func commitAndSaveData(enc int, mustBeChanged bool) {
 			} else {
 				err = fileData.StoreContentUnEncrypted(callbackAfterSave)
 			}
-			if err != nil {
+			if errors.Is(err, lib.ErrRemoteChanged) {
+				remoteChangedDialog(enc)
+			} else if errors.Is(err, lib.ErrFileChanged) {
+				fileChangedDialog(enc)
+			} else if err != nil {
 				logInformationDialog("Save File Error:", fmt.Sprintf("Error Message:\n-- %s --\nFile may not be saved!\nPress OK to continue", err.Error()))
 			} else {
 				hasDataChanges = false
@@ -1380,6 +2340,181 @@ func commitAndSaveData(enc int, mustBeChanged bool) {
 	}
 }
 
+/**
+The remote data has changed since it was loaded so it was not saved. The user can:
+	Reload:    Discard the local changes and load the remote data.
+	Overwrite: Save the local data over the remote data.
+	Merge:     Add the remote changes to the local data. Save again to update the remote data.
+*/
+func remoteChangedDialog(enc int) {
+	var d dialog.Dialog
+	reload := widget.NewButton("Reload", func() {
+		d.Hide()
+		reloadData("Remote data changed")
+	})
+	overwrite := widget.NewButton("Overwrite", func() {
+		d.Hide()
+		log(fmt.Sprintf("Remote data changed. Overwrite:'%s'", fileData.GetFileName()))
+		fileData.IgnoreRemoteChanges()
+		commitAndSaveData(enc, false)
+	})
+	merge := widget.NewButton("Merge", func() {
+		d.Hide()
+		content, err := fileData.GetRemoteContent()
+		if err != nil {
+			logInformationDialog("Merge Remote Data Error:", fmt.Sprintf("Error Message:\n-- %s --\nFile was not saved\nPress OK to continue", err.Error()))
+			return
+		}
+		count, err := jsonData.MergeFrom(content, currentSelPath)
+		if err != nil {
+			logInformationDialog("Merge Remote Data Error:", fmt.Sprintf("Error Message:\n-- %s --\nFile was not saved\nPress OK to continue", err.Error()))
+			return
+		}
+		log(fmt.Sprintf("Remote data changed. Merged %d items:'%s'", count, fileData.GetFileName()))
+		logInformationDialog("Merge Remote Data", fmt.Sprintf("%d item(s) were added from the remote data.\nCheck the data and save again to update the remote data", count))
+	})
+	message := widget.NewLabel(fmt.Sprintf("'%s' has been changed by someone else since it was loaded.\nYour changes were NOT saved.\n\n"+
+		"Reload: Discard your changes and load the remote data\n"+
+		"Overwrite: Replace the remote data with your data\n"+
+		"Merge: Add the remote changes to your data", fileData.GetFileName()))
+	d = dialog.NewCustom("Remote Data Changed", "Cancel", container.NewVBox(message, container.NewCenter(container.NewHBox(reload, overwrite, merge))), window)
+	d.Show()
+}
+
+/**
+The data file has been changed by another program so it was not saved. The user can:
+	Reload:    Discard the local changes and load the changed data file.
+	Compare:   List the differences between the local data and the changed data file.
+	Overwrite: Save the local data over the changed data file.
+*/
+func fileChangedDialog(enc int) {
+	var d dialog.Dialog
+	reload := widget.NewButton("Reload", func() {
+		d.Hide()
+		reloadData("Data file changed")
+	})
+	compare := widget.NewButton("Compare", func() {
+		d.Hide()
+		compareWithFile(fileData.GetFileName())
+	})
+	overwrite := widget.NewButton("Overwrite", func() {
+		d.Hide()
+		log(fmt.Sprintf("Data file changed. Overwrite:'%s'", fileData.GetFileName()))
+		changeBanner.Hide()
+		fileWatcher.Accept(fileData.GetFileName())
+		commitAndSaveData(enc, false)
+	})
+	message := widget.NewLabel(fmt.Sprintf("'%s' has been changed by another program since it was loaded.\nYour changes were NOT saved.\n\n"+
+		"Reload: Discard your changes and load the changed file\n"+
+		"Compare: List the differences between your data and the changed file\n"+
+		"Overwrite: Replace the changed file with your data", fileData.GetFileName()))
+	d = dialog.NewCustom("Data File Changed", "Cancel", container.NewVBox(message, container.NewCenter(container.NewHBox(reload, compare, overwrite))), window)
+	d.Show()
+}
+
+/**
+Discard the changes and load the data again.
+*/
+func reloadData(reason string) {
+	log(fmt.Sprintf("%s. Reload:'%s'", reason, fileData.GetFileName()))
+	gui.EditEntryListCache.Clear()
+	hasDataChanges = false
+	futureReleaseTheBeast(0, MAIN_THREAD_LOAD)
+}
+
+/**
+Called by the file watcher when the data file or the backup directory is changed by another program.
+Our own saves are not reported. This is called on the watcher thread (or the saving thread) so the
+path is queued and the main thread shows it (MAIN_THREAD_FILE_CHANGED).
+*/
+func fileChangedExternally(path string) {
+	changedPathsMu.Lock()
+	changedPaths = append(changedPaths, path)
+	changedPathsMu.Unlock()
+	futureReleaseTheBeast(100, MAIN_THREAD_FILE_CHANGED)
+}
+
+/**
+Return the paths queued by fileChangedExternally and clear the queue.
+*/
+func takeChangedPaths() []string {
+	changedPathsMu.Lock()
+	defer changedPathsMu.Unlock()
+	paths := changedPaths
+	changedPaths = make([]string, 0)
+	return paths
+}
+
+/**
+A banner is shown so the user can carry on working. Only called by the main thread.
+A change to the data file is not replaced by a change to the backup directory (another instance saving changes both).
+*/
+func showFileChanged(path string) {
+	log(fmt.Sprintf("Changed by another program:'%s'", path))
+	if dataIsNotLoadedYet || fileData == nil {
+		return
+	}
+	if path == fileData.GetFileName() {
+		changeBanner.Show(fmt.Sprintf("The data file '%s' has been changed by another program. Your changes cannot be saved until you Reload or choose to Overwrite.", path),
+			&gui.BannerAction{Label: "Reload", Action: reloadChangedFile},
+			&gui.BannerAction{Label: "Compare", Action: func() { compareWithFile(path) }})
+		return
+	}
+	if changeBanner.IsShowing() {
+		return
+	}
+	changeBanner.Show(fmt.Sprintf("The backup directory '%s' has been changed by another program.", path),
+		&gui.BannerAction{Label: "Compare With Latest Backup", Action: func() { compareWithFile(fileData.GetLatestBackup()) }})
+}
+
+func reloadChangedFile() {
+	if countChangedItems() > 0 {
+		dialog.NewConfirm("Reload Data", "Discard your changes and load the changed data file?", func(ok bool) {
+			if ok {
+				reloadData("Data file changed")
+			}
+		}, window).Show()
+		return
+	}
+	reloadData("Data file changed")
+}
+
+/**
+List the differences between the data in memory and the data in a file (the data file or a backup).
+Changes that have not been committed (the entries being edited) are not included.
+*/
+func compareWithFile(fileName string) {
+	if fileName == "" {
+		logInformationDialog("Compare", "There is no file to compare with")
+		return
+	}
+	content, err := fileData.ReadStoredContent(fileName)
+	if err != nil {
+		logInformationDialog("Compare Error:", fmt.Sprintf("Error Message:\n-- %s --\nPress OK to continue", err.Error()))
+		return
+	}
+	results, err := jsonData.CompareWith(content)
+	if err != nil {
+		logInformationDialog("Compare Error:", fmt.Sprintf("Error Message:\n-- %s --\nPress OK to continue", err.Error()))
+		return
+	}
+	log(fmt.Sprintf("Compare with '%s'. Differences %d", fileName, len(results)))
+	if len(results) == 0 {
+		logInformationDialog("Compare", fmt.Sprintf("There are no differences between your data and\n'%s'", fileName))
+		return
+	}
+	var sb strings.Builder
+	for i, r := range results {
+		if i >= compareListMax {
+			sb.WriteString(fmt.Sprintf("... and %d more\n", len(results)-i))
+			break
+		}
+		sb.WriteString(r.String())
+		sb.WriteString("\n")
+	}
+	logInformationDialog("Compare", fmt.Sprintf("'Only here' is your data. 'Only in other' is\n'%s'\n\n%s", fileName, sb.String()))
+}
+
 func shouldClose() {
 	if !shouldCloseLock {
 		shouldCloseLock = true // shouldCloseLock is cleared in the saveChangesDialogAction.
@@ -1387,6 +2522,12 @@ func shouldClose() {
 		if searchWindow != nil {
 			searchWindow.Close()
 		}
+		if auditWindow != nil {
+			auditWindow.Close()
+		}
+		if importPreviewWindow != nil {
+			importPreviewWindow.Close()
+		}
 		count := countChangedItems()
 		if count > 0 {
 			d := dialog.NewConfirm("Close Warning", "There are unsaved changes\nDo you want to save them before closing?", saveChangesDialogAction, window)
@@ -1408,7 +2549,7 @@ func countChangedItems() int {
 }
 
 func commitChangedItems() (int, error) {
-	count := gui.EditEntryListCache.Commit(jsonData.GetDataRoot())
+	count := commitEdits()
 	jsonData.SetDateTime()
 	statusDisplay.SetUpdated(jsonData.GetTimeStampString())
 	c := jsonData.ToJson()